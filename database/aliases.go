@@ -0,0 +1,478 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTagNotFound      = errors.New("tag not found")
+	ErrInvalidTagRule   = errors.New("invalid tag rule")
+	errAliasIsCanonical = fmt.Errorf("%w: a tag cannot be an alias of itself", ErrInvalidTagRule)
+)
+
+// TagAlias makes a name stand for another tag: queries, manual edits and imports use the tag instead
+type TagAlias struct {
+	Alias     string    `json:"alias"`
+	TagID     int64     `json:"tag_id"`
+	Tag       string    `json:"tag"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TagImplication adds ImpliedTag to every image that gets Tag, such as a character implying its series
+type TagImplication struct {
+	TagID        int64     `json:"tag_id"`
+	Tag          string    `json:"tag"`
+	ImpliedTagID int64     `json:"implied_tag_id"`
+	ImpliedTag   string    `json:"implied_tag"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// ResolveTagAlias returns the name of the tag name is an alias of, or name itself
+func ResolveTagAlias(q queryRower, name string) (string, error) {
+	var canonical string
+	err := q.QueryRow(`
+		SELECT t.name FROM tag_aliases a JOIN tags t ON t.id = a.tag_id WHERE a.alias = ?
+	`, name).Scan(&canonical)
+	if err == sql.ErrNoRows {
+		return name, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve tag alias: %w", err)
+	}
+	return canonical, nil
+}
+
+// resolveAliasesTx adds the aliases among names to cache.aliases
+func resolveAliasesTx(tx *sql.Tx, names []string, cache *TagCache) error {
+	for start := 0; start < len(names); start += batchChunkSize {
+		chunk := names[start:min(start+batchChunkSize, len(names))]
+
+		args := make([]any, len(chunk))
+		for i, name := range chunk {
+			args[i] = name
+		}
+
+		rows, err := tx.Query(fmt.Sprintf(`
+			SELECT a.alias, t.name FROM tag_aliases a JOIN tags t ON t.id = a.tag_id WHERE a.alias IN (%s)
+		`, strings.TrimRight(strings.Repeat("?,", len(chunk)), ",")), args...)
+		if err != nil {
+			return fmt.Errorf("look up tag aliases: %w", err)
+		}
+		for rows.Next() {
+			var alias, canonical string
+			if err := rows.Scan(&alias, &canonical); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan tag alias: %w", err)
+			}
+			cache.aliases[alias] = canonical
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTagAliases lists every alias by name
+func GetTagAliases(db *sql.DB) ([]TagAlias, error) {
+	rows, err := db.Query(`
+		SELECT a.alias, a.tag_id, t.name, a.created_at
+		FROM tag_aliases a JOIN tags t ON t.id = a.tag_id
+		ORDER BY a.alias
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("get tag aliases: %w", err)
+	}
+	defer rows.Close()
+
+	aliases := []TagAlias{}
+	for rows.Next() {
+		var a TagAlias
+		if err := rows.Scan(&a.Alias, &a.TagID, &a.Tag, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan tag alias: %w", err)
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// CreateTagAlias makes alias stand for tagName, replacing an earlier alias of the same name.
+// A tag already called alias is merged into tagName: its images, removals, aliases,
+// implications and smart album filters move over and the tag is deleted.
+func CreateTagAlias(db *sql.DB, alias, tagName string) (*TagAlias, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Aliases never chain, an alias of an alias points at the final tag
+	tagName, err = ResolveTagAlias(tx, tagName)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagID int64
+	if err := tx.QueryRow(`SELECT id FROM tags WHERE name = ?`, tagName).Scan(&tagID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrTagNotFound, tagName)
+		}
+		return nil, fmt.Errorf("get tag: %w", err)
+	}
+	if alias == tagName {
+		return nil, errAliasIsCanonical
+	}
+
+	var oldID int64
+	err = tx.QueryRow(`SELECT id FROM tags WHERE name = ?`, alias).Scan(&oldID)
+	switch {
+	case err == nil:
+		if err := mergeTagTx(tx, oldID, tagID); err != nil {
+			return nil, err
+		}
+	case err != sql.ErrNoRows:
+		return nil, fmt.Errorf("get aliased tag: %w", err)
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+		INSERT INTO tag_aliases (alias, tag_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(alias) DO UPDATE SET tag_id = excluded.tag_id, created_at = excluded.created_at
+	`, alias, tagID, now)
+	if err != nil {
+		return nil, fmt.Errorf("insert tag alias: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &TagAlias{Alias: alias, TagID: tagID, Tag: tagName, CreatedAt: now}, nil
+}
+
+// mergeTagTx moves everything that refers to tag oldID over to tag newID and deletes oldID.
+// A tag the user added as either name stays a user tag, and model links to oldID are dropped
+// from images the user removed newID from.
+func mergeTagTx(tx *sql.Tx, oldID, newID int64) error {
+	statements := []string{
+		`INSERT INTO image_tags (image_id, tag_id, confidence, source, model)
+		 SELECT image_id, ?2, confidence, source, model FROM image_tags it
+		 WHERE tag_id = ?1 AND NOT (source = '` + TagSourceModel + `' AND EXISTS (
+		     SELECT 1 FROM image_tag_removals r WHERE r.image_id = it.image_id AND r.tag_id = ?2))
+		 ON CONFLICT(image_id, tag_id) DO UPDATE SET source = excluded.source, confidence = NULL, model = NULL
+		 WHERE excluded.source = '` + TagSourceUser + `'`,
+		`DELETE FROM image_tags WHERE tag_id = ?1`,
+		`INSERT OR IGNORE INTO image_tag_removals (image_id, tag_id, removed_at)
+		 SELECT image_id, ?2, removed_at FROM image_tag_removals r
+		 WHERE tag_id = ?1 AND NOT EXISTS (
+		     SELECT 1 FROM image_tags it WHERE it.image_id = r.image_id AND it.tag_id = ?2)`,
+		`DELETE FROM image_tag_removals WHERE tag_id = ?1`,
+		`UPDATE tag_aliases SET tag_id = ?2 WHERE tag_id = ?1`,
+		`UPDATE OR IGNORE tag_implications SET tag_id = ?2 WHERE tag_id = ?1`,
+		`UPDATE OR IGNORE tag_implications SET implied_tag_id = ?2 WHERE implied_tag_id = ?1`,
+		`DELETE FROM tag_implications WHERE tag_id = ?1 OR implied_tag_id = ?1 OR tag_id = implied_tag_id`,
+		`UPDATE tags SET favorite = TRUE WHERE id = ?2 AND (SELECT favorite FROM tags WHERE id = ?1)`,
+		`DELETE FROM tags WHERE id = ?1`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, oldID, newID); err != nil {
+			return fmt.Errorf("merge tag %d into %d: %w", oldID, newID, err)
+		}
+	}
+
+	return replaceSmartAlbumTagTx(tx, oldID, newID)
+}
+
+// replaceSmartAlbumTagTx rewrites the tag ID lists of smart album filters that mention oldID
+func replaceSmartAlbumTagTx(tx *sql.Tx, oldID, newID int64) error {
+	rows, err := tx.Query(`SELECT album_id, COALESCE(include_tag_ids, ''), COALESCE(exclude_tag_ids, '') FROM smart_album_filters`)
+	if err != nil {
+		return fmt.Errorf("get smart album filters: %w", err)
+	}
+
+	type filter struct {
+		albumID          int64
+		include, exclude string
+	}
+	var changed []filter
+	old, replacement := strconv.FormatInt(oldID, 10), strconv.FormatInt(newID, 10)
+	for rows.Next() {
+		var f filter
+		if err := rows.Scan(&f.albumID, &f.include, &f.exclude); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan smart album filters: %w", err)
+		}
+		include, includeChanged := replaceCSVEntry(f.include, old, replacement)
+		exclude, excludeChanged := replaceCSVEntry(f.exclude, old, replacement)
+		if includeChanged || excludeChanged {
+			changed = append(changed, filter{albumID: f.albumID, include: include, exclude: exclude})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, f := range changed {
+		_, err := tx.Exec(`UPDATE smart_album_filters SET include_tag_ids = ?, exclude_tag_ids = ? WHERE album_id = ?`,
+			f.include, f.exclude, f.albumID)
+		if err != nil {
+			return fmt.Errorf("update smart album filters: %w", err)
+		}
+	}
+	return nil
+}
+
+// replaceCSVEntry swaps old for replacement in a comma-separated list, dropping the duplicate it may create
+func replaceCSVEntry(csv, old, replacement string) (string, bool) {
+	parts := parseCSV(csv)
+	changed := false
+	seen := map[string]bool{}
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p == old {
+			p = replacement
+			changed = true
+		}
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, ","), changed
+}
+
+// DeleteTagAlias returns false when there was no such alias
+func DeleteTagAlias(db *sql.DB, alias string) (bool, error) {
+	result, err := db.Exec(`DELETE FROM tag_aliases WHERE alias = ?`, alias)
+	if err != nil {
+		return false, fmt.Errorf("delete tag alias: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetTagImplications lists every implication by tag name
+func GetTagImplications(db *sql.DB) ([]TagImplication, error) {
+	rows, err := db.Query(`
+		SELECT ti.tag_id, t.name, ti.implied_tag_id, it.name, ti.created_at
+		FROM tag_implications ti
+		JOIN tags t ON t.id = ti.tag_id
+		JOIN tags it ON it.id = ti.implied_tag_id
+		ORDER BY t.name, it.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("get tag implications: %w", err)
+	}
+	defer rows.Close()
+
+	implications := []TagImplication{}
+	for rows.Next() {
+		var ti TagImplication
+		if err := rows.Scan(&ti.TagID, &ti.Tag, &ti.ImpliedTagID, &ti.ImpliedTag, &ti.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan tag implication: %w", err)
+		}
+		implications = append(implications, ti)
+	}
+	return implications, rows.Err()
+}
+
+// CreateTagImplication makes tagName imply impliedName, both resolved through aliases.
+// It refuses implications that would lead back to tagName. Images already tagged are
+// not changed, see ApplyTagImplications.
+func CreateTagImplication(db *sql.DB, tagName, impliedName string) (*TagImplication, error) {
+	ti := &TagImplication{CreatedAt: time.Now().UTC()}
+	for _, t := range []struct {
+		name *string
+		id   *int64
+		dest *string
+	}{{&tagName, &ti.TagID, &ti.Tag}, {&impliedName, &ti.ImpliedTagID, &ti.ImpliedTag}} {
+		name, err := ResolveTagAlias(db, *t.name)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.QueryRow(`SELECT id FROM tags WHERE name = ?`, name).Scan(t.id); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("%w: %s", ErrTagNotFound, name)
+			}
+			return nil, fmt.Errorf("get tag: %w", err)
+		}
+		*t.dest = name
+	}
+
+	if ti.TagID == ti.ImpliedTagID {
+		return nil, fmt.Errorf("%w: a tag cannot imply itself", ErrInvalidTagRule)
+	}
+
+	// The implied tag must not already lead back to the tag
+	var cycle bool
+	err := db.QueryRow(`
+		WITH RECURSIVE reachable(tag_id) AS (
+			SELECT ?1
+			UNION
+			SELECT ti.implied_tag_id FROM tag_implications ti JOIN reachable r ON ti.tag_id = r.tag_id
+		)
+		SELECT EXISTS (SELECT 1 FROM reachable WHERE tag_id = ?2)
+	`, ti.ImpliedTagID, ti.TagID).Scan(&cycle)
+	if err != nil {
+		return nil, fmt.Errorf("check implication cycle: %w", err)
+	}
+	if cycle {
+		return nil, fmt.Errorf("%w: %s already implies %s", ErrInvalidTagRule, ti.ImpliedTag, ti.Tag)
+	}
+
+	_, err = db.Exec(`INSERT OR IGNORE INTO tag_implications (tag_id, implied_tag_id, created_at) VALUES (?, ?, ?)`,
+		ti.TagID, ti.ImpliedTagID, ti.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("insert tag implication: %w", err)
+	}
+	return ti, nil
+}
+
+// DeleteTagImplication returns false when there was no such implication. Links it added stay.
+func DeleteTagImplication(db *sql.DB, tagName, impliedName string) (bool, error) {
+	tagName, err := ResolveTagAlias(db, tagName)
+	if err != nil {
+		return false, err
+	}
+	impliedName, err = ResolveTagAlias(db, impliedName)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Exec(`
+		DELETE FROM tag_implications
+		WHERE tag_id = (SELECT id FROM tags WHERE name = ?) AND implied_tag_id = (SELECT id FROM tags WHERE name = ?)
+	`, tagName, impliedName)
+	if err != nil {
+		return false, fmt.Errorf("delete tag implication: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// applyImplications links every tag implied, directly or through other implications,
+// by the tags of the given images. Implied links copy the source and confidence of the
+// link that implied them, and tags the user removed from an image stay removed.
+func applyImplications(q execer, imageIDs []int64) error {
+	for start := 0; start < len(imageIDs); start += batchChunkSize {
+		chunk := imageIDs[start:min(start+batchChunkSize, len(imageIDs))]
+
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+
+		_, err := q.Exec(fmt.Sprintf(`
+			INSERT OR IGNORE INTO image_tags (image_id, tag_id, confidence, source, model)
+			WITH RECURSIVE implied(image_id, tag_id, confidence, source, model) AS (
+				SELECT image_id, tag_id, confidence, source, model FROM image_tags WHERE image_id IN (%s)
+				UNION
+				SELECT i.image_id, ti.implied_tag_id, i.confidence, i.source, i.model
+				FROM implied i JOIN tag_implications ti ON ti.tag_id = i.tag_id
+			)
+			SELECT image_id, tag_id, confidence, source, model FROM implied
+			WHERE NOT EXISTS (
+				SELECT 1 FROM image_tag_removals r WHERE r.image_id = implied.image_id AND r.tag_id = implied.tag_id
+			)
+			ORDER BY source = '%s' DESC, confidence IS NULL DESC, confidence DESC
+		`, strings.TrimRight(strings.Repeat("?,", len(chunk)), ","), TagSourceUser), args...)
+		if err != nil {
+			return fmt.Errorf("apply tag implications: %w", err)
+		}
+	}
+	return nil
+}
+
+// ApplyTagImplications adds the tags the current implications call for to the given images
+func ApplyTagImplications(db *sql.DB, imageIDs []int64) error {
+	return applyImplications(db, imageIDs)
+}
+
+// GetImageIDsWithTag returns the images outside the trash that have the tag
+func GetImageIDsWithTag(db *sql.DB, tagID int64) ([]int64, error) {
+	rows, err := db.Query(`
+		SELECT it.image_id FROM image_tags it JOIN images ON images.id = it.image_id
+		WHERE it.tag_id = ? AND images.deleted_at IS NULL
+		ORDER BY it.image_id
+	`, tagID)
+	if err != nil {
+		return nil, fmt.Errorf("get images with tag: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan image ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// impliedTagNamesTx returns the names of the tags implied by names or by the user's tags on the image
+func impliedTagNamesTx(tx *sql.Tx, imageID int64, names map[string]bool) (map[string]bool, error) {
+	args := []any{imageID}
+	for name := range names {
+		args = append(args, name)
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`
+		WITH RECURSIVE base(tag_id) AS (
+			SELECT tag_id FROM image_tags WHERE image_id = ? AND source = '%s'
+			UNION
+			SELECT id FROM tags WHERE name IN (%s)
+		), implied(tag_id) AS (
+			SELECT implied_tag_id FROM tag_implications WHERE tag_id IN (SELECT tag_id FROM base)
+			UNION
+			SELECT ti.implied_tag_id FROM tag_implications ti JOIN implied i ON ti.tag_id = i.tag_id
+		)
+		SELECT t.name FROM tags t JOIN implied i ON i.tag_id = t.id
+	`, TagSourceUser, strings.TrimRight(strings.Repeat("?,", len(names)), ",")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("get implied tags: %w", err)
+	}
+	defer rows.Close()
+
+	implied := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan implied tag: %w", err)
+		}
+		implied[name] = true
+	}
+	return implied, rows.Err()
+}
+
+// ResolveTagAliases resolves every name through ResolveTagAlias, dropping names that end up repeated
+func ResolveTagAliases(db *sql.DB, names []string) ([]string, error) {
+	seen := map[string]bool{}
+	var resolved []string
+	for _, name := range names {
+		canonical, err := ResolveTagAlias(db, name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[canonical] {
+			seen[canonical] = true
+			resolved = append(resolved, canonical)
+		}
+	}
+	return resolved, nil
+}
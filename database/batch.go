@@ -0,0 +1,287 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brayanMuniz/AGO/internal/images"
+)
+
+// Rows per multi-row statement, kept well under SQLite's bound parameter limit
+const batchChunkSize = 150
+
+// NewImage is an image row to insert together with its tags
+type NewImage struct {
+	Phash      string
+	SHA256     string
+	Filename   string
+	Width      int
+	Height     int
+	Provenance Provenance
+	Tags       []ImportedTag
+	// Source of the tag links, TagSourceModel when empty
+	Source string
+	// Model names the tagger that produced Tags, empty when unknown
+	Model string
+	// Colors is stored along with the row when set
+	Colors *images.ColorAnalysis
+}
+
+// TagCache remembers tag IDs, categories and aliases so a bulk import looks each tag up once.
+// It is not safe for concurrent use; give every import its own cache.
+type TagCache struct {
+	ids        map[string]int64
+	categories map[string]string
+	aliases    map[string]string
+}
+
+func NewTagCache() *TagCache {
+	return &TagCache{ids: map[string]int64{}, categories: map[string]string{}, aliases: map[string]string{}}
+}
+
+func (c *TagCache) forget(names []string) {
+	for _, name := range names {
+		delete(c.ids, name)
+		delete(c.categories, name)
+	}
+}
+
+// InsertImagesWithTags inserts images and all their tags in a single transaction.
+// An image that fails is rolled back on its own and its error returned at the same index in errs;
+// err is only set when the transaction as a whole could not be committed.
+func InsertImagesWithTags(db *sql.DB, newImages []NewImage, tagCategoryMap map[string]string, cache *TagCache) (errs []error, err error) {
+	if cache == nil {
+		cache = NewTagCache()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+
+	errs = make([]error, len(newImages))
+	var touched []string
+	for i, img := range newImages {
+		names, imgErr := insertImageSavepoint(tx, img, tagCategoryMap, cache)
+		touched = append(touched, names...)
+		errs[i] = imgErr
+	}
+
+	if err := tx.Commit(); err != nil {
+		// Tags created or changed in this transaction are gone, look them up again next time
+		cache.forget(touched)
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return errs, nil
+}
+
+// insertImageSavepoint inserts one image inside tx, rolling back only its own changes on failure.
+// It returns the tag names it created or changed in the cache.
+func insertImageSavepoint(tx *sql.Tx, img NewImage, tagCategoryMap map[string]string, cache *TagCache) ([]string, error) {
+	if _, err := tx.Exec(`SAVEPOINT image_insert`); err != nil {
+		return nil, fmt.Errorf("create savepoint: %w", err)
+	}
+
+	touched, err := insertImageTx(tx, img, tagCategoryMap, cache)
+	if err != nil {
+		cache.forget(touched)
+		if _, rollbackErr := tx.Exec(`ROLLBACK TO image_insert`); rollbackErr != nil {
+			return nil, fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		tx.Exec(`RELEASE image_insert`)
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`RELEASE image_insert`); err != nil {
+		return touched, fmt.Errorf("release savepoint: %w", err)
+	}
+	return touched, nil
+}
+
+func insertImageTx(tx *sql.Tx, img NewImage, tagCategoryMap map[string]string, cache *TagCache) ([]string, error) {
+	imageInsertStmt := `
+		INSERT INTO images (phash, sha256, filename, width, height, original_filename, source_folder, file_size, file_modified_at, imported_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	p := img.Provenance
+	result, err := tx.Exec(imageInsertStmt, img.Phash, nullIfEmpty(img.SHA256), img.Filename, img.Width, img.Height,
+		p.OriginalFilename, p.SourceFolder, p.FileSize, p.FileModifiedAt, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("insert image: %w", err)
+	}
+
+	imageID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("get last insert ID: %w", err)
+	}
+
+	if img.Colors != nil {
+		if err := insertColorsTx(tx, imageID, *img.Colors); err != nil {
+			return nil, err
+		}
+	}
+
+	source := img.Source
+	if source == "" {
+		source = TagSourceModel
+	}
+	return linkTagsTx(tx, imageID, img.Tags, tagCategoryMap, cache, source, img.Model)
+}
+
+// linkTagsTx resolves tag IDs through the cache, creating missing tags, and links them to imageID
+// along with the tags they imply. Existing model links get the new confidence and model; user links are left alone.
+func linkTagsTx(tx *sql.Tx, imageID int64, tags []ImportedTag, tagCategoryMap map[string]string, cache *TagCache, source, model string) ([]string, error) {
+	type link struct {
+		name       string
+		confidence *float64
+	}
+
+	tags, err := resolveImportedTagsTx(tx, tags, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []link
+	categories := map[string]string{}
+	var unknown []string
+	for _, importedTag := range tags {
+		tag := sanitizeTag(importedTag.Name)
+		if tag == "" {
+			continue
+		}
+
+		category := tagCategoryMap[tag]
+		if category == "" {
+			category = importedTag.Category
+		}
+
+		if _, seen := categories[tag]; !seen {
+			if _, cached := cache.ids[tag]; !cached {
+				unknown = append(unknown, tag)
+			}
+		}
+		categories[tag] = category
+		links = append(links, link{name: tag, confidence: importedTag.Confidence})
+	}
+
+	if err := loadTagIDs(tx, unknown, cache); err != nil {
+		return nil, err
+	}
+
+	var touched []string
+	for name, category := range categories {
+		id, ok := cache.ids[name]
+		if !ok {
+			result, err := tx.Exec(`INSERT INTO tags (name, category) VALUES (?, ?)`, name, category)
+			if err != nil {
+				return touched, fmt.Errorf("insert tag '%s': %w", name, err)
+			}
+			if id, err = result.LastInsertId(); err != nil {
+				return touched, fmt.Errorf("get tag ID for '%s': %w", name, err)
+			}
+			cache.ids[name] = id
+			cache.categories[name] = category
+			touched = append(touched, name)
+			continue
+		}
+
+		// A known category wins over an empty one, but a new one replaces the old
+		if category != "" && category != cache.categories[name] {
+			if _, err := tx.Exec(`UPDATE tags SET category = ? WHERE id = ?`, category, id); err != nil {
+				return touched, fmt.Errorf("update category of tag '%s': %w", name, err)
+			}
+			cache.categories[name] = category
+			touched = append(touched, name)
+		}
+	}
+
+	for start := 0; start < len(links); start += batchChunkSize {
+		chunk := links[start:min(start+batchChunkSize, len(links))]
+
+		args := make([]any, 0, len(chunk)*5)
+		for _, l := range chunk {
+			args = append(args, imageID, cache.ids[l.name], l.confidence, source, nullIfEmpty(model))
+		}
+
+		stmt := `INSERT INTO image_tags (image_id, tag_id, confidence, source, model) VALUES ` +
+			strings.TrimRight(strings.Repeat("(?, ?, ?, ?, ?),", len(chunk)), ",") + `
+			ON CONFLICT(image_id, tag_id) DO UPDATE SET confidence = excluded.confidence, model = excluded.model
+			WHERE image_tags.source = '` + TagSourceModel + `'`
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return touched, fmt.Errorf("link image to tags: %w", err)
+		}
+	}
+
+	return touched, applyImplications(tx, []int64{imageID})
+}
+
+// resolveImportedTagsTx renames tags that are aliases to the tag they stand for.
+// Their category is dropped so an alias never changes the category of its tag.
+func resolveImportedTagsTx(tx *sql.Tx, tags []ImportedTag, cache *TagCache) ([]ImportedTag, error) {
+	var unknown []string
+	for _, tag := range tags {
+		name := sanitizeTag(tag.Name)
+		_, isTag := cache.ids[name]
+		_, isAlias := cache.aliases[name]
+		if name != "" && !isTag && !isAlias {
+			unknown = append(unknown, name)
+		}
+	}
+	if err := resolveAliasesTx(tx, unknown, cache); err != nil {
+		return nil, err
+	}
+
+	resolved := make([]ImportedTag, len(tags))
+	for i, tag := range tags {
+		resolved[i] = tag
+		if canonical, ok := cache.aliases[sanitizeTag(tag.Name)]; ok {
+			resolved[i].Name = canonical
+			resolved[i].Category = ""
+		}
+	}
+	return resolved, nil
+}
+
+// loadTagIDs fills the cache with the IDs and categories of the existing tags among names
+func loadTagIDs(tx *sql.Tx, names []string, cache *TagCache) error {
+	for start := 0; start < len(names); start += batchChunkSize {
+		chunk := names[start:min(start+batchChunkSize, len(names))]
+
+		args := make([]any, len(chunk))
+		for i, name := range chunk {
+			args[i] = name
+		}
+
+		query := fmt.Sprintf(`SELECT id, name, COALESCE(category, '') FROM tags WHERE name IN (%s)`,
+			strings.TrimRight(strings.Repeat("?,", len(chunk)), ","))
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("look up tag IDs: %w", err)
+		}
+
+		for rows.Next() {
+			var id int64
+			var name, category string
+			if err := rows.Scan(&id, &name, &category); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan tag ID: %w", err)
+			}
+			cache.ids[name] = id
+			cache.categories[name] = category
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
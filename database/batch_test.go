@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	benchTagsPerImage = 100
+	benchVocabulary   = 5000
+	benchBatchSize    = 100
+)
+
+// BenchmarkInsertImagesWithTags inserts images of benchTagsPerImage tags each through the
+// batched, transactional import path, benchBatchSize images per call
+func BenchmarkInsertImagesWithTags(b *testing.B) {
+	db := openBenchDB(b)
+	newImages := generateBenchImages(b.N)
+	cache := NewTagCache()
+
+	b.ResetTimer()
+	for start := 0; start < len(newImages); start += benchBatchSize {
+		batch := newImages[start:min(start+benchBatchSize, len(newImages))]
+		errs, err := InsertImagesWithTags(db, batch, nil, cache)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, err := range errs {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkInsertImagesUnbatched inserts the same images the way imports did before batching,
+// an autocommit upsert, select and link per tag, as a baseline for BenchmarkInsertImagesWithTags
+func BenchmarkInsertImagesUnbatched(b *testing.B) {
+	db := openBenchDB(b)
+	newImages := generateBenchImages(b.N)
+
+	b.ResetTimer()
+	for _, img := range newImages {
+		if err := unbatchedInsert(db, img); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func openBenchDB(b *testing.B) *sql.DB {
+	b.Helper()
+	db, err := InitDB(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+func generateBenchImages(count int) []NewImage {
+	rng := rand.New(rand.NewSource(1))
+	newImages := make([]NewImage, count)
+	for i := range newImages {
+		tags := make([]ImportedTag, benchTagsPerImage)
+		for j := range tags {
+			confidence := rng.Float64()
+			tags[j] = ImportedTag{
+				Name:       fmt.Sprintf("tag_%d", rng.Intn(benchVocabulary)),
+				Category:   "general",
+				Confidence: &confidence,
+			}
+		}
+		phash := fmt.Sprintf("%016x", rng.Uint64())
+		newImages[i] = NewImage{Phash: phash, Filename: phash + ".png", Width: 512, Height: 512, Tags: tags}
+	}
+	return newImages
+}
+
+func unbatchedInsert(db *sql.DB, img NewImage) error {
+	result, err := db.Exec(`INSERT INTO images (phash, filename, width, height) VALUES (?, ?, ?, ?)`,
+		img.Phash, img.Filename, img.Width, img.Height)
+	if err != nil {
+		return err
+	}
+	imageID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range img.Tags {
+		_, err := db.Exec(`
+			INSERT INTO tags (name, category) VALUES (?, ?)
+			ON CONFLICT(name) DO UPDATE SET category=excluded.category`, tag.Name, tag.Category)
+		if err != nil {
+			return err
+		}
+
+		var tagID int64
+		if err := db.QueryRow(`SELECT id FROM tags WHERE name = ?`, tag.Name).Scan(&tagID); err != nil {
+			return err
+		}
+
+		_, err = db.Exec(`INSERT OR IGNORE INTO image_tags (image_id, tag_id, confidence) VALUES (?, ?, ?)`,
+			imageID, tagID, tag.Confidence)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
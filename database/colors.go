@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/internal/images"
+)
+
+// SetImageColors replaces the palette and monochrome flag of an image
+func SetImageColors(db *sql.DB, imageID int64, colors images.ColorAnalysis) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertColorsTx(tx, imageID, colors); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertColorsTx(tx *sql.Tx, imageID int64, colors images.ColorAnalysis) error {
+	if _, err := tx.Exec(`DELETE FROM image_colors WHERE image_id = ?`, imageID); err != nil {
+		return fmt.Errorf("clear image colors: %w", err)
+	}
+
+	for rank, c := range colors.Palette {
+		_, err := tx.Exec(`
+			INSERT INTO image_colors (image_id, rank, hex, l, a, b, weight)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, imageID, rank, c.Hex, c.L, c.A, c.B, c.Weight)
+		if err != nil {
+			return fmt.Errorf("insert image color: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE images SET monochrome = ? WHERE id = ?`, colors.Monochrome, imageID); err != nil {
+		return fmt.Errorf("set monochrome: %w", err)
+	}
+	return nil
+}
+
+// GetImageFilesWithoutColors returns the images outside the trash that have not been analyzed yet,
+// or every one of them when all is set
+func GetImageFilesWithoutColors(db *sql.DB, all bool) ([]ImageFile, error) {
+	rows, err := db.Query(`
+		SELECT id, phash, COALESCE(filename, ''), COALESCE(width, 0), COALESCE(height, 0)
+		FROM images
+		WHERE deleted_at IS NULL AND (? OR monochrome IS NULL)
+		ORDER BY id
+	`, all)
+	if err != nil {
+		return nil, fmt.Errorf("get images without colors: %w", err)
+	}
+	defer rows.Close()
+
+	var files []ImageFile
+	for rows.Next() {
+		var f ImageFile
+		if err := rows.Scan(&f.ID, &f.Phash, &f.Filename, &f.Width, &f.Height); err != nil {
+			return nil, fmt.Errorf("scan image file: %w", err)
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// AttachPalettes fills in the Palette of every image in imgs with one query
+func AttachPalettes(db *sql.DB, imgs []ImageResult) error {
+	if len(imgs) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*ImageResult, len(imgs))
+	args := make([]any, len(imgs))
+	for i := range imgs {
+		byID[imgs[i].ID] = &imgs[i]
+		args[i] = imgs[i].ID
+	}
+
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(imgs)), ",")
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT image_id, hex, l, a, b, weight
+		FROM image_colors
+		WHERE image_id IN (%s)
+		ORDER BY image_id, rank
+	`, placeholders), args...)
+	if err != nil {
+		return fmt.Errorf("get palettes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var c images.PaletteColor
+		if err := rows.Scan(&id, &c.Hex, &c.L, &c.A, &c.B, &c.Weight); err != nil {
+			return fmt.Errorf("scan palette color: %w", err)
+		}
+		if img := byID[id]; img != nil {
+			img.Palette = append(img.Palette, c)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ImageHash pairs an image ID with its stored phash
+type ImageHash struct {
+	ID    int
+	Phash string
+}
+
+// GetImageHashes returns the phash of every image outside the trash
+func GetImageHashes(db *sql.DB) ([]ImageHash, error) {
+	rows, err := db.Query(`SELECT id, phash FROM images WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("query image hashes: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []ImageHash
+	for rows.Next() {
+		var h ImageHash
+		if err := rows.Scan(&h.ID, &h.Phash); err != nil {
+			return nil, fmt.Errorf("scan image hash: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+// DuplicateFlag is a raw file that was left out of the gallery because it looks like an existing image
+type DuplicateFlag struct {
+	ID          int       `json:"id"`
+	SourcePath  string    `json:"source_path"`
+	Phash       string    `json:"phash"`
+	DuplicateOf string    `json:"duplicate_of"`
+	ImageID     *int      `json:"image_id"` // ID of the matching image once it has been imported
+	Distance    int       `json:"distance"`
+	FlaggedAt   time.Time `json:"flagged_at"`
+}
+
+func FlagDuplicate(db *sql.DB, sourcePath, phash, duplicateOf string, distance int) error {
+	_, err := db.Exec(`
+		INSERT INTO duplicate_flags (source_path, phash, duplicate_of, distance, flagged_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(source_path) DO UPDATE SET
+			phash = excluded.phash,
+			duplicate_of = excluded.duplicate_of,
+			distance = excluded.distance,
+			flagged_at = excluded.flagged_at`,
+		sourcePath, phash, duplicateOf, distance, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("flag duplicate: %w", err)
+	}
+	return nil
+}
+
+func ClearDuplicateFlag(db *sql.DB, sourcePath string) error {
+	_, err := db.Exec(`DELETE FROM duplicate_flags WHERE source_path = ?`, sourcePath)
+	return err
+}
+
+func GetDuplicateFlags(db *sql.DB) ([]DuplicateFlag, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.source_path, f.phash, f.duplicate_of, images.id, f.distance, f.flagged_at
+		FROM duplicate_flags f
+		LEFT JOIN images ON images.phash = f.duplicate_of
+		ORDER BY f.flagged_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query duplicate flags: %w", err)
+	}
+	defer rows.Close()
+
+	flags := []DuplicateFlag{}
+	for rows.Next() {
+		var flag DuplicateFlag
+		var imageID sql.NullInt64
+		if err := rows.Scan(&flag.ID, &flag.SourcePath, &flag.Phash, &flag.DuplicateOf, &imageID, &flag.Distance, &flag.FlaggedAt); err != nil {
+			return nil, fmt.Errorf("scan duplicate flag: %w", err)
+		}
+		if imageID.Valid {
+			id := int(imageID.Int64)
+			flag.ImageID = &id
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+// MergeImages folds the metadata of the retired images into keepID and deletes their rows.
+// The kept image gets the union of tags and manual albums, the highest rating, favorite if any
+// of them was a favorite, and the sum of likes. Album covers pointing at retired images are moved to keepID.
+func MergeImages(db *sql.DB, keepID int, retireIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, retireID := range retireIDs {
+		if retireID == keepID {
+			continue
+		}
+
+		statements := []string{
+			`INSERT OR IGNORE INTO image_tags (image_id, tag_id, confidence, source, model)
+			 SELECT ?, tag_id, confidence, source, model FROM image_tags WHERE image_id = ?`,
+			// A tag the user added to either image stays a user tag
+			`UPDATE image_tags SET source = '` + TagSourceUser + `'
+			 WHERE image_id = ?1 AND tag_id IN (
+			     SELECT tag_id FROM image_tags WHERE image_id = ?2 AND source = '` + TagSourceUser + `')`,
+			`INSERT OR IGNORE INTO album_images (album_id, image_id)
+			 SELECT album_id, ? FROM album_images WHERE image_id = ?`,
+			`UPDATE images SET
+			     rating = MAX(COALESCE(rating, 0), (SELECT COALESCE(rating, 0) FROM images WHERE id = ?2)),
+			     favorite = (COALESCE(favorite, 0) OR (SELECT COALESCE(favorite, 0) FROM images WHERE id = ?2)),
+			     like_count = COALESCE(like_count, 0) + (SELECT COALESCE(like_count, 0) FROM images WHERE id = ?2)
+			 WHERE id = ?1`,
+			`UPDATE albums SET cover_image_id = ? WHERE cover_image_id = ?`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt, keepID, retireID); err != nil {
+				return fmt.Errorf("merge image %d into %d: %w", retireID, keepID, err)
+			}
+		}
+
+		cleanup := []string{
+			`DELETE FROM image_tags WHERE image_id = ?`,
+			`DELETE FROM image_tag_removals WHERE image_id = ?`,
+			`DELETE FROM image_colors WHERE image_id = ?`,
+			`DELETE FROM album_images WHERE image_id = ?`,
+			`DELETE FROM images WHERE id = ?`,
+		}
+		for _, stmt := range cleanup {
+			if _, err := tx.Exec(stmt, retireID); err != nil {
+				return fmt.Errorf("retire image %d: %w", retireID, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit merge: %w", err)
+	}
+	return nil
+}
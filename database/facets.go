@@ -0,0 +1,43 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/brayanMuniz/AGO/internal/query"
+)
+
+// Facet is a tag and how many images of a result carry it
+type Facet struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// GetFacets counts the most common tags of each category across every image q matches,
+// keyed by stored category. Categories no image has a tag of map to an empty list.
+func GetFacets(db *sql.DB, q query.ImageQuery, categories []string, limit int, minConfidence float64) (map[string][]Facet, error) {
+	facets := make(map[string][]Facet, len(categories))
+	if len(categories) == 0 {
+		return facets, nil
+	}
+	for _, category := range categories {
+		facets[category] = []Facet{}
+	}
+
+	facetSQL, args := q.Facets(categories, limit, minConfidence)
+	rows, err := db.Query(facetSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("count facets: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category string
+		var facet Facet
+		if err := rows.Scan(&category, &facet.Name, &facet.Count); err != nil {
+			return nil, fmt.Errorf("scan facet: %w", err)
+		}
+		facets[category] = append(facets[category], facet)
+	}
+	return facets, rows.Err()
+}
@@ -6,63 +6,94 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	
-	"github.com/brayanMuniz/AGO/utils"
+	"github.com/brayanMuniz/AGO/internal/images"
 )
 
 var supportedExtensions = []string{".jpg", ".jpeg", ".png", ".webp", ".gif"}
 
-func InsertImageWithTags(db *sql.DB, phash string, tags []string, tagCategoryMap map[string]string, width, height int) error {
-	imagePath, err := FindImageFile(phash)
+// ImportedTag is a tag name with the tagger's confidence, nil for tags without a score
+type ImportedTag struct {
+	Name string
+	// Category reported by the tagger, used when the category map doesn't know the tag
+	Category   string
+	Confidence *float64
+}
+
+// InsertImageWithTags inserts the gallery file stored as name and all its tags in one transaction.
+// source is TagSourceModel for tagger output and TagSourceUser for tags typed in by hand,
+// model names the tagger that produced model tags, empty when unknown.
+func InsertImageWithTags(db *sql.DB, galleryDir, name string, tags []ImportedTag, tagCategoryMap map[string]string, width, height int, source, model string) error {
+	img, err := PrepareNewImage(db, galleryDir, name, width, height)
 	if err != nil {
-		return fmt.Errorf("image file not found for phash %s: %w", phash, err)
+		return err
 	}
+	img.Tags = tags
+	img.Source = source
+	img.Model = model
 
-	filename := filepath.Base(imagePath)
+	// A single image is cheap to analyze, one that fails is left for the colors job
+	if colors, err := images.AnalyzeColors(filepath.Join(galleryDir, img.Filename)); err != nil {
+		fmt.Printf("Failed to analyze colors of %s: %v\n", img.Filename, err)
+	} else {
+		img.Colors = &colors
+	}
 
-	var imageID int64
-	imageInsertStmt := `INSERT INTO images (phash, filename, width, height) VALUES (?, ?, ?, ?)`
-	result, err := db.Exec(imageInsertStmt, phash, filename, width, height)
+	errs, err := InsertImagesWithTags(db, []NewImage{img}, tagCategoryMap, nil)
 	if err != nil {
-		return fmt.Errorf("insert image: %w", err)
+		return err
 	}
-	imageID, err = result.LastInsertId()
+	return errs[0]
+}
+
+// PrepareNewImage reads what InsertImagesWithTags needs to know about a gallery file besides its tags.
+// name is the file name without extension, see images.GalleryName.
+// Colors are left out so the caller can decode a whole batch at once.
+func PrepareNewImage(db *sql.DB, galleryDir, name string, width, height int) (NewImage, error) {
+	imagePath, err := FindImageFile(galleryDir, name)
 	if err != nil {
-		return fmt.Errorf("get last insert ID: %w", err)
+		return NewImage{}, fmt.Errorf("image file not found for %s: %w", name, err)
 	}
 
-	for _, tag := range tags {
-		tag = sanitizeTag(tag)
-		if tag == "" {
-			continue
-		}
+	sha, err := images.HashFile(imagePath)
+	if err != nil {
+		return NewImage{}, err
+	}
 
-		category := tagCategoryMap[tag]
-
-		var tagID int64
-		tagInsertStmt := `
-			INSERT INTO tags (name, category) 
-			VALUES (?, ?)
-			ON CONFLICT(name) DO UPDATE SET category=excluded.category;
-		`
-		_, err := db.Exec(tagInsertStmt, tag, category)
-		if err != nil {
-			return fmt.Errorf("insert tag '%s': %w", tag, err)
-		}
+	provenance, err := getProvenance(db, name, imagePath)
+	if err != nil {
+		return NewImage{}, err
+	}
 
-		err = db.QueryRow(`SELECT id FROM tags WHERE name = ?`, tag).Scan(&tagID)
-		if err != nil {
-			return fmt.Errorf("get tag ID for '%s': %w", tag, err)
-		}
+	return NewImage{
+		Phash:      images.PhashFromName(name),
+		SHA256:     sha,
+		Filename:   filepath.Base(imagePath),
+		Width:      width,
+		Height:     height,
+		Provenance: provenance,
+	}, nil
+}
 
-		linkStmt := `INSERT OR IGNORE INTO image_tags (image_id, tag_id) VALUES (?, ?)`
-		_, err = db.Exec(linkStmt, imageID, tagID)
-		if err != nil {
-			return fmt.Errorf("link image to tag '%s': %w", tag, err)
-		}
+// LinkImportedTags creates any missing tags and links them to an existing image in one transaction.
+// Tags the user removed from the image are not linked again.
+func LinkImportedTags(db *sql.DB, imageID int64, tags []ImportedTag, tagCategoryMap map[string]string, model string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return nil
+	removed, err := getRemovedTagNames(tx, imageID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := linkTagsTx(tx, imageID, withoutTags(tags, removed), tagCategoryMap, NewTagCache(), TagSourceModel, model); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func sanitizeTag(tag string) string {
@@ -89,26 +120,36 @@ func UpdateImageFields(db *sql.DB, imageID int, updates map[string]any) error {
 	return err
 }
 
-// finds the first image file matching a phash with common extensions in the gallery folder.
-func FindImageFile(phash string) (string, error) {
+// finds the first image file stored as name with common extensions in the gallery folder.
+func FindImageFile(galleryDir, name string) (string, error) {
 	for _, ext := range supportedExtensions {
-		fullPath := filepath.Join("./gallery", phash+ext)
+		fullPath := filepath.Join(galleryDir, name+ext)
 		if _, err := os.Stat(fullPath); err == nil {
 			return fullPath, nil
 		}
 	}
 
-	return "", fmt.Errorf("no matching image file found for %s", phash)
+	return "", fmt.Errorf("no matching image file found for %s", name)
 }
 
+// Where an image_tags link came from
+const (
+	TagSourceModel = "model" // written by a tagger or tag file import
+	TagSourceUser  = "user"  // added by hand, never changed by re-tagging
+)
+
 type TagInfo struct {
-	Name     string `json:"name"`
-	Favorite bool   `json:"favorite"`
+	Name       string   `json:"name"`
+	Favorite   bool     `json:"favorite"`
+	Confidence *float64 `json:"confidence,omitempty"` // tagger score, absent for manual tags
+	Source     string   `json:"source"`
+	Model      string   `json:"model,omitempty"` // tagger model that produced a model tag, when known
 }
 
 type ImageResult struct {
 	ID       int                    `json:"id"`
 	Phash    string                 `json:"phash"`
+	SHA256   string                 `json:"sha256,omitempty"` // empty until the file has been hashed
 	Filename string                 `json:"filename"`
 	Width    int                    `json:"width"`
 	Height   int                    `json:"height"`
@@ -116,259 +157,75 @@ type ImageResult struct {
 	Likes    int                    `json:"likes"`
 	Rating   int                    `json:"rating"`
 	Tags     map[string][]TagInfo   `json:"tags"` // Grouped by category with favorite info
+	// Provenance, empty for images imported before it was recorded
+	OriginalFilename string     `json:"original_filename,omitempty"`
+	SourceFolder     string     `json:"source_folder,omitempty"`
+	FileSize         int64      `json:"file_size,omitempty"`
+	FileModifiedAt   *time.Time `json:"file_modified_at,omitempty"`
+	ImportedAt       *time.Time `json:"imported_at,omitempty"`
+	// Set when an integrity repair found the file gone from the gallery
+	MissingAt *time.Time `json:"missing_at,omitempty"`
+	// Set while the image is in the trash, see MarkImageDeleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Dominant colors, largest share first, see AttachPalettes. Monochrome is nil until analyzed.
+	Palette    []images.PaletteColor `json:"palette,omitempty"`
+	Monochrome *bool                 `json:"monochrome,omitempty"`
+	// Place in the order of the list the image was read for, see ScanSortedImage
+	SortKey interface{} `json:"-"`
 }
 
-func GetImagesByTags(db *sql.DB, tags []string) ([]ImageResult, error) {
-	if len(tags) == 0 {
-		return nil, fmt.Errorf("no tags provided")
-	}
-
-	placeholders := strings.TrimRight(strings.Repeat("?,", len(tags)), ",")
-	query := fmt.Sprintf(`
-	SELECT 
-		images.id,
-		images.phash,
-		images.filename,
-		images.width,
-		images.height,
-		images.favorite,
-		images.like_count,
-		images.rating
-	FROM images
-	JOIN image_tags ON images.id = image_tags.image_id
-	JOIN tags ON tags.id = image_tags.tag_id
-	WHERE tags.name IN (%s)
-	GROUP BY images.id
-	HAVING COUNT(DISTINCT tags.name) = ?
-	`, placeholders)
-
-	args := make([]any, len(tags)+1)
-	for i, tag := range tags {
-		args[i] = tag
-	}
-	args[len(tags)] = len(tags)
+// ImageColumns is the select list read by ScanImage, every query using it must select FROM images
+const ImageColumns = `images.id, images.phash, images.sha256, images.filename, images.width, images.height, images.favorite, images.like_count, images.rating,
+	images.original_filename, images.source_folder, images.file_size, images.file_modified_at, images.imported_at, images.missing_at, images.deleted_at, images.monochrome`
 
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []ImageResult
-	for rows.Next() {
-		var img ImageResult
-		err := rows.Scan(
-			&img.ID,
-			&img.Phash,
-			&img.Filename,
-			&img.Width,
-			&img.Height,
-			&img.Favorite,
-			&img.Likes,
-			&img.Rating,
-		)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, img)
-	}
-
-	return results, nil
-}
-
-func GetImagesByTagsPaginated(db *sql.DB, tags []string, page, limit int, sortBy string, seed string, includeCharacters, excludeCharacters, includeTags, excludeTags, includeExplicitness, excludeExplicitness, includeSeries, excludeSeries, includeArtists, excludeArtists []string) ([]ImageResult, int, error) {
-	if len(tags) == 0 {
-		return nil, 0, fmt.Errorf("no tags provided")
-	}
-
-	offset := (page - 1) * limit
-
-	// Build order by clause
-	var orderBy string
-	switch sortBy {
-	case "date_asc":
-		orderBy = "ORDER BY images.id ASC"
-	case "date_desc":
-		orderBy = "ORDER BY images.id DESC"
-	case "rating_desc":
-		orderBy = "ORDER BY images.rating DESC, images.id DESC"
-	case "rating_asc":
-		orderBy = "ORDER BY images.rating ASC, images.id ASC"
-	case "likes_desc":
-		orderBy = "ORDER BY images.like_count DESC, images.id DESC"
-	case "likes_asc":
-		orderBy = "ORDER BY images.like_count ASC, images.id ASC"
-	case "random":
-		if seed != "" {
-			// Use seeded random for reproducible results
-			orderBy = fmt.Sprintf("ORDER BY (images.id * %s) %% 1000000", seed)
-		} else {
-			orderBy = "ORDER BY RANDOM()"
-		}
-	default:
-		orderBy = "ORDER BY RANDOM()"
-	}
-
-	// Create placeholders for IN clause
-	placeholders := strings.TrimRight(strings.Repeat("?,", len(tags)), ",")
-
-	// Build filter conditions using shared utilities
-	var filterConditions []utils.FilterCondition
-	
-	// Character filters
-	if len(includeCharacters) > 0 {
-		filterConditions = append(filterConditions, utils.BuildCharacterFilterCondition(includeCharacters, true))
-	}
-	if len(excludeCharacters) > 0 {
-		filterConditions = append(filterConditions, utils.BuildCharacterFilterCondition(excludeCharacters, false))
-	}
-	
-	// Tag filters
-	if len(includeTags) > 0 {
-		filterConditions = append(filterConditions, utils.BuildGeneralTagFilterCondition(includeTags, true))
-	}
-	if len(excludeTags) > 0 {
-		filterConditions = append(filterConditions, utils.BuildGeneralTagFilterCondition(excludeTags, false))
-	}
-	
-	// Explicitness filters
-	if len(includeExplicitness) > 0 {
-		filterConditions = append(filterConditions, utils.BuildExplicitnessFilterCondition(includeExplicitness, true))
-	}
-	if len(excludeExplicitness) > 0 {
-		filterConditions = append(filterConditions, utils.BuildExplicitnessFilterCondition(excludeExplicitness, false))
-	}
-	
-	// Series filters
-	if len(includeSeries) > 0 {
-		filterConditions = append(filterConditions, utils.BuildSeriesFilterCondition(includeSeries, true))
-	}
-	if len(excludeSeries) > 0 {
-		filterConditions = append(filterConditions, utils.BuildSeriesFilterCondition(excludeSeries, false))
-	}
-	
-	// Artist filters
-	if len(includeArtists) > 0 {
-		filterConditions = append(filterConditions, utils.BuildArtistFilterCondition(includeArtists, true))
-	}
-	if len(excludeArtists) > 0 {
-		filterConditions = append(filterConditions, utils.BuildArtistFilterCondition(excludeArtists, false))
-	}
-	
-	// Combine filter conditions
-	var filterWhereClause string
-	var allFilterArgs []any
-	if len(filterConditions) > 0 {
-		whereClause, args := utils.CombineFilterConditions(filterConditions)
-		if whereClause != "" {
-			filterWhereClause = " AND " + strings.TrimPrefix(whereClause, "WHERE ")
-			allFilterArgs = args
-		}
-	}
-
-	// Get total count first
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(DISTINCT images.id)
-		FROM images
-		JOIN image_tags ON images.id = image_tags.image_id
-		JOIN tags ON tags.id = image_tags.tag_id
-		WHERE tags.name IN (%s) %s
-		GROUP BY images.id
-		HAVING COUNT(DISTINCT tags.name) = ?
-	`, placeholders, filterWhereClause)
-
-	args := make([]any, len(tags)+len(allFilterArgs)+1)
-	for i, tag := range tags {
-		args[i] = tag
-	}
-	for i, filterArg := range allFilterArgs {
-		args[len(tags)+i] = filterArg
-	}
-	args[len(tags)+len(allFilterArgs)] = len(tags)
-
-	// Count total matching images
-	var totalCount int
-	countRows, err := db.Query(countQuery, args...)
+// ScanImage reads a row selected with ImageColumns, followed by any extra columns into extra
+func ScanImage(row interface{ Scan(...any) error }, extra ...any) (ImageResult, error) {
+	var img ImageResult
+	var sha, originalFilename, sourceFolder sql.NullString
+	var fileSize sql.NullInt64
+	var fileModifiedAt, importedAt, missingAt, deletedAt sql.NullTime
+	var monochrome sql.NullBool
+	dest := []any{&img.ID, &img.Phash, &sha, &img.Filename, &img.Width, &img.Height, &img.Favorite, &img.Likes, &img.Rating,
+		&originalFilename, &sourceFolder, &fileSize, &fileModifiedAt, &importedAt, &missingAt, &deletedAt, &monochrome}
+	err := row.Scan(append(dest, extra...)...)
 	if err != nil {
-		return nil, 0, err
+		return img, err
 	}
-	defer countRows.Close()
 
-	for countRows.Next() {
-		totalCount++
+	img.SHA256 = sha.String
+	img.OriginalFilename = originalFilename.String
+	img.SourceFolder = sourceFolder.String
+	img.FileSize = fileSize.Int64
+	if fileModifiedAt.Valid {
+		img.FileModifiedAt = &fileModifiedAt.Time
 	}
-
-	// Get paginated results
-	query := fmt.Sprintf(`
-		SELECT 
-			images.id,
-			images.phash,
-			images.filename,
-			images.width,
-			images.height,
-			images.favorite,
-			images.like_count,
-			images.rating
-		FROM images
-		JOIN image_tags ON images.id = image_tags.image_id
-		JOIN tags ON tags.id = image_tags.tag_id
-		WHERE tags.name IN (%s) %s
-		GROUP BY images.id
-		HAVING COUNT(DISTINCT tags.name) = ?
-		%s
-		LIMIT ? OFFSET ?
-	`, placeholders, filterWhereClause, orderBy)
-
-	paginatedArgs := make([]any, len(tags)+len(allFilterArgs)+3)
-	for i, tag := range tags {
-		paginatedArgs[i] = tag
+	if importedAt.Valid {
+		img.ImportedAt = &importedAt.Time
 	}
-	for i, filterArg := range allFilterArgs {
-		paginatedArgs[len(tags)+i] = filterArg
+	if missingAt.Valid {
+		img.MissingAt = &missingAt.Time
 	}
-	paginatedArgs[len(tags)+len(allFilterArgs)] = len(tags)
-	paginatedArgs[len(tags)+len(allFilterArgs)+1] = limit
-	paginatedArgs[len(tags)+len(allFilterArgs)+2] = offset
-
-	rows, err := db.Query(query, paginatedArgs...)
-	if err != nil {
-		return nil, 0, err
+	if deletedAt.Valid {
+		img.DeletedAt = &deletedAt.Time
 	}
-	defer rows.Close()
-
-	var results []ImageResult
-	for rows.Next() {
-		var img ImageResult
-		err := rows.Scan(
-			&img.ID,
-			&img.Phash,
-			&img.Filename,
-			&img.Width,
-			&img.Height,
-			&img.Favorite,
-			&img.Likes,
-			&img.Rating,
-		)
-		if err != nil {
-			return nil, 0, err
-		}
-		results = append(results, img)
+	if monochrome.Valid {
+		img.Monochrome = &monochrome.Bool
 	}
 
-	return results, totalCount, nil
+	return img, nil
 }
 
 func GetImageByID(db *sql.DB, id int) (*ImageResult, error) {
 	query := `
-		SELECT id, phash, filename, width, height, favorite, rating, like_count
+		SELECT ` + ImageColumns + `
 		FROM images
 		WHERE id = ?
 	`
 
 	row := db.QueryRow(query, id)
 
-	var img ImageResult
-	if err := row.Scan(&img.ID, &img.Phash, &img.Filename, &img.Width, &img.Height, &img.Favorite, &img.Rating, &img.Likes); err != nil {
+	img, err := ScanImage(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // not found
 		}
@@ -381,12 +238,40 @@ func GetImageByID(db *sql.DB, id int) (*ImageResult, error) {
 	}
 	img.Tags = tags
 
-	return &img, nil
+	imgs := []ImageResult{img}
+	if err := AttachPalettes(db, imgs); err != nil {
+		return nil, err
+	}
+
+	return &imgs[0], nil
+}
+
+// GetImageByName returns the image whose gallery file is name plus an extension, nil when there is none
+func GetImageByName(db *sql.DB, name string) (*ImageResult, error) {
+	id, err := GetImageIDByName(db, name)
+	if err != nil || id == 0 {
+		return nil, err
+	}
+	return GetImageByID(db, int(id))
+}
+
+// GetImageBySHA256 returns nil when no image has the given content hash
+func GetImageBySHA256(db *sql.DB, sha string) (*ImageResult, error) {
+	var id int
+	err := db.QueryRow(`SELECT id FROM images WHERE sha256 = ?`, strings.ToLower(sha)).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find image: %w", err)
+	}
+	return GetImageByID(db, id)
 }
 
 func GetTagsByImageID(db *sql.DB, imageID int) (map[string][]TagInfo, error) {
 	query := `
-		SELECT tags.name, tags.category, tags.favorite
+		SELECT tags.name, tags.category, tags.favorite, image_tags.confidence,
+		       image_tags.source, COALESCE(image_tags.model, '')
 		FROM tags
 		JOIN image_tags ON tags.id = image_tags.tag_id
 		WHERE image_tags.image_id = ?
@@ -404,7 +289,9 @@ func GetTagsByImageID(db *sql.DB, imageID int) (map[string][]TagInfo, error) {
 		var name string
 		var category sql.NullString
 		var favorite bool
-		if err := rows.Scan(&name, &category, &favorite); err != nil {
+		var confidence sql.NullFloat64
+		var source, model string
+		if err := rows.Scan(&name, &category, &favorite, &confidence, &source, &model); err != nil {
 			return nil, fmt.Errorf("scan tag: %w", err)
 		}
 
@@ -416,6 +303,11 @@ func GetTagsByImageID(db *sql.DB, imageID int) (map[string][]TagInfo, error) {
 		tagInfo := TagInfo{
 			Name:     name,
 			Favorite: favorite,
+			Source:   source,
+			Model:    model,
+		}
+		if confidence.Valid {
+			tagInfo.Confidence = &confidence.Float64
 		}
 
 		tagsByCategory[cat] = append(tagsByCategory[cat], tagInfo)
@@ -424,9 +316,60 @@ func GetTagsByImageID(db *sql.DB, imageID int) (map[string][]TagInfo, error) {
 	return tagsByCategory, nil
 }
 
-func ImageExists(db *sql.DB, phash string) (bool, error) {
-	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM images WHERE phash = ?)`
-	err := db.QueryRow(query, phash).Scan(&exists)
-	return exists, err
+// FilterTagsByConfidence drops tagger tags scored below minConfidence, keeping manual tags
+func FilterTagsByConfidence(tagsByCategory map[string][]TagInfo, minConfidence float64) map[string][]TagInfo {
+	if minConfidence <= 0 {
+		return tagsByCategory
+	}
+
+	filtered := make(map[string][]TagInfo)
+	for category, tags := range tagsByCategory {
+		for _, tag := range tags {
+			if tag.Confidence == nil || *tag.Confidence >= minConfidence {
+				filtered[category] = append(filtered[category], tag)
+			}
+		}
+	}
+	return filtered
+}
+
+// GetUntaggedImages returns every image outside the trash that has no tags linked to it
+func GetUntaggedImages(db *sql.DB) ([]ImageFile, error) {
+	rows, err := db.Query(`
+		SELECT id, phash, COALESCE(filename, ''), COALESCE(width, 0), COALESCE(height, 0) FROM images
+		WHERE deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM image_tags WHERE image_tags.image_id = images.id)
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query untagged images: %w", err)
+	}
+	defer rows.Close()
+
+	var untagged []ImageFile
+	for rows.Next() {
+		var img ImageFile
+		if err := rows.Scan(&img.ID, &img.Phash, &img.Filename, &img.Width, &img.Height); err != nil {
+			return nil, fmt.Errorf("scan untagged image: %w", err)
+		}
+		untagged = append(untagged, img)
+	}
+	return untagged, rows.Err()
+}
+
+// GetImageIDByName returns the ID of the image whose gallery file is name plus an extension, or 0 when there is none
+func GetImageIDByName(db *sql.DB, name string) (int64, error) {
+	filenames := make([]any, len(supportedExtensions))
+	for i, ext := range supportedExtensions {
+		filenames[i] = name + ext
+	}
+
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(filenames)), ",")
+
+	var id int64
+	query := fmt.Sprintf(`SELECT id FROM images WHERE filename IN (%s)`, placeholders)
+	err := db.QueryRow(query, filenames...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
 }
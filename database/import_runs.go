@@ -0,0 +1,321 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Per-file outcomes of an import run
+const (
+	OutcomeImported        = "imported"
+	OutcomeRetagged        = "retagged"  // model tags of an existing image were updated
+	OutcomeOrganized       = "organized" // moved into the gallery
+	OutcomeSkippedExisting = "skipped_existing"
+	OutcomeRestored        = "restored" // a trashed image's file came back, its row shows again
+	OutcomeSkippedOther    = "skipped" // not an image, or nothing to do
+	OutcomeDuplicate       = "duplicate"
+	OutcomeMissingImage    = "missing_image"
+	OutcomeDecodeError     = "decode_error"
+	OutcomeDimensionError  = "dimension_error"
+	OutcomeInsertError     = "insert_error"
+	OutcomeMoveError       = "move_error"
+	OutcomeTaggerError     = "tagger_error"
+	OutcomeRepaired        = "repaired"
+	OutcomeAnalyzed        = "analyzed" // palette extracted
+	OutcomeRepairError     = "repair_error"
+)
+
+// Problems an integrity scan records, one entry per file and problem
+const (
+	IssueMissingFile       = "missing_file"       // row whose file is gone from the gallery
+	IssueOrphanFile        = "orphan_file"        // gallery file without a row
+	IssueStaleThumbnail    = "stale_thumbnail"    // thumbnail of a file that is gone
+	IssueDimensionMismatch = "dimension_mismatch" // stored width and height differ from the file
+	IssuePhashMismatch     = "phash_mismatch"     // stored phash differs from the file
+	IssueSHA256Mismatch    = "sha256_mismatch"    // stored content hash differs from the file, or was never stored
+)
+
+// FailedOutcomes are the outcomes a retry runs again
+var FailedOutcomes = []string{
+	OutcomeMissingImage, OutcomeDecodeError, OutcomeDimensionError,
+	OutcomeInsertError, OutcomeMoveError, OutcomeTaggerError, OutcomeRepairError,
+}
+
+// IntegrityIssues are the problems an integrity scan looks for
+var IntegrityIssues = []string{
+	IssueMissingFile, IssueOrphanFile, IssueStaleThumbnail, IssueDimensionMismatch, IssuePhashMismatch,
+	IssueSHA256Mismatch,
+}
+
+// IsIntegrityIssue reports whether outcome is one of IntegrityIssues
+func IsIntegrityIssue(outcome string) bool {
+	for _, issue := range IntegrityIssues {
+		if outcome == issue {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFailedOutcome reports whether outcome is one of FailedOutcomes
+func IsFailedOutcome(outcome string) bool {
+	for _, failed := range FailedOutcomes {
+		if outcome == failed {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportRun is one organize, import, tag, integrity or repair run. Status uses the job statuses.
+type ImportRun struct {
+	ID         int64          `json:"id"`
+	Type       string         `json:"type"`
+	JobID      *int64         `json:"job_id"`
+	RetryOf    *int64         `json:"retry_of"`
+	Model      string         `json:"model"` // tagger model recorded on the tags the run adds
+	Status     string         `json:"status"`
+	Error      string         `json:"error"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at"`
+	Summary    map[string]int `json:"summary"` // files per outcome
+}
+
+// ImportRunFile is the outcome of a single file in a run
+type ImportRunFile struct {
+	ID        int64  `json:"id"`
+	Path      string `json:"path"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error"`
+	RetriedBy *int64 `json:"retried_by"` // run that retried this entry
+}
+
+func CreateImportRun(db *sql.DB, runType string, retryOf *int64, model string) (*ImportRun, error) {
+	run := ImportRun{
+		Type:      runType,
+		RetryOf:   retryOf,
+		Model:     model,
+		Status:    JobQueued,
+		CreatedAt: time.Now().UTC(),
+		Summary:   map[string]int{},
+	}
+
+	result, err := db.Exec(`INSERT INTO import_runs (type, retry_of, model, status, created_at) VALUES (?, ?, ?, ?, ?)`,
+		run.Type, run.RetryOf, run.Model, run.Status, run.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("insert import run: %w", err)
+	}
+
+	run.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("get last insert ID: %w", err)
+	}
+
+	return &run, nil
+}
+
+func SetImportRunJob(db *sql.DB, runID, jobID int64) error {
+	_, err := db.Exec(`UPDATE import_runs SET job_id = ? WHERE id = ?`, jobID, runID)
+	if err != nil {
+		return fmt.Errorf("set import run job: %w", err)
+	}
+	return nil
+}
+
+func StartImportRun(db *sql.DB, runID int64) error {
+	_, err := db.Exec(`UPDATE import_runs SET status = ?, started_at = ? WHERE id = ?`, JobRunning, time.Now().UTC(), runID)
+	if err != nil {
+		return fmt.Errorf("start import run: %w", err)
+	}
+	return nil
+}
+
+func FinishImportRun(db *sql.DB, runID int64, status, errMsg string) error {
+	_, err := db.Exec(`UPDATE import_runs SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+		status, errMsg, time.Now().UTC(), runID)
+	if err != nil {
+		return fmt.Errorf("finish import run: %w", err)
+	}
+	return nil
+}
+
+// FinishQueuedImportRun finishes a run that never started, leaving runs that did as they are
+func FinishQueuedImportRun(db *sql.DB, runID int64, status, errMsg string) error {
+	_, err := db.Exec(`UPDATE import_runs SET status = ?, error = ?, finished_at = ? WHERE id = ? AND status = ?`,
+		status, errMsg, time.Now().UTC(), runID, JobQueued)
+	if err != nil {
+		return fmt.Errorf("finish queued import run: %w", err)
+	}
+	return nil
+}
+
+// MarkInterruptedImportRuns flags runs that were still pending when the server last stopped
+func MarkInterruptedImportRuns(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE import_runs SET status = ?, finished_at = ? WHERE status IN (?, ?)`,
+		JobInterrupted, time.Now().UTC(), JobQueued, JobRunning)
+	return err
+}
+
+func RecordImportRunFile(db *sql.DB, runID int64, path, outcome, errMsg string) error {
+	_, err := db.Exec(`INSERT INTO import_run_files (run_id, path, outcome, error) VALUES (?, ?, ?, ?)`,
+		runID, path, outcome, errMsg)
+	if err != nil {
+		return fmt.Errorf("record import run file: %w", err)
+	}
+	return nil
+}
+
+const importRunColumns = `id, type, job_id, retry_of, COALESCE(model, ''), status, error, created_at, started_at, finished_at`
+
+func scanImportRun(row interface{ Scan(...any) error }) (*ImportRun, error) {
+	var run ImportRun
+	var jobID, retryOf sql.NullInt64
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&run.ID, &run.Type, &jobID, &retryOf, &run.Model, &run.Status, &run.Error, &run.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if jobID.Valid {
+		run.JobID = &jobID.Int64
+	}
+	if retryOf.Valid {
+		run.RetryOf = &retryOf.Int64
+	}
+	if startedAt.Valid {
+		run.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+
+	return &run, nil
+}
+
+// GetImportRunByID returns the run with its summary, or nil when it does not exist
+func GetImportRunByID(db *sql.DB, id int64) (*ImportRun, error) {
+	row := db.QueryRow(`SELECT `+importRunColumns+` FROM import_runs WHERE id = ?`, id)
+	run, err := scanImportRun(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // not found
+		}
+		return nil, fmt.Errorf("get import run: %w", err)
+	}
+
+	if run.Summary, err = getImportRunSummary(db, run.ID); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// GetImportRuns returns the most recent runs first, optionally filtered by type
+func GetImportRuns(db *sql.DB, runType string, limit int) ([]ImportRun, error) {
+	query := `SELECT ` + importRunColumns + ` FROM import_runs`
+	args := []any{}
+	if runType != "" {
+		query += ` WHERE type = ?`
+		args = append(args, runType)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get import runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []ImportRun{}
+	for rows.Next() {
+		run, err := scanImportRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan import run: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range runs {
+		if runs[i].Summary, err = getImportRunSummary(db, runs[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+func getImportRunSummary(db *sql.DB, runID int64) (map[string]int, error) {
+	rows, err := db.Query(`SELECT outcome, COUNT(*) FROM import_run_files WHERE run_id = ? GROUP BY outcome`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("summarize import run: %w", err)
+	}
+	defer rows.Close()
+
+	summary := map[string]int{}
+	for rows.Next() {
+		var outcome string
+		var count int
+		if err := rows.Scan(&outcome, &count); err != nil {
+			return nil, fmt.Errorf("scan import run summary: %w", err)
+		}
+		summary[outcome] = count
+	}
+	return summary, rows.Err()
+}
+
+// GetImportRunFiles returns the files of a run, limited to outcomes when any are given
+func GetImportRunFiles(db *sql.DB, runID int64, outcomes []string) ([]ImportRunFile, error) {
+	query := `SELECT id, path, outcome, error, retried_by FROM import_run_files WHERE run_id = ?`
+	args := []any{runID}
+	if len(outcomes) > 0 {
+		query += fmt.Sprintf(` AND outcome IN (%s)`, strings.TrimRight(strings.Repeat("?,", len(outcomes)), ","))
+		for _, outcome := range outcomes {
+			args = append(args, outcome)
+		}
+	}
+	query += ` ORDER BY id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get import run files: %w", err)
+	}
+	defer rows.Close()
+
+	files := []ImportRunFile{}
+	for rows.Next() {
+		var file ImportRunFile
+		var retriedBy sql.NullInt64
+		if err := rows.Scan(&file.ID, &file.Path, &file.Outcome, &file.Error, &retriedBy); err != nil {
+			return nil, fmt.Errorf("scan import run file: %w", err)
+		}
+		if retriedBy.Valid {
+			file.RetriedBy = &retriedBy.Int64
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// MarkImportRunFilesRetried points the given entries at the run retrying them
+func MarkImportRunFilesRetried(db *sql.DB, fileIDs []int64, retryRunID int64) error {
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(fileIDs)), ",")
+	args := []any{retryRunID}
+	for _, id := range fileIDs {
+		args = append(args, id)
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`UPDATE import_run_files SET retried_by = ? WHERE id IN (%s)`, placeholders), args...)
+	if err != nil {
+		return fmt.Errorf("mark import run files retried: %w", err)
+	}
+	return nil
+}
@@ -2,10 +2,13 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 )
 
 func InitDB(filepath string) (*sql.DB, error) {
-	database, err := sql.Open("sqlite3", filepath)
+	// Background jobs write while requests are being served, so wait on locks instead of failing
+	database, err := sql.Open(driverName, filepath+"?_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
@@ -14,6 +17,10 @@ func InitDB(filepath string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err := migrateTables(database); err != nil {
+		return nil, err
+	}
+
 	return database, nil
 }
 
@@ -21,13 +28,23 @@ func createStartingTables(db *sql.DB) error {
 	_, err := db.Exec(`
 	CREATE TABLE IF NOT EXISTS images (
 	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    phash TEXT UNIQUE NOT NULL,
+	    phash TEXT NOT NULL,
+	    sha256 TEXT,
 	    filename TEXT,
 	    width INTEGER,
 	    height INTEGER,
 	    rating INTEGER DEFAULT 0 CHECK (rating BETWEEN 0 AND 5),
 	    favorite BOOLEAN DEFAULT FALSE,
-	    like_count INTEGER DEFAULT 0
+	    like_count INTEGER DEFAULT 0,
+	    original_filename TEXT,
+	    source_folder TEXT,
+	    file_size INTEGER,
+	    file_modified_at DATETIME,
+	    imported_at DATETIME,
+	    missing_at DATETIME,
+	    deleted_at DATETIME,
+	    trash_path TEXT,
+	    monochrome BOOLEAN
 	);
 
 	CREATE TABLE IF NOT EXISTS tags (
@@ -40,11 +57,51 @@ func createStartingTables(db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS image_tags (
 	    image_id INTEGER NOT NULL,
 	    tag_id INTEGER NOT NULL,
+	    confidence REAL,
+	    source TEXT NOT NULL DEFAULT 'model',
+	    model TEXT,
 	    PRIMARY KEY (image_id, tag_id),
 	    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
 	    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
 	);
 
+	CREATE TABLE IF NOT EXISTS image_tag_removals (
+	    image_id INTEGER NOT NULL,
+	    tag_id INTEGER NOT NULL,
+	    removed_at DATETIME NOT NULL,
+	    PRIMARY KEY (image_id, tag_id),
+	    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
+	    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS image_colors (
+	    image_id INTEGER NOT NULL,
+	    rank INTEGER NOT NULL,
+	    hex TEXT NOT NULL,
+	    l REAL NOT NULL,
+	    a REAL NOT NULL,
+	    b REAL NOT NULL,
+	    weight REAL NOT NULL,
+	    PRIMARY KEY (image_id, rank),
+	    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS tag_aliases (
+	    alias TEXT PRIMARY KEY,
+	    tag_id INTEGER NOT NULL,
+	    created_at DATETIME NOT NULL,
+	    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS tag_implications (
+	    tag_id INTEGER NOT NULL,
+	    implied_tag_id INTEGER NOT NULL,
+	    created_at DATETIME NOT NULL,
+	    PRIMARY KEY (tag_id, implied_tag_id),
+	    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+	    FOREIGN KEY (implied_tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+
 	CREATE TABLE IF NOT EXISTS albums (
 	    id INTEGER PRIMARY KEY AUTOINCREMENT,
 	    name TEXT NOT NULL,
@@ -69,10 +126,178 @@ func createStartingTables(db *sql.DB) error {
 	    favorite_only BOOLEAN,
 	    include_album_ids TEXT,
 	    exclude_album_ids TEXT,
+	    include_tag_match TEXT,
 	    FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE
 	);
 
+	CREATE TABLE IF NOT EXISTS jobs (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    type TEXT NOT NULL,
+	    status TEXT NOT NULL,
+	    total INTEGER DEFAULT 0,
+	    processed INTEGER DEFAULT 0,
+	    failed INTEGER DEFAULT 0,
+	    skipped INTEGER DEFAULT 0,
+	    current_file TEXT DEFAULT '',
+	    error TEXT DEFAULT '',
+	    created_at DATETIME NOT NULL,
+	    started_at DATETIME,
+	    finished_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS duplicate_flags (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    source_path TEXT UNIQUE NOT NULL,
+	    phash TEXT NOT NULL,
+	    duplicate_of TEXT NOT NULL,
+	    distance INTEGER NOT NULL,
+	    flagged_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS import_runs (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    type TEXT NOT NULL,
+	    job_id INTEGER,
+	    retry_of INTEGER,
+	    model TEXT DEFAULT '',
+	    status TEXT NOT NULL,
+	    error TEXT DEFAULT '',
+	    created_at DATETIME NOT NULL,
+	    started_at DATETIME,
+	    finished_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS import_run_files (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    run_id INTEGER NOT NULL,
+	    path TEXT NOT NULL,
+	    outcome TEXT NOT NULL,
+	    error TEXT DEFAULT '',
+	    retried_by INTEGER,
+	    FOREIGN KEY (run_id) REFERENCES import_runs(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_import_run_files_run ON import_run_files(run_id, outcome);
+
+	CREATE TABLE IF NOT EXISTS file_provenance (
+	    phash TEXT PRIMARY KEY,
+	    original_filename TEXT NOT NULL,
+	    source_folder TEXT NOT NULL,
+	    file_size INTEGER NOT NULL,
+	    file_modified_at DATETIME,
+	    organized_at DATETIME NOT NULL
+	);
 
 	`)
 	return err
 }
+
+// migrateTables adds columns introduced after a database was first created
+func migrateTables(db *sql.DB) error {
+	if err := dropPhashUnique(db); err != nil {
+		return err
+	}
+
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"image_tags", "confidence", "REAL"},
+		// Links from before sources were tracked cannot be told apart, treat them as model output
+		{"image_tags", "source", "TEXT NOT NULL DEFAULT 'model'"},
+		{"image_tags", "model", "TEXT"},
+		{"import_runs", "model", "TEXT DEFAULT ''"},
+		{"images", "original_filename", "TEXT"},
+		{"images", "source_folder", "TEXT"},
+		{"images", "file_size", "INTEGER"},
+		{"images", "file_modified_at", "DATETIME"},
+		{"images", "imported_at", "DATETIME"},
+		{"images", "missing_at", "DATETIME"},
+		{"images", "sha256", "TEXT"},
+		{"images", "deleted_at", "DATETIME"},
+		{"images", "trash_path", "TEXT"},
+		// NULL until the image's colors have been analyzed
+		{"images", "monochrome", "BOOLEAN"},
+		// any, all or a count, see utils.MatchMode. NULL means any.
+		{"smart_album_filters", "include_tag_match", "TEXT"},
+	}
+
+	for _, col := range columns {
+		if err := addColumnIfMissing(db, col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Indexes on migrated columns can only be created once the columns exist
+	_, err := db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_images_phash ON images(phash);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_images_sha256 ON images(sha256);
+	`)
+	if err != nil {
+		return fmt.Errorf("create image hash indexes: %w", err)
+	}
+	return nil
+}
+
+// dropPhashUnique rebuilds an images table created when phash was unique, so different
+// images that share a phash can each have a row. SQLite cannot drop a constraint in place.
+func dropPhashUnique(db *sql.DB) error {
+	var schema string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'images'`).Scan(&schema); err != nil {
+		return fmt.Errorf("read images schema: %w", err)
+	}
+	if !strings.Contains(schema, "phash TEXT UNIQUE NOT NULL") {
+		return nil
+	}
+
+	schema = strings.Replace(schema, "phash TEXT UNIQUE NOT NULL", "phash TEXT NOT NULL", 1)
+	schema = strings.Replace(schema, "images", "images_rebuild", 1)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		schema,
+		`INSERT INTO images_rebuild SELECT * FROM images`,
+		// Keep AUTOINCREMENT from handing out IDs of deleted images again
+		`UPDATE sqlite_sequence SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'images') WHERE name = 'images_rebuild'`,
+		`DROP TABLE images`,
+		`ALTER TABLE images_rebuild RENAME TO images`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("rebuild images table: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("scan column of %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImageFile is what an integrity scan compares against the file on disk
+type ImageFile struct {
+	ID       int64
+	Phash    string
+	SHA256   string
+	Filename string
+	Width    int
+	Height   int
+	Deleted  bool // in the trash, its file is not in the gallery
+}
+
+// GetImageFiles returns the file details of every image
+func GetImageFiles(db *sql.DB) ([]ImageFile, error) {
+	return getImageFiles(db, "1")
+}
+
+// GetUnhashedImageFiles returns the images outside the trash whose content hash was never
+// stored, rows imported before hashes were kept
+func GetUnhashedImageFiles(db *sql.DB) ([]ImageFile, error) {
+	return getImageFiles(db, "COALESCE(sha256, '') = '' AND deleted_at IS NULL")
+}
+
+func getImageFiles(db *sql.DB, condition string) ([]ImageFile, error) {
+	rows, err := db.Query(`
+		SELECT id, phash, COALESCE(sha256, ''), COALESCE(filename, ''), COALESCE(width, 0), COALESCE(height, 0), deleted_at IS NOT NULL
+		FROM images WHERE ` + condition + ` ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("get image files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []ImageFile
+	for rows.Next() {
+		var f ImageFile
+		if err := rows.Scan(&f.ID, &f.Phash, &f.SHA256, &f.Filename, &f.Width, &f.Height, &f.Deleted); err != nil {
+			return nil, fmt.Errorf("scan image file: %w", err)
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// GetImageFileByFilename returns the image stored as filename, or nil when there is none
+func GetImageFileByFilename(db *sql.DB, filename string) (*ImageFile, error) {
+	var f ImageFile
+	err := db.QueryRow(`
+		SELECT id, phash, COALESCE(sha256, ''), filename, COALESCE(width, 0), COALESCE(height, 0), deleted_at IS NOT NULL
+		FROM images WHERE filename = ?
+	`, filename).Scan(&f.ID, &f.Phash, &f.SHA256, &f.Filename, &f.Width, &f.Height, &f.Deleted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // not found
+		}
+		return nil, fmt.Errorf("get image file: %w", err)
+	}
+	return &f, nil
+}
+
+func SetImageDimensions(db *sql.DB, imageID int64, width, height int) error {
+	_, err := db.Exec(`UPDATE images SET width = ?, height = ? WHERE id = ?`, width, height, imageID)
+	if err != nil {
+		return fmt.Errorf("set image dimensions: %w", err)
+	}
+	return nil
+}
+
+// SetImageSHA256 stores the content hash of an image's file
+func SetImageSHA256(db *sql.DB, imageID int64, sha string) error {
+	_, err := db.Exec(`UPDATE images SET sha256 = ? WHERE id = ?`, sha, imageID)
+	if err != nil {
+		return fmt.Errorf("set image sha256: %w", err)
+	}
+	return nil
+}
+
+// SetImageMissing flags an image whose file is gone, or clears the flag once it is back
+func SetImageMissing(db *sql.DB, imageID int64, missing bool) error {
+	var missingAt any
+	if missing {
+		missingAt = time.Now().UTC()
+	}
+
+	_, err := db.Exec(`UPDATE images SET missing_at = ? WHERE id = ? AND (missing_at IS NULL) = ?`, missingAt, imageID, missing)
+	if err != nil {
+		return fmt.Errorf("set image missing: %w", err)
+	}
+	return nil
+}
+
+// RehashImage stores a new phash, content hash and filename for an image, carrying its file provenance along
+func RehashImage(db *sql.DB, imageID int64, oldName, newPhash, sha, filename string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE images SET phash = ?, sha256 = ?, filename = ? WHERE id = ?`, newPhash, sha, filename, imageID)
+	if err != nil {
+		return fmt.Errorf("update image hash: %w", err)
+	}
+	newName := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if _, err := tx.Exec(`UPDATE OR IGNORE file_provenance SET phash = ? WHERE phash = ?`, newName, oldName); err != nil {
+		return fmt.Errorf("update file provenance: %w", err)
+	}
+
+	return tx.Commit()
+}
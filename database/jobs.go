@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Job statuses
+const (
+	JobQueued      = "queued"
+	JobRunning     = "running"
+	JobCompleted   = "completed"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+	JobInterrupted = "interrupted" // server stopped while the job was queued or running
+)
+
+type Job struct {
+	ID          int64      `json:"id"`
+	Type        string     `json:"type"`
+	Status      string     `json:"status"`
+	Total       int        `json:"total"`
+	Processed   int        `json:"processed"`
+	Failed      int        `json:"failed"`
+	Skipped     int        `json:"skipped"`
+	CurrentFile string     `json:"current_file"`
+	Error       string     `json:"error"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+}
+
+// Finished reports whether the job has reached a terminal status
+func (j Job) Finished() bool {
+	switch j.Status {
+	case JobQueued, JobRunning:
+		return false
+	}
+	return true
+}
+
+func InsertJob(db *sql.DB, jobType string) (*Job, error) {
+	job := Job{
+		Type:      jobType,
+		Status:    JobQueued,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	result, err := db.Exec(`INSERT INTO jobs (type, status, created_at) VALUES (?, ?, ?)`, job.Type, job.Status, job.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("insert job: %w", err)
+	}
+
+	job.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("get last insert ID: %w", err)
+	}
+
+	return &job, nil
+}
+
+// SaveJob persists the status and progress counters of a job
+func SaveJob(db *sql.DB, job Job) error {
+	_, err := db.Exec(`
+		UPDATE jobs
+		SET status = ?, total = ?, processed = ?, failed = ?, skipped = ?,
+		    current_file = ?, error = ?, started_at = ?, finished_at = ?
+		WHERE id = ?`,
+		job.Status, job.Total, job.Processed, job.Failed, job.Skipped,
+		job.CurrentFile, job.Error, job.StartedAt, job.FinishedAt, job.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("save job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+// MarkInterruptedJobs flags jobs that were still pending when the server last stopped
+func MarkInterruptedJobs(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE jobs SET status = ?, finished_at = ? WHERE status IN (?, ?)`,
+		JobInterrupted, time.Now().UTC(), JobQueued, JobRunning)
+	return err
+}
+
+const jobColumns = `id, type, status, total, processed, failed, skipped, current_file, error, created_at, started_at, finished_at`
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Type, &job.Status, &job.Total, &job.Processed, &job.Failed, &job.Skipped,
+		&job.CurrentFile, &job.Error, &job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return &job, nil
+}
+
+func GetJobByID(db *sql.DB, id int64) (*Job, error) {
+	row := db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)
+	job, err := scanJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // not found
+		}
+		return nil, fmt.Errorf("get job: %w", err)
+	}
+	return job, nil
+}
+
+// GetJobs returns the most recent jobs first, optionally filtered by type
+func GetJobs(db *sql.DB, jobType string, limit int) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	args := []any{}
+	if jobType != "" {
+		query += ` WHERE type = ?`
+		args = append(args, jobType)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan job: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
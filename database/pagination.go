@@ -0,0 +1,18 @@
+package database
+
+// ScanSortedImage scans a row selected with ImageColumns, the extra columns and then utils.Sort.Column,
+// as query.ImageQuery.Select reads them
+func ScanSortedImage(row interface{ Scan(...any) error }, extra ...any) (ImageResult, error) {
+	var key any
+	img, err := ScanImage(row, append(extra, &key)...)
+	if b, ok := key.([]byte); ok {
+		key = string(b)
+	}
+	img.SortKey = key
+	return img, err
+}
+
+// SortPosition is where the image sits in the sort it was read with
+func (img ImageResult) SortPosition() (interface{}, int64) {
+	return img.SortKey, int64(img.ID)
+}
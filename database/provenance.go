@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Provenance is what we know about an image file before it was renamed into the gallery
+type Provenance struct {
+	OriginalFilename string
+	SourceFolder     string
+	FileSize         int64
+	FileModifiedAt   *time.Time
+}
+
+// RecordProvenance remembers where the gallery file stored as name came from until its row is created.
+// The key column is still called phash, names only differ from the phash after a collision.
+func RecordProvenance(db *sql.DB, name string, p Provenance) error {
+	_, err := db.Exec(`
+		INSERT INTO file_provenance (phash, original_filename, source_folder, file_size, file_modified_at, organized_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(phash) DO UPDATE SET
+			original_filename = excluded.original_filename,
+			source_folder = excluded.source_folder,
+			file_size = excluded.file_size,
+			file_modified_at = excluded.file_modified_at,
+			organized_at = excluded.organized_at
+	`, name, p.OriginalFilename, p.SourceFolder, p.FileSize, p.FileModifiedAt, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("record provenance: %w", err)
+	}
+	return nil
+}
+
+// getProvenance returns the recorded provenance for name, falling back to the gallery file itself
+// for images organized before provenance was tracked
+func getProvenance(db *sql.DB, name, imagePath string) (Provenance, error) {
+	var p Provenance
+	var modifiedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT original_filename, source_folder, file_size, file_modified_at
+		FROM file_provenance WHERE phash = ?
+	`, name).Scan(&p.OriginalFilename, &p.SourceFolder, &p.FileSize, &modifiedAt)
+	if err == nil {
+		if modifiedAt.Valid {
+			p.FileModifiedAt = &modifiedAt.Time
+		}
+		return p, nil
+	}
+	if err != sql.ErrNoRows {
+		return p, fmt.Errorf("get provenance: %w", err)
+	}
+
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return p, fmt.Errorf("stat image file: %w", err)
+	}
+	modTime := info.ModTime().UTC()
+
+	return Provenance{
+		OriginalFilename: filepath.Base(imagePath),
+		SourceFolder:     filepath.Dir(imagePath),
+		FileSize:         info.Size(),
+		FileModifiedAt:   &modTime,
+	}, nil
+}
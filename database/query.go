@@ -0,0 +1,40 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/brayanMuniz/AGO/internal/query"
+)
+
+// FindImages reads the page of images q asks for and counts every image it matches.
+// The page holds one row past its limit when more follow, see utils.FinishPage.
+// Columns defaults to ImageColumns.
+func FindImages(db *sql.DB, q query.ImageQuery) ([]ImageResult, int, error) {
+	if q.Columns == "" {
+		q.Columns = ImageColumns
+	}
+
+	countSQL, countArgs := q.Count()
+	var total int
+	if err := db.QueryRow(countSQL, countArgs...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count images: %w", err)
+	}
+
+	selectSQL, selectArgs := q.Select()
+	rows, err := db.Query(selectSQL, selectArgs...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []ImageResult
+	for rows.Next() {
+		img, err := ScanSortedImage(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan image: %w", err)
+		}
+		images = append(images, img)
+	}
+	return images, total, rows.Err()
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// RetagResult counts how the model tags of an image changed
+type RetagResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"` // new confidence or model
+	Dropped int `json:"dropped"`
+}
+
+func (r RetagResult) Changed() bool {
+	return r.Added+r.Updated+r.Dropped > 0
+}
+
+type currentLink struct {
+	tagID      int64
+	source     string
+	confidence *float64
+	model      string
+}
+
+// RetagImage replaces the model tags of an existing image with tags in one transaction.
+// Model tags missing from tags are dropped, while tags added by the user and tags the
+// user removed are left as they are.
+func RetagImage(db *sql.DB, imageID int64, tags []ImportedTag, tagCategoryMap map[string]string, model string, cache *TagCache) (RetagResult, error) {
+	var result RetagResult
+	if cache == nil {
+		cache = NewTagCache()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	removed, err := getRemovedTagNames(tx, imageID)
+	if err != nil {
+		return result, err
+	}
+
+	current, err := getCurrentLinks(tx, imageID)
+	if err != nil {
+		return result, err
+	}
+
+	tags, err = resolveImportedTagsTx(tx, tags, cache)
+	if err != nil {
+		return result, err
+	}
+
+	var keep []ImportedTag
+	wanted := map[string]bool{}
+	for _, tag := range withoutTags(tags, removed) {
+		name := sanitizeTag(tag.Name)
+		if name == "" || wanted[name] {
+			continue
+		}
+		wanted[name] = true
+
+		link, ok := current[name]
+		switch {
+		case !ok:
+			result.Added++
+		case link.source != TagSourceModel:
+			continue
+		case link.model != model || !sameConfidence(link.confidence, tag.Confidence):
+			result.Updated++
+		}
+		keep = append(keep, tag)
+	}
+
+	// Links the kept tags imply would only be added back
+	implied, err := impliedTagNamesTx(tx, imageID, wanted)
+	if err != nil {
+		return result, err
+	}
+
+	for name, link := range current {
+		if link.source != TagSourceModel || wanted[name] || implied[name] {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM image_tags WHERE image_id = ? AND tag_id = ?`, imageID, link.tagID); err != nil {
+			return result, fmt.Errorf("drop tag '%s': %w", name, err)
+		}
+		result.Dropped++
+	}
+
+	touched, err := linkTagsTx(tx, imageID, keep, tagCategoryMap, cache, TagSourceModel, model)
+	if err != nil {
+		cache.forget(touched)
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		cache.forget(touched)
+		return result, fmt.Errorf("commit transaction: %w", err)
+	}
+	return result, nil
+}
+
+func getCurrentLinks(tx *sql.Tx, imageID int64) (map[string]currentLink, error) {
+	rows, err := tx.Query(`
+		SELECT tags.name, image_tags.tag_id, image_tags.source, image_tags.confidence, COALESCE(image_tags.model, '')
+		FROM image_tags
+		JOIN tags ON tags.id = image_tags.tag_id
+		WHERE image_tags.image_id = ?
+	`, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("get image tags: %w", err)
+	}
+	defer rows.Close()
+
+	links := map[string]currentLink{}
+	for rows.Next() {
+		var name string
+		var link currentLink
+		var confidence sql.NullFloat64
+		if err := rows.Scan(&name, &link.tagID, &link.source, &confidence, &link.model); err != nil {
+			return nil, fmt.Errorf("scan image tag: %w", err)
+		}
+		if confidence.Valid {
+			link.confidence = &confidence.Float64
+		}
+		links[name] = link
+	}
+	return links, rows.Err()
+}
+
+// getRemovedTagNames returns the tags the user removed from an image
+func getRemovedTagNames(tx *sql.Tx, imageID int64) (map[string]bool, error) {
+	rows, err := tx.Query(`
+		SELECT tags.name FROM image_tag_removals
+		JOIN tags ON tags.id = image_tag_removals.tag_id
+		WHERE image_tag_removals.image_id = ?
+	`, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("get removed tags: %w", err)
+	}
+	defer rows.Close()
+
+	removed := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan removed tag: %w", err)
+		}
+		removed[name] = true
+	}
+	return removed, rows.Err()
+}
+
+func withoutTags(tags []ImportedTag, names map[string]bool) []ImportedTag {
+	if len(names) == 0 {
+		return tags
+	}
+
+	kept := make([]ImportedTag, 0, len(tags))
+	for _, tag := range tags {
+		if !names[sanitizeTag(tag.Name)] {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+func sameConfidence(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"database/sql"
+	"hash/fnv"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is SQLite with the functions queries here rely on
+const driverName = "sqlite3_ago"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("shuffle_rank", shuffleRank, true)
+		},
+	})
+}
+
+// shuffleRank places an image in the shuffle seed picks. Every image gets an unrelated,
+// evenly spread rank, and the same seed always gives the same order.
+func shuffleRank(seed string, id int64) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+
+	// splitmix64 finalizer
+	x := h.Sum64() + uint64(id)*0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return int64(x >> 1)
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 )
 
 type Tag struct {
@@ -14,17 +15,21 @@ type Tag struct {
 	IsFavorite bool   `json:"isFavorite"`
 }
 
-func GetTagsByCategory(db *sql.DB, category string) ([]Tag, error) {
+// GetTagsByCategory lists the tags of a category with their image counts.
+// Links scored below minConfidence by the tagger are left out of the counts.
+func GetTagsByCategory(db *sql.DB, category string, minConfidence float64) ([]Tag, error) {
 	rows, err := db.Query(`
         SELECT t.id, t.name, t.category,
                COUNT(it.image_id) as image_count,
                COALESCE(t.favorite, false) as is_favorite
         FROM tags t
         LEFT JOIN image_tags it ON t.id = it.tag_id
+            AND (it.confidence IS NULL OR it.confidence >= ?)
+            AND it.image_id NOT IN (SELECT id FROM images WHERE deleted_at IS NOT NULL)
         WHERE t.category = ?
         GROUP BY t.id, t.name, t.category, t.favorite
         ORDER BY t.name
-    `, category)
+    `, minConfidence, category)
 	if err != nil {
 		return nil, err
 	}
@@ -41,23 +46,32 @@ func GetTagsByCategory(db *sql.DB, category string) ([]Tag, error) {
 	return tags, rows.Err()
 }
 
+// AddTagToImage links a tag, or the tag it is an alias of, and the tags it implies to an image
 func AddTagToImage(db *sql.DB, imageID int, tagName string, category string) error {
 	tagName = strings.TrimSpace(tagName)
 	if tagName == "" {
 		return fmt.Errorf("tag name is empty")
 	}
 
-	// Insert tag into `tags` table if it doesn't exist
-	tagInsert := `
-		INSERT INTO tags (name, category)
-		VALUES (?, ?)
-		ON CONFLICT(name) DO UPDATE SET category=excluded.category;
-	`
-	_, err := db.Exec(tagInsert, tagName, category)
+	canonical, err := ResolveTagAlias(db, tagName)
 	if err != nil {
-		return fmt.Errorf("insert tag: %w", err)
+		return err
 	}
 
+	// Insert tag into `tags` table if it doesn't exist, an alias always points at an existing one
+	if canonical == tagName {
+		tagInsert := `
+			INSERT INTO tags (name, category)
+			VALUES (?, ?)
+			ON CONFLICT(name) DO UPDATE SET category=excluded.category;
+		`
+		_, err = db.Exec(tagInsert, tagName, category)
+		if err != nil {
+			return fmt.Errorf("insert tag: %w", err)
+		}
+	}
+	tagName = canonical
+
 	// Get tag ID
 	var tagID int
 	err = db.QueryRow(`SELECT id FROM tags WHERE name = ?`, tagName).Scan(&tagID)
@@ -65,19 +79,34 @@ func AddTagToImage(db *sql.DB, imageID int, tagName string, category string) err
 		return fmt.Errorf("get tag id: %w", err)
 	}
 
-	// Insert into `image_tags`
-	linkInsert := `INSERT OR IGNORE INTO image_tags (image_id, tag_id) VALUES (?, ?)`
-	_, err = db.Exec(linkInsert, imageID, tagID)
+	// Insert into `image_tags`. A model link becomes a manual one, so confidence filters
+	// no longer hide it and re-tagging leaves it alone.
+	linkInsert := `
+		INSERT INTO image_tags (image_id, tag_id, source) VALUES (?, ?, ?)
+		ON CONFLICT(image_id, tag_id) DO UPDATE SET source = excluded.source, confidence = NULL, model = NULL
+	`
+	_, err = db.Exec(linkInsert, imageID, tagID, TagSourceUser)
 	if err != nil {
 		return fmt.Errorf("link tag to image: %w", err)
 	}
 
-	return nil
+	// Adding the tag back undoes an earlier removal
+	_, err = db.Exec(`DELETE FROM image_tag_removals WHERE image_id = ? AND tag_id = ?`, imageID, tagID)
+	if err != nil {
+		return fmt.Errorf("clear tag removal: %w", err)
+	}
+
+	return ApplyTagImplications(db, []int64{int64(imageID)})
 }
 
 func RemoveTagFromImage(db *sql.DB, imageID int, tagName string) error {
+	tagName, err := ResolveTagAlias(db, tagName)
+	if err != nil {
+		return err
+	}
+
 	var tagID int
-	err := db.QueryRow(`SELECT id FROM tags WHERE name = ?`, tagName).Scan(&tagID)
+	err = db.QueryRow(`SELECT id FROM tags WHERE name = ?`, tagName).Scan(&tagID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil // Tag doesn't exist, nothing to remove
@@ -91,6 +120,13 @@ func RemoveTagFromImage(db *sql.DB, imageID int, tagName string) error {
 		return fmt.Errorf("remove tag from image: %w", err)
 	}
 
+	// Remember the removal so re-tagging does not bring the tag back
+	_, err = db.Exec(`INSERT OR REPLACE INTO image_tag_removals (image_id, tag_id, removed_at) VALUES (?, ?, ?)`,
+		imageID, tagID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("record tag removal: %w", err)
+	}
+
 	return nil
 }
 
@@ -102,9 +138,10 @@ func GetTagByName(db *sql.DB, tagName string) (*Tag, error) {
 		       COALESCE(t.favorite, false) as is_favorite
 		FROM tags t
 		LEFT JOIN image_tags it ON t.id = it.tag_id
-		WHERE t.name = ?
+		       AND it.image_id NOT IN (SELECT id FROM images WHERE deleted_at IS NOT NULL)
+		WHERE t.name = ? OR t.id = (SELECT tag_id FROM tag_aliases WHERE alias = ?)
 		GROUP BY t.id, t.name, t.category, t.favorite
-	`, tagName).Scan(&tag.ID, &tag.Name, &tag.Category, &tag.ImageCount, &tag.IsFavorite)
+	`, tagName, tagName).Scan(&tag.ID, &tag.Name, &tag.Category, &tag.ImageCount, &tag.IsFavorite)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -115,3 +152,30 @@ func GetTagByName(db *sql.DB, tagName string) (*Tag, error) {
 	
 	return &tag, nil
 }
+
+// GetAllTags lists every tag with the number of images outside the trash that have it
+func GetAllTags(db *sql.DB) ([]Tag, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.name, COALESCE(t.category, ''),
+		       COUNT(i.id) as image_count,
+		       COALESCE(t.favorite, false) as is_favorite
+		FROM tags t
+		LEFT JOIN image_tags it ON t.id = it.tag_id
+		LEFT JOIN images i ON i.id = it.image_id AND i.deleted_at IS NULL
+		GROUP BY t.id, t.name, t.category, t.favorite
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("get all tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tg Tag
+		if err := rows.Scan(&tg.ID, &tg.Name, &tg.Category, &tg.ImageCount, &tg.IsFavorite); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, tg)
+	}
+	return tags, rows.Err()
+}
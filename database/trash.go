@@ -0,0 +1,162 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brayanMuniz/AGO/internal/query"
+	"github.com/brayanMuniz/AGO/utils"
+)
+
+// TrashedImage is an image in the trash with the path its file was moved to
+type TrashedImage struct {
+	ImageResult
+	// TrashPath is empty when the file was already gone from the gallery
+	TrashPath string `json:"trash_path"`
+}
+
+const trashedImageColumns = ImageColumns + `, COALESCE(images.trash_path, '')`
+
+func scanTrashedImage(row interface{ Scan(...any) error }) (TrashedImage, error) {
+	var trashPath string
+	img, err := ScanImage(row, &trashPath)
+	return TrashedImage{ImageResult: img, TrashPath: trashPath}, err
+}
+
+// MarkImageDeleted hides an image from every list and remembers where its file went.
+// It returns false when the image does not exist or is already in the trash.
+func MarkImageDeleted(db *sql.DB, imageID int, trashPath string) (bool, error) {
+	result, err := db.Exec(`UPDATE images SET deleted_at = ?, trash_path = ? WHERE id = ? AND deleted_at IS NULL`,
+		time.Now().UTC(), nullIfEmpty(trashPath), imageID)
+	if err != nil {
+		return false, fmt.Errorf("mark image deleted: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RestoreImage takes an image out of the trash, its file must already be back in the gallery
+func RestoreImage(db *sql.DB, imageID int) error {
+	_, err := db.Exec(`UPDATE images SET deleted_at = NULL, trash_path = NULL WHERE id = ?`, imageID)
+	if err != nil {
+		return fmt.Errorf("restore image: %w", err)
+	}
+	return nil
+}
+
+// GetTrashedImage returns nil when the image does not exist or is not in the trash
+func GetTrashedImage(db *sql.DB, imageID int) (*TrashedImage, error) {
+	row := db.QueryRow(`SELECT `+trashedImageColumns+` FROM images WHERE id = ? AND deleted_at IS NOT NULL`, imageID)
+	img, err := scanTrashedImage(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get trashed image: %w", err)
+	}
+	return &img, nil
+}
+
+// TrashSort is the order of the trash, most recently deleted first
+var TrashSort = utils.Sort{Name: "deleted_desc", Key: "COALESCE(images.deleted_at, '')", Desc: true}
+
+// GetTrashedImages lists page of the trash in TrashSort order and how many images it holds.
+// The page holds one row past its limit when more follow, see utils.FinishPage.
+func GetTrashedImages(db *sql.DB, page utils.Page) ([]TrashedImage, int, error) {
+	q := query.ImageQuery{Filters: []utils.FilterCondition{query.Deleted()}, Sort: TrashSort, Page: page, Columns: trashedImageColumns}
+
+	var total int
+	countSQL, countArgs := q.Count()
+	if err := db.QueryRow(countSQL, countArgs...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count trashed images: %w", err)
+	}
+
+	selectSQL, args := q.Select()
+	rows, err := db.Query(selectSQL, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get trashed images: %w", err)
+	}
+	defer rows.Close()
+
+	trashed := []TrashedImage{}
+	for rows.Next() {
+		var trashPath string
+		img, err := ScanSortedImage(rows, &trashPath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan trashed image: %w", err)
+		}
+		trashed = append(trashed, TrashedImage{ImageResult: img, TrashPath: trashPath})
+	}
+	return trashed, total, rows.Err()
+}
+
+// GetExpiredTrash returns every image deleted before cutoff
+func GetExpiredTrash(db *sql.DB, cutoff time.Time) ([]TrashedImage, error) {
+	rows, err := db.Query(`
+		SELECT `+trashedImageColumns+`
+		FROM images
+		WHERE deleted_at IS NOT NULL AND deleted_at < ?
+		ORDER BY deleted_at
+	`, cutoff.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("get expired trash: %w", err)
+	}
+	defer rows.Close()
+
+	var expired []TrashedImage
+	for rows.Next() {
+		img, err := scanTrashedImage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan trashed image: %w", err)
+		}
+		expired = append(expired, img)
+	}
+	return expired, rows.Err()
+}
+
+// PurgeImage permanently deletes a trashed image's row and everything linked to it.
+// Removing its file and thumbnails is up to the caller.
+func PurgeImage(db *sql.DB, imageID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var filename string
+	err = tx.QueryRow(`SELECT COALESCE(filename, '') FROM images WHERE id = ? AND deleted_at IS NOT NULL`, imageID).Scan(&filename)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("image %d is not in the trash", imageID)
+		}
+		return fmt.Errorf("get trashed image: %w", err)
+	}
+
+	statements := []struct {
+		query string
+		arg   any
+	}{
+		{`DELETE FROM image_tags WHERE image_id = ?`, imageID},
+		{`DELETE FROM image_tag_removals WHERE image_id = ?`, imageID},
+		{`DELETE FROM image_colors WHERE image_id = ?`, imageID},
+		{`DELETE FROM album_images WHERE image_id = ?`, imageID},
+		{`UPDATE albums SET cover_image_id = NULL WHERE cover_image_id = ?`, imageID},
+		{`DELETE FROM file_provenance WHERE phash = ?`, strings.TrimSuffix(filename, filepath.Ext(filename))},
+		// Raw files flagged as duplicates of this one no longer have an original
+		{`DELETE FROM duplicate_flags WHERE duplicate_of = ?`, strings.TrimSuffix(filename, filepath.Ext(filename))},
+		{`DELETE FROM images WHERE id = ?`, imageID},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.arg); err != nil {
+			return fmt.Errorf("purge image %d: %w", imageID, err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server address and every path the server reads or writes.
+// Relative paths are resolved against Library once loading is done.
+type Config struct {
+	// Library is the directory relative paths are resolved against
+	Library        string
+	Addr           string
+	Database       string
+	GalleryDir     string
+	ThumbnailsDir  string
+	RawDir         string
+	TxtDir         string
+	TagMapPath     string
+	ThresholdsPath string
+	ExportsDir     string
+	TrashDir       string
+	// TrashRetention is how long deleted images stay in the trash before a purge removes them
+	TrashRetention time.Duration
+
+	Watch         bool
+	WatchInterval time.Duration
+	Tagger        string
+	TaggerModel   string
+	TaggerTimeout time.Duration
+}
+
+// DefaultConfigFile is read from the working directory when no config file is given
+const DefaultConfigFile = "ago.json"
+
+// Default returns the settings the server used before it was configurable
+func Default() Config {
+	return Config{
+		Library:        ".",
+		Addr:           ":8081",
+		Database:       "galleryDB.db",
+		GalleryDir:     "gallery",
+		RawDir:         "raw_images",
+		TxtDir:         "raw_txt_files",
+		TagMapPath:     "tag_to_category.json",
+		ThresholdsPath: "tag_thresholds.json",
+		ExportsDir:     "exports",
+		TrashDir:       "trash",
+		TrashRetention: 30 * 24 * time.Hour,
+		WatchInterval:  5 * time.Second,
+		TaggerTimeout:  2 * time.Minute,
+	}
+}
+
+// setting is one option. It is called name on the command line, name with underscores
+// in the config file and AGO_NAME in the environment, e.g. -gallery-dir, gallery_dir, AGO_GALLERY_DIR.
+type setting struct {
+	name  string
+	usage string
+	value func(c *Config) flag.Value
+}
+
+var settings = []setting{
+	{"library", "directory relative paths are resolved against", func(c *Config) flag.Value { return (*stringValue)(&c.Library) }},
+	{"addr", "address the server listens on", func(c *Config) flag.Value { return (*stringValue)(&c.Addr) }},
+	{"database", "SQLite database file", func(c *Config) flag.Value { return (*stringValue)(&c.Database) }},
+	{"gallery-dir", "directory organized images are stored in", func(c *Config) flag.Value { return (*stringValue)(&c.GalleryDir) }},
+	{"thumbnails-dir", "directory generated thumbnails are stored in (default <gallery-dir>/thumbnails)", func(c *Config) flag.Value { return (*stringValue)(&c.ThumbnailsDir) }},
+	{"raw-dir", "directory new images are organized from", func(c *Config) flag.Value { return (*stringValue)(&c.RawDir) }},
+	{"txt-dir", "directory tag files are imported from", func(c *Config) flag.Value { return (*stringValue)(&c.TxtDir) }},
+	{"tag-map", "JSON file mapping tags to categories", func(c *Config) flag.Value { return (*stringValue)(&c.TagMapPath) }},
+	{"tag-thresholds", "JSON file with minimum tagger confidences per category", func(c *Config) flag.Value { return (*stringValue)(&c.ThresholdsPath) }},
+	{"exports-dir", "directory exports are written to", func(c *Config) flag.Value { return (*stringValue)(&c.ExportsDir) }},
+	{"trash-dir", "directory removed files are moved to", func(c *Config) flag.Value { return (*stringValue)(&c.TrashDir) }},
+	{"trash-retention", "how long deleted images are kept in the trash before a purge removes them", func(c *Config) flag.Value { return (*durationValue)(&c.TrashRetention) }},
+	{"watch", "automatically organize and import new files in the raw and tag file directories", func(c *Config) flag.Value { return (*boolValue)(&c.Watch) }},
+	{"watch-interval", "how often the watcher scans for new files", func(c *Config) flag.Value { return (*durationValue)(&c.WatchInterval) }},
+	{"tagger", `command that tags one image and prints its tags, e.g. "python tag.py {image}"`, func(c *Config) flag.Value { return (*stringValue)(&c.Tagger) }},
+	{"tagger-model", "name of the model the tagger runs, recorded on the tags it produces (default the tagger command)", func(c *Config) flag.Value { return (*stringValue)(&c.TaggerModel) }},
+	{"tagger-timeout", "how long the tagger may run on a single image", func(c *Config) flag.Value { return (*durationValue)(&c.TaggerTimeout) }},
+}
+
+func (s setting) key() string {
+	return strings.ReplaceAll(s.name, "-", "_")
+}
+
+func (s setting) env() string {
+	return "AGO_" + strings.ToUpper(s.key())
+}
+
+// Load builds the configuration from, in increasing priority, the defaults, the config file,
+// AGO_* environment variables and the command line flags in args.
+// The config file is -config, AGO_CONFIG or DefaultConfigFile if it exists.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("ago", flag.ContinueOnError)
+	configPath := fs.String("config", "", "JSON config file (default "+DefaultConfigFile+" when present, env AGO_CONFIG)")
+
+	// Flags are parsed into their own copy so only the ones actually given override the rest
+	flagged := Default()
+	for _, s := range settings {
+		fs.Var(s.value(&flagged), s.name, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	path, required := *configPath, true
+	if path == "" {
+		path = os.Getenv("AGO_CONFIG")
+	}
+	if path == "" {
+		path, required = DefaultConfigFile, false
+	}
+	if err := cfg.loadFile(path); err != nil {
+		if required || !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := s.value(&cfg).Set(v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", s.env(), err)
+			}
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range settings {
+		if set[s.name] {
+			s.value(&cfg).Set(s.value(&flagged).String())
+		}
+	}
+
+	cfg.resolvePaths()
+	return &cfg, nil
+}
+
+// loadFile applies the settings in a JSON object such as {"gallery_dir": "/mnt/art/gallery"}
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer f.Close()
+
+	values := map[string]any{}
+	decoder := json.NewDecoder(f)
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil && err != io.EOF {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	for _, s := range settings {
+		v, ok := values[s.key()]
+		if !ok {
+			continue
+		}
+		delete(values, s.key())
+		if err := s.value(c).Set(fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("config file %s: invalid %s: %w", path, s.key(), err)
+		}
+		// A library given in the file is relative to the file, not to the working directory
+		if s.name == "library" && !filepath.IsAbs(c.Library) {
+			c.Library = filepath.Join(filepath.Dir(path), c.Library)
+		}
+	}
+	for key := range values {
+		return fmt.Errorf("config file %s: unknown setting %q", path, key)
+	}
+
+	return nil
+}
+
+// resolvePaths makes every relative path relative to Library
+func (c *Config) resolvePaths() {
+	if c.ThumbnailsDir == "" {
+		c.ThumbnailsDir = filepath.Join(c.GalleryDir, "thumbnails")
+	}
+
+	for _, path := range []*string{
+		&c.Database, &c.GalleryDir, &c.ThumbnailsDir, &c.RawDir, &c.TxtDir,
+		&c.TagMapPath, &c.ThresholdsPath, &c.ExportsDir, &c.TrashDir,
+	} {
+		if !filepath.IsAbs(*path) {
+			*path = filepath.Join(c.Library, *path)
+		}
+	}
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) String() string { return time.Duration(*v).String() }
@@ -0,0 +1,97 @@
+package duplicates
+
+import (
+	"math/bits"
+	"strconv"
+)
+
+// Hamming returns the number of bits that differ between two perceptual hashes
+func Hamming(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// ParseHash converts a phash stored as hex text back into its 64-bit value
+func ParseHash(phash string) (uint64, error) {
+	return strconv.ParseUint(phash, 16, 64)
+}
+
+// Match is a value found within the searched distance
+type Match[T any] struct {
+	Hash     uint64
+	Value    T
+	Distance int
+}
+
+type node[T any] struct {
+	hash     uint64
+	values   []T // every value added with exactly this hash
+	children map[int]*node[T]
+}
+
+// BKTree indexes 64-bit hashes by Hamming distance so nearby hashes can be found
+// without comparing against every entry.
+type BKTree[T any] struct {
+	root *node[T]
+	size int
+}
+
+func (t *BKTree[T]) Len() int {
+	return t.size
+}
+
+func (t *BKTree[T]) Add(hash uint64, value T) {
+	t.size++
+	if t.root == nil {
+		t.root = &node[T]{hash: hash, values: []T{value}}
+		return
+	}
+
+	current := t.root
+	for {
+		distance := Hamming(hash, current.hash)
+		if distance == 0 {
+			current.values = append(current.values, value)
+			return
+		}
+
+		child, ok := current.children[distance]
+		if !ok {
+			if current.children == nil {
+				current.children = make(map[int]*node[T])
+			}
+			current.children[distance] = &node[T]{hash: hash, values: []T{value}}
+			return
+		}
+		current = child
+	}
+}
+
+// Search returns every value whose hash is within maxDistance of hash
+func (t *BKTree[T]) Search(hash uint64, maxDistance int) []Match[T] {
+	var matches []Match[T]
+	if t.root == nil {
+		return matches
+	}
+
+	stack := []*node[T]{t.root}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		distance := Hamming(hash, current.hash)
+		if distance <= maxDistance {
+			for _, value := range current.values {
+				matches = append(matches, Match[T]{Hash: current.hash, Value: value, Distance: distance})
+			}
+		}
+
+		// Triangle inequality: only children at distance d ± maxDistance can contain matches
+		for childDistance, child := range current.children {
+			if childDistance >= distance-maxDistance && childDistance <= distance+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,98 @@
+package duplicates
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestBKTreeSearchEmpty(t *testing.T) {
+	var tree BKTree[int]
+	if matches := tree.Search(0, 64); len(matches) != 0 {
+		t.Errorf("Search on an empty tree = %v, want none", matches)
+	}
+}
+
+func TestBKTreeSearch(t *testing.T) {
+	var tree BKTree[string]
+	tree.Add(0x0, "zero")
+	tree.Add(0x0, "zero again")
+	tree.Add(0x1, "one bit")
+	tree.Add(0x3, "two bits")
+	tree.Add(0xF0, "four other bits")
+	tree.Add(0xFFFFFFFFFFFFFFFF, "all bits")
+
+	if tree.Len() != 6 {
+		t.Errorf("Len = %d, want 6", tree.Len())
+	}
+
+	tests := []struct {
+		hash        uint64
+		maxDistance int
+		want        []string
+	}{
+		{0x0, 0, []string{"zero", "zero again"}},
+		{0x0, 1, []string{"one bit", "zero", "zero again"}},
+		{0x0, 2, []string{"one bit", "two bits", "zero", "zero again"}},
+		{0x2, 1, []string{"two bits", "zero", "zero again"}},
+		{0xF1, 1, []string{"four other bits"}},
+		{0xFFFFFFFFFFFFFFFE, 1, []string{"all bits"}},
+		{0x0, 64, []string{"all bits", "four other bits", "one bit", "two bits", "zero", "zero again"}},
+		{0xFF00, 3, nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, m := range tree.Search(tt.hash, tt.maxDistance) {
+			if d := Hamming(tt.hash, m.Hash); m.Distance != d {
+				t.Errorf("Search(%x, %d) reports %q at distance %d, want %d", tt.hash, tt.maxDistance, m.Value, m.Distance, d)
+			}
+			got = append(got, m.Value)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%x, %d) = %v, want %v", tt.hash, tt.maxDistance, got, tt.want)
+		}
+	}
+}
+
+// TestBKTreeSearchMatchesBruteForce checks the tree against comparing every hash, on hashes
+// clustered closely enough that each radius finds some of them
+func TestBKTreeSearchMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bases := []uint64{rng.Uint64(), rng.Uint64(), rng.Uint64()}
+
+	var tree BKTree[int]
+	hashes := make([]uint64, 500)
+	for i := range hashes {
+		hash := bases[i%len(bases)]
+		for flips := rng.Intn(12); flips > 0; flips-- {
+			hash ^= 1 << rng.Intn(64)
+		}
+		hashes[i] = hash
+		tree.Add(hash, i)
+	}
+
+	for _, maxDistance := range []int{0, 1, 4, 10, 32} {
+		for q := 0; q < 20; q++ {
+			query := hashes[rng.Intn(len(hashes))] ^ 1<<rng.Intn(64)
+
+			var want []int
+			for i, hash := range hashes {
+				if Hamming(query, hash) <= maxDistance {
+					want = append(want, i)
+				}
+			}
+
+			var got []int
+			for _, m := range tree.Search(query, maxDistance) {
+				got = append(got, m.Value)
+			}
+			slices.Sort(got)
+
+			if !slices.Equal(got, want) {
+				t.Fatalf("Search(%x, %d) = %v, want %v", query, maxDistance, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package duplicates
+
+import (
+	"sort"
+)
+
+// Entry is an image identified by its database ID and perceptual hash
+type Entry struct {
+	ID   int
+	Hash uint64
+}
+
+// Cluster is a group of images linked by pairs within the search distance
+type Cluster struct {
+	IDs []int `json:"ids"`
+	// MaxDistance is the largest distance between two directly linked images
+	MaxDistance int `json:"max_distance"`
+}
+
+// FindClusters groups entries whose hashes are within maxDistance of each other.
+// Grouping is transitive, so A~B and B~C puts all three in one cluster.
+// Larger clusters come first, then clusters containing older images.
+func FindClusters(entries []Entry, maxDistance int) []Cluster {
+	tree := &BKTree[int]{}
+	for i, entry := range entries {
+		tree.Add(entry.Hash, i)
+	}
+
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	linkDistance := make(map[int]int)
+	for i, entry := range entries {
+		for _, match := range tree.Search(entry.Hash, maxDistance) {
+			j := match.Value
+			if j == i {
+				continue
+			}
+			rootI, rootJ := find(i), find(j)
+			if rootI != rootJ {
+				parent[rootJ] = rootI
+			}
+			linkDistance[i] = max(linkDistance[i], match.Distance)
+		}
+	}
+
+	groups := make(map[int]*Cluster)
+	for i, entry := range entries {
+		root := find(i)
+		group, ok := groups[root]
+		if !ok {
+			group = &Cluster{}
+			groups[root] = group
+		}
+		group.IDs = append(group.IDs, entry.ID)
+		group.MaxDistance = max(group.MaxDistance, linkDistance[i])
+	}
+
+	clusters := []Cluster{}
+	for _, group := range groups {
+		if len(group.IDs) < 2 {
+			continue
+		}
+		sort.Ints(group.IDs)
+		clusters = append(clusters, *group)
+	}
+
+	sort.Slice(clusters, func(a, b int) bool {
+		if len(clusters[a].IDs) != len(clusters[b].IDs) {
+			return len(clusters[a].IDs) > len(clusters[b].IDs)
+		}
+		return clusters[a].IDs[0] < clusters[b].IDs[0]
+	})
+
+	return clusters
+}
@@ -2,11 +2,11 @@ package handlers
 
 import (
 	"database/sql"
-	"fmt"
-	"strings"
 	"strconv"
+	"strings"
 	
 	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/query"
 	"github.com/brayanMuniz/AGO/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -52,7 +52,10 @@ func GetAlbumImagesHandler(db *sql.DB) gin.HandlerFunc {
 		
 		// Parse query parameters using shared utility
 		params := utils.ParseImageQueryParams(c)
-		offset := (params.Page - 1) * params.Limit
+		sort, page, ok := listPage(c, &params)
+		if !ok {
+			return
+		}
 
 		var albumType string
 		err := db.QueryRow("SELECT type FROM albums WHERE id = ?", id).Scan(&albumType)
@@ -61,120 +64,32 @@ func GetAlbumImagesHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		var images []database.ImageResult
-		var totalCount int
-
 		// Build filter conditions using shared utilities
-		filterConditions := utils.BuildFilterConditionsFromParams(params)
-		var filterWhereClause string
-		var allFilterArgs []interface{}
-		if len(filterConditions) > 0 {
-			whereClause, args := utils.CombineFilterConditions(filterConditions)
-			if whereClause != "" {
-				filterWhereClause = " AND " + strings.TrimPrefix(whereClause, "WHERE ")
-				allFilterArgs = args
-			}
-		}
-
-		// Build order by clause
-		var orderBy string
-		switch params.SortBy {
-		case "date_asc":
-			orderBy = "ORDER BY images.id ASC"
-		case "date_desc":
-			orderBy = "ORDER BY images.id DESC"
-		case "rating_desc":
-			orderBy = "ORDER BY images.rating DESC, images.id DESC"
-		case "rating_asc":
-			orderBy = "ORDER BY images.rating ASC, images.id ASC"
-		case "likes_desc":
-			orderBy = "ORDER BY images.like_count DESC, images.id DESC"
-		case "likes_asc":
-			orderBy = "ORDER BY images.like_count ASC, images.id ASC"
-		case "random":
-			if params.Seed != "" {
-				// Use seeded random for reproducible results
-				orderBy = fmt.Sprintf("ORDER BY (images.id * %s) %% 1000000", params.Seed)
-			} else {
-				orderBy = "ORDER BY RANDOM()"
-			}
-		default:
-			orderBy = "ORDER BY RANDOM()"
+		filterConditions, ok := buildFilterConditions(c, params)
+		if !ok {
+			return
 		}
 
+		albumID, _ := strconv.Atoi(id)
 		if albumType == "manual" {
-			// Get total count
-			countQuery := fmt.Sprintf(`
-				SELECT COUNT(*)
-				FROM album_images
-				JOIN images ON album_images.image_id = images.id
-				WHERE album_images.album_id = ? %s
-			`, filterWhereClause)
-			
-			countArgs := []interface{}{id}
-			countArgs = append(countArgs, allFilterArgs...)
-			
-			err = db.QueryRow(countQuery, countArgs...).Scan(&totalCount)
-			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return
-			}
-
-			// Get paginated results
-			query := fmt.Sprintf(`
-				SELECT images.id, images.phash, images.filename, images.width, images.height, images.favorite, images.like_count, images.rating
-				FROM album_images
-				JOIN images ON album_images.image_id = images.id
-				WHERE album_images.album_id = ? %s
-				%s
-				LIMIT ? OFFSET ?
-			`, filterWhereClause, orderBy)
-
-			queryArgs := []interface{}{id}
-			queryArgs = append(queryArgs, allFilterArgs...)
-			queryArgs = append(queryArgs, params.Limit, offset)
-			
-			rows, err := db.Query(query, queryArgs...)
-			if err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
-				return
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var img database.ImageResult
-				err := rows.Scan(&img.ID, &img.Phash, &img.Filename, &img.Width, &img.Height, &img.Favorite, &img.Likes, &img.Rating)
-				if err != nil {
-					c.JSON(500, gin.H{"error": err.Error()})
-					return
-				}
-				images = append(images, img)
-			}
-
+			filterConditions = append(filterConditions, query.InAlbum(albumID))
 		} else if albumType == "smart" {
-			albumID, _ := strconv.Atoi(id)
-			
-			// Parse filter arrays using shared utility
-			includeCharactersList, excludeCharactersList, includeTagsList, excludeTagsList, includeExplicitnessList, excludeExplicitnessList, includeSeriesList, excludeSeriesList, includeArtistsList, excludeArtistsList := utils.ParseFilterArrays(params)
-			
-			images, totalCount, err = database.GetSmartAlbumImagesPaginated(db, albumID, params.Page, params.Limit, params.SortBy, includeCharactersList, excludeCharactersList, includeTagsList, excludeTagsList, includeExplicitnessList, excludeExplicitnessList, includeSeriesList, excludeSeriesList, includeArtistsList, excludeArtistsList)
+			smart, err := database.GetSmartAlbum(db, albumID)
 			if err != nil {
 				c.JSON(500, gin.H{"error": err.Error()})
 				return
 			}
+			filterConditions = append(filterConditions, smart.Filters(params.MinConfidence)...)
 		}
 
-		totalPages := (totalCount + params.Limit - 1) / params.Limit
+		q := query.ImageQuery{Filters: filterConditions, Sort: sort, Page: page}
+		images, totalCount, err := database.FindImages(db, q)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
 
-		c.JSON(200, gin.H{
-			"images": images,
-			"pagination": gin.H{
-				"current_page": params.Page,
-				"total_pages":  totalPages,
-				"total_count":  totalCount,
-				"limit":        params.Limit,
-			},
-		})
+		respondImagePage(c, db, q, params, images, totalCount)
 	}
 }
 
@@ -219,22 +134,27 @@ func GetSmartAlbumFiltersHandler(db *sql.DB) gin.HandlerFunc {
 			FavoriteOnly    bool   `json:"favorite_only"`
 			IncludeAlbumIDs string `json:"include_album_ids"`
 			ExcludeAlbumIDs string `json:"exclude_album_ids"`
+			IncludeTagMatch string `json:"include_tag_match"`
 		}
 
 		err := db.QueryRow(`
 			SELECT include_tag_ids, exclude_tag_ids, min_rating, favorite_only, 
 			       COALESCE(include_album_ids, '') as include_album_ids, 
-			       COALESCE(exclude_album_ids, '') as exclude_album_ids
+			       COALESCE(exclude_album_ids, '') as exclude_album_ids,
+			       COALESCE(include_tag_match, '') as include_tag_match
 			FROM smart_album_filters 
 			WHERE album_id = ?`,
 			albumID,
-		).Scan(&filters.IncludeTagIDs, &filters.ExcludeTagIDs, &filters.MinRating, &filters.FavoriteOnly, &filters.IncludeAlbumIDs, &filters.ExcludeAlbumIDs)
+		).Scan(&filters.IncludeTagIDs, &filters.ExcludeTagIDs, &filters.MinRating, &filters.FavoriteOnly, &filters.IncludeAlbumIDs, &filters.ExcludeAlbumIDs, &filters.IncludeTagMatch)
 
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
+		mode, _ := utils.ParseMatchMode(filters.IncludeTagMatch, utils.MatchAny)
+		filters.IncludeTagMatch = mode.String()
+
 		c.JSON(200, filters)
 	}
 }
@@ -250,6 +170,7 @@ func UpdateSmartAlbumFiltersHandler(db *sql.DB) gin.HandlerFunc {
 			FavoriteOnly    bool   `json:"favorite_only"`
 			IncludeAlbumIDs string `json:"include_album_ids"`
 			ExcludeAlbumIDs string `json:"exclude_album_ids"`
+			IncludeTagMatch string `json:"include_tag_match"` // any (default), all or a count
 			CoverImageID    *int   `json:"cover_image_id"`
 		}
 
@@ -258,14 +179,20 @@ func UpdateSmartAlbumFiltersHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		mode, err := utils.ParseMatchMode(input.IncludeTagMatch, utils.MatchAny)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Update smart album filters
-		_, err := db.Exec(`
+		_, err = db.Exec(`
 			UPDATE smart_album_filters 
 			SET include_tag_ids = ?, exclude_tag_ids = ?, min_rating = ?, favorite_only = ?, 
-			    include_album_ids = ?, exclude_album_ids = ?
+			    include_album_ids = ?, exclude_album_ids = ?, include_tag_match = ?
 			WHERE album_id = ?`,
 			input.IncludeTagIDs, input.ExcludeTagIDs, input.MinRating, input.FavoriteOnly, 
-			input.IncludeAlbumIDs, input.ExcludeAlbumIDs, albumID,
+			input.IncludeAlbumIDs, input.ExcludeAlbumIDs, mode.String(), albumID,
 		)
 
 		if err != nil {
@@ -295,9 +222,10 @@ func UpdateSmartAlbumFiltersHandler(db *sql.DB) gin.HandlerFunc {
 func GetAlbumsHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		rows, err := db.Query(`
-			SELECT a.id, a.name, a.type, a.cover_image_id, COUNT(ai.image_id) as image_count
+			SELECT a.id, a.name, a.type, a.cover_image_id, COUNT(i.id) as image_count
 			FROM albums a
 			LEFT JOIN album_images ai ON a.id = ai.album_id
+			LEFT JOIN images i ON i.id = ai.image_id AND i.deleted_at IS NULL
 			GROUP BY a.id, a.name, a.type, a.cover_image_id
 		`)
 		if err != nil {
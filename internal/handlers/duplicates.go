@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/duplicates"
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/query"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/brayanMuniz/AGO/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// GetDuplicatesHandler returns clusters of images whose phashes are within ?distance of each other
+func GetDuplicatesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		distance, err := strconv.Atoi(c.DefaultQuery("distance", "6"))
+		if err != nil || distance < 0 || distance > 32 {
+			c.JSON(400, gin.H{"error": "distance must be between 0 and 32"})
+			return
+		}
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if page < 1 {
+			page = 1
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		hashes, err := database.GetImageHashes(db)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to load image hashes"})
+			return
+		}
+
+		entries := make([]duplicates.Entry, 0, len(hashes))
+		for _, h := range hashes {
+			hash, err := duplicates.ParseHash(h.Phash)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, duplicates.Entry{ID: h.ID, Hash: hash})
+		}
+
+		clusters := duplicates.FindClusters(entries, distance)
+		totalCount := len(clusters)
+
+		start := min((page-1)*limit, totalCount)
+		end := min(start+limit, totalCount)
+
+		// Read every image on the page at once, then hand them out to their clusters
+		ids := []int{}
+		for _, cluster := range clusters[start:end] {
+			ids = append(ids, cluster.IDs...)
+		}
+		pageImages, _, err := database.FindImages(db, query.ImageQuery{
+			Filters: []utils.FilterCondition{query.WithIDs(ids)},
+			Sort:    utils.BuildSort("date_asc", ""),
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+		if err := database.AttachPalettes(db, pageImages); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch palettes"})
+			return
+		}
+		byID := make(map[int]database.ImageResult, len(pageImages))
+		for _, img := range pageImages {
+			byID[img.ID] = img
+		}
+
+		results := []gin.H{}
+		for _, cluster := range clusters[start:end] {
+			clusterImages := []database.ImageResult{}
+			for _, id := range cluster.IDs {
+				if img, ok := byID[id]; ok {
+					clusterImages = append(clusterImages, img)
+				}
+			}
+
+			results = append(results, gin.H{
+				"images":       clusterImages,
+				"max_distance": cluster.MaxDistance,
+			})
+		}
+
+		totalPages := (totalCount + limit - 1) / limit
+
+		c.JSON(200, gin.H{
+			"clusters": results,
+			"distance": distance,
+			"pagination": gin.H{
+				"current_page": page,
+				"total_pages":  totalPages,
+				"total_count":  totalCount,
+				"limit":        limit,
+			},
+		})
+	}
+}
+
+// GetDuplicateFlagsHandler lists raw files that organize left behind as likely duplicates
+func GetDuplicateFlagsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		flags, err := database.GetDuplicateFlags(db)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch flagged duplicates"})
+			return
+		}
+
+		// Files the user already removed from the raw directory are no longer pending
+		pending := []database.DuplicateFlag{}
+		for _, flag := range flags {
+			if _, err := os.Stat(flag.SourcePath); err == nil {
+				pending = append(pending, flag)
+			}
+		}
+
+		c.JSON(200, gin.H{"flags": pending})
+	}
+}
+
+// ResolveDuplicatesRequest picks which of a group of duplicate images survives
+type ResolveDuplicatesRequest struct {
+	ImageIDs []int  `json:"image_ids"` // the duplicate group, at least two images
+	Keep     string `json:"keep"`      // "resolution", "file_size" or "explicit"
+	KeepID   int    `json:"keep_id"`   // required when keep is "explicit"
+}
+
+// ResolveDuplicatesHandler keeps one image of a duplicate group, merges the others' metadata
+// into it and moves their files to the trash folder
+func ResolveDuplicatesHandler(db *sql.DB, cfg *config.Config, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResolveDuplicatesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if len(req.ImageIDs) < 2 {
+			c.JSON(400, gin.H{"error": "At least two image IDs are required"})
+			return
+		}
+
+		group := []database.ImageResult{}
+		seen := map[int]bool{}
+		for _, id := range req.ImageIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			img, err := database.GetImageByID(db, id)
+			if err != nil {
+				c.JSON(500, gin.H{"error": "Failed to fetch image"})
+				return
+			}
+			if img == nil {
+				c.JSON(404, gin.H{"error": fmt.Sprintf("Image %d not found", id)})
+				return
+			}
+			group = append(group, *img)
+		}
+
+		if len(group) < 2 {
+			c.JSON(400, gin.H{"error": "At least two distinct image IDs are required"})
+			return
+		}
+
+		keeper, err := chooseKeeper(group, req.Keep, req.KeepID, cfg.GalleryDir)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Move retired files out of the gallery first so a failed merge can put them back
+		retiredIDs := []int{}
+		trashed := map[string]string{}
+		for _, img := range group {
+			if img.ID == keeper.ID {
+				continue
+			}
+			retiredIDs = append(retiredIDs, img.ID)
+
+			sourcePath := filepath.Join(cfg.GalleryDir, img.Filename)
+			trashPath, err := images.MoveToTrash(sourcePath, filepath.Join(cfg.TrashDir, "duplicates"))
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue // file already gone, only the row needs retiring
+				}
+				restoreTrashed(trashed)
+				c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to move image %d to trash: %v", img.ID, err)})
+				return
+			}
+			trashed[sourcePath] = trashPath
+		}
+
+		if err := database.MergeImages(db, keeper.ID, retiredIDs); err != nil {
+			restoreTrashed(trashed)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		index.Invalidate()
+
+		for sourcePath := range trashed {
+			if err := images.RemoveThumbnails(cfg.ThumbnailsDir, filepath.Base(sourcePath)); err != nil {
+				fmt.Printf("Failed to remove thumbnails for %s: %v\n", sourcePath, err)
+			}
+		}
+
+		kept, err := database.GetImageByID(db, keeper.ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch merged image"})
+			return
+		}
+
+		trashPaths := []string{}
+		for _, trashPath := range trashed {
+			trashPaths = append(trashPaths, trashPath)
+		}
+
+		c.JSON(200, gin.H{
+			"kept":    kept,
+			"retired": retiredIDs,
+			"trashed": trashPaths,
+		})
+	}
+}
+
+// chooseKeeper applies the keep policy to a duplicate group
+func chooseKeeper(group []database.ImageResult, policy string, keepID int, galleryDir string) (database.ImageResult, error) {
+	switch policy {
+	case "explicit":
+		for _, img := range group {
+			if img.ID == keepID {
+				return img, nil
+			}
+		}
+		return database.ImageResult{}, fmt.Errorf("keep_id must be one of image_ids")
+
+	case "resolution", "":
+		best := group[0]
+		for _, img := range group[1:] {
+			if img.Width*img.Height > best.Width*best.Height {
+				best = img
+			}
+		}
+		return best, nil
+
+	case "file_size":
+		best := group[0]
+		var bestSize int64 = -1
+		for _, img := range group {
+			info, err := os.Stat(filepath.Join(galleryDir, img.Filename))
+			if err != nil {
+				continue
+			}
+			if info.Size() > bestSize {
+				best = img
+				bestSize = info.Size()
+			}
+		}
+		return best, nil
+	}
+
+	return database.ImageResult{}, fmt.Errorf("keep must be one of: resolution, file_size, explicit")
+}
+
+// restoreTrashed moves files back to where they were before a failed resolve
+func restoreTrashed(trashed map[string]string) {
+	for sourcePath, trashPath := range trashed {
+		if err := os.Rename(trashPath, sourcePath); err != nil {
+			fmt.Printf("Failed to restore %s from trash: %v\n", sourcePath, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
@@ -12,7 +13,13 @@ import (
 	"strings"
 
 	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/config"
 	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/ingest"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/query"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
 	"github.com/brayanMuniz/AGO/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -21,88 +28,25 @@ func GetImagesHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Parse query parameters using shared utility
 		params := utils.ParseImageQueryParams(c)
-		offset := (params.Page - 1) * params.Limit
-
-		// Build query based on sort parameter
-		var orderBy string
-		switch params.SortBy {
-		case "date_asc":
-			orderBy = "ORDER BY id ASC"
-		case "date_desc":
-			orderBy = "ORDER BY id DESC"
-		case "rating_desc":
-			orderBy = "ORDER BY rating DESC, id DESC"
-		case "rating_asc":
-			orderBy = "ORDER BY rating ASC, id ASC"
-		case "likes_desc":
-			orderBy = "ORDER BY like_count DESC, id DESC"
-		case "likes_asc":
-			orderBy = "ORDER BY like_count ASC, id ASC"
-		case "random":
-			if params.Seed != "" {
-				// Use seeded random for reproducible results
-				orderBy = fmt.Sprintf("ORDER BY (id * %s) %% 1000000", params.Seed)
-			} else {
-				orderBy = "ORDER BY RANDOM()"
-			}
-		default:
-			orderBy = "ORDER BY RANDOM()"
+		sort, page, ok := listPage(c, &params)
+		if !ok {
+			return
 		}
 
 		// Build filter conditions using shared utilities
-		filterConditions := utils.BuildFilterConditionsFromParams(params)
-		whereClause, queryArgs := utils.CombineFilterConditions(filterConditions)
-		countArgs := queryArgs // Same args for both queries
-
-		// Get total count with filters
-		var totalCount int
-		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM images %s", whereClause)
-		err := db.QueryRow(countQuery, countArgs...).Scan(&totalCount)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count images"})
+		filterConditions, ok := buildFilterConditions(c, params)
+		if !ok {
 			return
 		}
 
-		// Get images with pagination and filters
-		query := fmt.Sprintf(`
-			SELECT id, phash, filename, width, height, favorite, like_count, rating
-			FROM images
-			%s
-			%s
-			LIMIT ? OFFSET ?
-		`, whereClause, orderBy)
-
-		// Add limit and offset to query args
-		queryArgs = append(queryArgs, params.Limit, offset)
-		rows, err := db.Query(query, queryArgs...)
+		q := query.ImageQuery{Filters: filterConditions, Sort: sort, Page: page}
+		images, totalCount, err := database.FindImages(db, q)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
 			return
 		}
-		defer rows.Close()
-
-		var images []database.ImageResult
-		for rows.Next() {
-			var img database.ImageResult
-			err := rows.Scan(&img.ID, &img.Phash, &img.Filename, &img.Width, &img.Height, &img.Favorite, &img.Likes, &img.Rating)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan image"})
-				return
-			}
-			images = append(images, img)
-		}
-
-		totalPages := (totalCount + params.Limit - 1) / params.Limit
 
-		c.JSON(http.StatusOK, gin.H{
-			"images": images,
-			"pagination": gin.H{
-				"current_page": params.Page,
-				"total_pages":  totalPages,
-				"total_count":  totalCount,
-				"limit":        params.Limit,
-			},
-		})
+		respondImagePage(c, db, q, params, images, totalCount)
 	}
 }
 
@@ -121,15 +65,49 @@ func GetImageByIDHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		if img == nil {
+		// Trashed images are only served when asked for
+		if img == nil || img.DeletedAt != nil && c.Query("include_deleted") != "true" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
 			return
 		}
 
+		img.Tags = database.FilterTagsByConfidence(img.Tags, utils.ParseMinConfidence(c))
+
 		c.JSON(http.StatusOK, img)
 	}
 }
 
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// GetImageBySHA256Handler looks an image up by the SHA-256 of its file
+func GetImageBySHA256Handler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sha := c.Param("sha")
+		if !sha256Pattern.MatchString(sha) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid SHA-256, expected 64 hex characters"})
+			return
+		}
+
+		img, err := database.GetImageBySHA256(db, sha)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image"})
+			return
+		}
+
+		// Trashed images are only served when asked for
+		if img == nil || img.DeletedAt != nil && c.Query("include_deleted") != "true" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+
+		img.Tags = database.FilterTagsByConfidence(img.Tags, utils.ParseMinConfidence(c))
+
+		c.JSON(http.StatusOK, img)
+	}
+}
+
+// GetImagesByTagsHandler lists images with the tags of ?tags=, of any category. ?match= says how many
+// of them an image needs and defaults to all, while the *_match of the include filters default to any.
 func GetImagesByTagsHandler(db *sql.DB) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		tagsParam := ctx.Query("tags")
@@ -140,37 +118,58 @@ func GetImagesByTagsHandler(db *sql.DB) gin.HandlerFunc {
 
 		// Parse query parameters using shared utility
 		params := utils.ParseImageQueryParams(ctx)
+		sort, page, ok := listPage(ctx, &params)
+		if !ok {
+			return
+		}
+
+		var tagList []string
+		seen := map[string]bool{}
+		for _, tag := range strings.Split(tagsParam, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				tagList = append(tagList, tag)
+			}
+		}
+		if len(tagList) == 0 {
+			ctx.JSON(400, gin.H{"error": "Missing tags parameter"})
+			return
+		}
 
-		tagList := strings.Split(tagsParam, ",")
-		for i := range tagList {
-			tagList[i] = strings.TrimSpace(tagList[i])
+		// Images need every tag unless ?match= says any or at least N
+		mode, err := utils.ParseMatchMode(ctx.Query("match"), utils.MatchAll)
+		if err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
 
-		// Parse filter arrays using shared utility
-		includeCharactersList, excludeCharactersList, includeTagsList, excludeTagsList, includeExplicitnessList, excludeExplicitnessList, includeSeriesList, excludeSeriesList, includeArtistsList, excludeArtistsList := utils.ParseFilterArrays(params)
+		// Build filter conditions using shared utilities
+		filterConditions, ok := buildFilterConditions(ctx, params)
+		if !ok {
+			return
+		}
 
-		// Get paginated results
-		results, totalCount, err := database.GetImagesByTagsPaginated(db, tagList, params.Page, params.Limit, params.SortBy, params.Seed, includeCharactersList, excludeCharactersList, includeTagsList, excludeTagsList, includeExplicitnessList, excludeExplicitnessList, includeSeriesList, excludeSeriesList, includeArtistsList, excludeArtistsList)
+		// An alias and its tag are the same tag
+		tagList, err = database.ResolveTagAliases(db, tagList)
 		if err != nil {
 			ctx.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
+		filterConditions = append(filterConditions, query.HasTags(tagList, mode, params.MinConfidence))
 
-		totalPages := (totalCount + params.Limit - 1) / params.Limit
+		q := query.ImageQuery{Filters: filterConditions, Sort: sort, Page: page}
+		results, totalCount, err := database.FindImages(db, q)
+		if err != nil {
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
 
-		ctx.JSON(200, gin.H{
-			"images": results,
-			"pagination": gin.H{
-				"current_page": params.Page,
-				"total_pages":  totalPages,
-				"total_count":  totalCount,
-				"limit":        params.Limit,
-			},
-		})
+		respondImagePage(ctx, db, q, params, results, totalCount)
 	}
 }
 
-func ServeImageFileHandler() gin.HandlerFunc {
+func ServeImageFileHandler(cfg *config.Config) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		filename := ctx.Param("filename")
 		if filename == "" {
@@ -184,7 +183,7 @@ func ServeImageFileHandler() gin.HandlerFunc {
 		
 		// If no size specified or size is "original", serve original image
 		if size == "" || size == "original" {
-			fullPath := filepath.Join("./gallery", filename)
+			fullPath := filepath.Join(cfg.GalleryDir, filename)
 			if _, err := os.Stat(fullPath); err != nil {
 				ctx.JSON(404, gin.H{"error": "file not found"})
 				return
@@ -211,14 +210,14 @@ func ServeImageFileHandler() gin.HandlerFunc {
 		}
 		
 		// Check if original image exists
-		originalPath := filepath.Join("./gallery", filename)
+		originalPath := filepath.Join(cfg.GalleryDir, filename)
 		if _, err := os.Stat(originalPath); err != nil {
 			ctx.JSON(404, gin.H{"error": "original file not found"})
 			return
 		}
 		
 		// Generate thumbnail if needed
-		thumbnailPath, err := images.GenerateThumbnailIfNeeded(originalPath, filename, size)
+		thumbnailPath, err := images.GenerateThumbnailIfNeeded(cfg.ThumbnailsDir, originalPath, filename, size)
 		if err != nil {
 			// If thumbnail generation fails, fall back to original
 			fmt.Printf("Failed to generate thumbnail for %s (size: %s): %v\n", filename, size, err)
@@ -271,7 +270,7 @@ func UpdateSingleImageFieldHandler[T any](db *sql.DB, fieldName string) gin.Hand
 	}
 }
 
-func AddTagToImageHandler(db *sql.DB) gin.HandlerFunc {
+func AddTagToImageHandler(db *sql.DB, index *tagindex.Index) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		imageID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
@@ -294,12 +293,13 @@ func AddTagToImageHandler(db *sql.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		index.Invalidate()
 
 		c.JSON(http.StatusOK, gin.H{"status": "tag added", "tag": body.Tag})
 	}
 }
 
-func RemoveTagFromImageHandler(db *sql.DB) gin.HandlerFunc {
+func RemoveTagFromImageHandler(db *sql.DB, index *tagindex.Index) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		imageID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
@@ -321,80 +321,93 @@ func RemoveTagFromImageHandler(db *sql.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		index.Invalidate()
 
 		c.JSON(http.StatusOK, gin.H{"status": "tag removed", "tag": body.Tag})
 	}
 }
 
-// moves images with raw file names to gallery
-func OrganizeImagesHandler() gin.HandlerFunc {
+// queues a job that moves images with raw file names to gallery.
+// With ?duplicate_distance=N, near duplicates of gallery images are flagged instead of moved.
+func OrganizeImagesHandler(db *sql.DB, cfg *config.Config, jobManager *jobs.Manager) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		err := images.OrganizeImages("./raw_images", "./gallery")
+		duplicateDistance, err := strconv.Atoi(ctx.DefaultQuery("duplicate_distance", "0"))
+		if err != nil || duplicateDistance < 0 || duplicateDistance > 64 {
+			ctx.JSON(400, gin.H{"error": "duplicate_distance must be between 0 and 64"})
+			return
+		}
+
+		run, job, err := enqueueImportRun(db, jobManager, nil, "organize", nil, "", func(jobCtx context.Context, r *ingest.Report) error {
+			return ingest.Organize(jobCtx, db, cfg.RawDir, cfg.GalleryDir, duplicateDistance, r)
+		})
 		if err != nil {
 			ctx.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		ctx.JSON(200, gin.H{"status": "Images processed successfully"})
+		respondImportRun(ctx, db, jobManager, run, job, "Organize job queued")
 	}
 }
 
-// populates database by cross referencing images and text files
-func PopulateDatabaseHanlder(db *sql.DB) gin.HandlerFunc {
+// queues a job that populates database by cross referencing images and text files.
+// Every file's outcome is recorded in an import run, see GetImportRunHandler.
+// ?model= names the tagger that wrote the files. With ?retag=true images that already have
+// a row get their model tags replaced, keeping the tags the user added or removed.
+func PopulateDatabaseHanlder(db *sql.DB, cfg *config.Config, jobManager *jobs.Manager, index *tagindex.Index) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		tagMap, err := images.LoadTagCategoryMapping("./tag_to_category.json")
-		if err != nil {
-			ctx.JSON(500, gin.H{"error": "Failed to load tag metadata"})
-			return
+		opts := ingest.ImportOptions{
+			Model: strings.TrimSpace(ctx.Query("model")),
+			Retag: ctx.Query("retag") == "true",
+		}
+
+		runType := "import"
+		if opts.Retag {
+			runType = "retag"
 		}
 
-		txtDir := "./raw_txt_files"
-		files, err := os.ReadDir(txtDir)
+		run, job, err := enqueueImportRun(db, jobManager, index, runType, nil, opts.Model, func(jobCtx context.Context, r *ingest.Report) error {
+			return ingest.ImportTagFiles(jobCtx, db, cfg.TxtDir, cfg.GalleryDir, cfg.TagMapPath, cfg.ThresholdsPath, opts, r)
+		})
 		if err != nil {
-			ctx.JSON(500, gin.H{"error": "Failed to read tag files"})
+			ctx.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
+		respondImportRun(ctx, db, jobManager, run, job, "Import job queued")
+	}
+}
 
-		for _, file := range files {
-			if file.IsDir() || !strings.HasSuffix(file.Name(), ".txt") {
-				continue
-			}
-
-			phash := strings.TrimSuffix(file.Name(), ".txt")
-
-			exists, err := database.ImageExists(db, phash)
-			if err != nil || exists {
-				continue
-			}
-
-			tagFilePath := filepath.Join(txtDir, file.Name())
-			tags, err := images.LoadTagsFromFile(tagFilePath)
-			if err != nil {
-				continue
-			}
-
-			// Get image dimensions
-			imagePath, err := database.FindImageFile(phash)
-			if err != nil {
-				fmt.Printf("Could not find image for %s: %v\n", phash, err)
-				continue
-			}
-
-			width, height, err := images.GetImageDimensions(imagePath)
-			if err != nil {
-				fmt.Printf("Failed to read image dimensions for %s: %v\n", phash, err)
-				continue
-			}
+// queues a job that runs the tagger on gallery images that have no row or no tags
+func TagImagesHandler(db *sql.DB, cfg *config.Config, jobManager *jobs.Manager, imageTagger tagger.Tagger, index *tagindex.Index) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if imageTagger == nil {
+			ctx.JSON(503, gin.H{"error": "No tagger configured, start the server with -tagger"})
+			return
+		}
 
-			// Insert into DB
-			err = database.InsertImageWithTags(db, phash, tags, tagMap, width, height)
-			if err != nil {
-				fmt.Printf("Failed to insert image %s: %v\n", phash, err)
-				continue
-			}
+		run, job, err := enqueueImportRun(db, jobManager, index, "tag", nil, imageTagger.Model(), func(jobCtx context.Context, r *ingest.Report) error {
+			return ingest.TagUntagged(jobCtx, db, cfg.GalleryDir, imageTagger, cfg.TagMapPath, cfg.ThresholdsPath, r)
+		})
+		if err != nil {
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
 		}
+		respondImportRun(ctx, db, jobManager, run, job, "Tag job queued")
+	}
+}
 
-		ctx.JSON(200, gin.H{"status": "Imported images from tag files"})
+// AnalyzeColorsHandler queues a job that extracts the dominant colors of images analyzed before
+// palettes existed. ?all=true analyzes every image again.
+func AnalyzeColorsHandler(db *sql.DB, cfg *config.Config, jobManager *jobs.Manager) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		all := ctx.Query("all") == "true"
 
+		run, job, err := enqueueImportRun(db, jobManager, nil, "colors", nil, "", func(jobCtx context.Context, r *ingest.Report) error {
+			return ingest.AnalyzeColors(jobCtx, db, cfg.GalleryDir, all, r)
+		})
+		if err != nil {
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		respondImportRun(ctx, db, jobManager, run, job, "Color analysis queued")
 	}
 }
 
@@ -407,7 +420,7 @@ type ExportImagesRequest struct {
 }
 
 // ExportImagesHandler handles exporting images to a directory
-func ExportImagesHandler(db *sql.DB) gin.HandlerFunc {
+func ExportImagesHandler(db *sql.DB, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ExportImagesRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -421,7 +434,7 @@ func ExportImagesHandler(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Create exports directory if it doesn't exist
-		exportsDir := "exports"
+		exportsDir := cfg.ExportsDir
 		if err := os.MkdirAll(exportsDir, 0755); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create exports directory"})
 			return
@@ -443,18 +456,21 @@ func ExportImagesHandler(db *sql.DB) gin.HandlerFunc {
 			existingFiles = getExistingFiles(exportPath)
 		}
 
+		// Images that are missing or in the trash have no file to export
+		exportImages, _, err := database.FindImages(db, query.ImageQuery{
+			Filters: []utils.FilterCondition{query.WithIDs(req.Images), utils.BuildNotDeletedFilterCondition()},
+			Sort:    utils.BuildSort("date_asc", ""),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+
 		// Export images
 		exportedCount := 0
 		skippedCount := 0
 		
-		for _, imageID := range req.Images {
-			// Get image details from database
-			image, err := database.GetImageByID(db, imageID)
-			if err != nil {
-				fmt.Printf("Failed to get image %d: %v\n", imageID, err)
-				continue
-			}
-
+		for _, image := range exportImages {
 			// Skip if update_only and file already exists
 			if req.UpdateOnly && existingFiles[image.Filename] {
 				skippedCount++
@@ -462,7 +478,7 @@ func ExportImagesHandler(db *sql.DB) gin.HandlerFunc {
 			}
 
 			// Copy image file
-			sourcePath := filepath.Join("gallery", image.Filename)
+			sourcePath := filepath.Join(cfg.GalleryDir, image.Filename)
 			destPath := filepath.Join(exportPath, image.Filename)
 
 			if err := copyFile(sourcePath, destPath); err != nil {
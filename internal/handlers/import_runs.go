@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/ingest"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/gin-gonic/gin"
+)
+
+// enqueueImportRun records a new import run and queues a job that executes fn as that run.
+// Runs that change tags pass the tag index, which is invalidated when the job finishes.
+func enqueueImportRun(db *sql.DB, jobManager *jobs.Manager, index *tagindex.Index, runType string, retryOf *int64, model string, fn func(ctx context.Context, r *ingest.Report) error) (*database.ImportRun, database.Job, error) {
+	run, err := database.CreateImportRun(db, runType, retryOf, model)
+	if err != nil {
+		return nil, database.Job{}, err
+	}
+
+	job, err := jobManager.EnqueueWithDone(runType, func(jobCtx context.Context, p *jobs.Progress) error {
+		return ingest.RunReported(jobCtx, db, run.ID, p, func(r *ingest.Report) error {
+			return fn(jobCtx, r)
+		})
+	}, func(job database.Job) {
+		// A job cancelled or dropped while queued never started the run, so finish it here
+		if err := database.FinishQueuedImportRun(db, run.ID, job.Status, job.Error); err != nil {
+			fmt.Printf("Failed to finish import run %d: %v\n", run.ID, err)
+		}
+		index.Invalidate()
+	})
+	if err != nil {
+		database.FinishImportRun(db, run.ID, database.JobFailed, err.Error())
+		return nil, database.Job{}, err
+	}
+
+	if err := database.SetImportRunJob(db, run.ID, job.ID); err != nil {
+		return nil, database.Job{}, err
+	}
+	run.JobID = &job.ID
+
+	return run, job, nil
+}
+
+// respondImportRun answers 202 with the job and run IDs, or with ?wait=true blocks until
+// the job finishes and answers with the run and its per-outcome summary
+func respondImportRun(c *gin.Context, db *sql.DB, jobManager *jobs.Manager, run *database.ImportRun, job database.Job, status string) {
+	if c.Query("wait") != "true" {
+		c.JSON(202, gin.H{"status": status, "job_id": job.ID, "run_id": run.ID})
+		return
+	}
+
+	updates, unsubscribe, err := jobManager.Subscribe(job.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to follow job"})
+		return
+	}
+	defer unsubscribe()
+
+	for finished := false; !finished; {
+		select {
+		case update, ok := <-updates:
+			finished = !ok || update.Finished()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	finishedRun, err := database.GetImportRunByID(db, run.ID)
+	if err != nil || finishedRun == nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch import run"})
+		return
+	}
+	c.JSON(200, gin.H{"job_id": job.ID, "run": finishedRun})
+}
+
+func GetImportRunsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limit < 1 || limit > 500 {
+			limit = 50
+		}
+
+		runs, err := database.GetImportRuns(db, c.Query("type"), limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch import runs"})
+			return
+		}
+
+		c.JSON(200, gin.H{"runs": runs})
+	}
+}
+
+// GetImportRunHandler returns a run with its files. ?outcome= takes a comma separated list
+// of outcomes, or "failed" for every failed outcome.
+func GetImportRunHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid import run ID"})
+			return
+		}
+
+		run, err := database.GetImportRunByID(db, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch import run"})
+			return
+		}
+		if run == nil {
+			c.JSON(404, gin.H{"error": "Import run not found"})
+			return
+		}
+
+		var outcomes []string
+		for _, outcome := range strings.Split(c.Query("outcome"), ",") {
+			switch outcome = strings.TrimSpace(outcome); outcome {
+			case "":
+			case "failed":
+				outcomes = append(outcomes, database.FailedOutcomes...)
+			default:
+				outcomes = append(outcomes, outcome)
+			}
+		}
+
+		files, err := database.GetImportRunFiles(db, id, outcomes)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch import run files"})
+			return
+		}
+
+		c.JSON(200, gin.H{"run": run, "files": files})
+	}
+}
+
+// RetryImportRunHandler queues a new run of the same type over the failed files of a run
+// that have not been retried yet. Organize retries do not check for near duplicates.
+func RetryImportRunHandler(db *sql.DB, cfg *config.Config, jobManager *jobs.Manager, imageTagger tagger.Tagger, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid import run ID"})
+			return
+		}
+
+		run, err := database.GetImportRunByID(db, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch import run"})
+			return
+		}
+		if run == nil {
+			c.JSON(404, gin.H{"error": "Import run not found"})
+			return
+		}
+		if run.Status == database.JobQueued || run.Status == database.JobRunning {
+			c.JSON(409, gin.H{"error": "Import run has not finished"})
+			return
+		}
+
+		failedFiles, err := database.GetImportRunFiles(db, id, database.FailedOutcomes)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch import run files"})
+			return
+		}
+
+		paths, fileIDs := unretriedFiles(failedFiles)
+		if len(paths) == 0 {
+			c.JSON(400, gin.H{"error": "No failed files left to retry"})
+			return
+		}
+
+		var retry func(ctx context.Context, r *ingest.Report) error
+		switch run.Type {
+		case "organize":
+			retry = func(ctx context.Context, r *ingest.Report) error {
+				return ingest.OrganizeFiles(ctx, db, paths, cfg.GalleryDir, 0, r)
+			}
+		case "import", "retag":
+			opts := ingest.ImportOptions{Model: run.Model, Retag: run.Type == "retag"}
+			retry = func(ctx context.Context, r *ingest.Report) error {
+				return ingest.ImportFiles(ctx, db, paths, cfg.GalleryDir, cfg.TagMapPath, cfg.ThresholdsPath, opts, r)
+			}
+		case "tag":
+			if imageTagger == nil {
+				c.JSON(503, gin.H{"error": "No tagger configured, start the server with -tagger"})
+				return
+			}
+			retry = func(ctx context.Context, r *ingest.Report) error {
+				return ingest.TagFiles(ctx, db, paths, imageTagger, cfg.TagMapPath, cfg.ThresholdsPath, r)
+			}
+		case "colors":
+			retry = func(ctx context.Context, r *ingest.Report) error {
+				return ingest.AnalyzeColorFiles(ctx, db, paths, r)
+			}
+		default:
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Cannot retry %s runs", run.Type)})
+			return
+		}
+
+		retryRun, job, err := enqueueImportRun(db, jobManager, index, run.Type, &run.ID, run.Model, retry)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := database.MarkImportRunFilesRetried(db, fileIDs, retryRun.ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		respondImportRun(c, db, jobManager, retryRun, job, "Retry job queued")
+	}
+}
+
+// unretriedFiles returns the paths and IDs of the entries no later run has taken over yet
+func unretriedFiles(files []database.ImportRunFile) ([]string, []int64) {
+	var paths []string
+	var fileIDs []int64
+	for _, file := range files {
+		if file.RetriedBy == nil {
+			paths = append(paths, file.Path)
+			fileIDs = append(fileIDs, file.ID)
+		}
+	}
+	return paths, fileIDs
+}
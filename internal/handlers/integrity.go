@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/ingest"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+// CheckIntegrityHandler queues a scan comparing the database with the gallery and thumbnails.
+// Problems are recorded as an "integrity" import run, one entry per file and issue.
+// ?hashes=true also recomputes every phash, which decodes each image.
+func CheckIntegrityHandler(db *sql.DB, cfg *config.Config, jobManager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts := ingest.IntegrityOptions{CheckHashes: c.Query("hashes") == "true"}
+
+		run, job, err := enqueueImportRun(db, jobManager, nil, "integrity", nil, "", func(ctx context.Context, r *ingest.Report) error {
+			return ingest.CheckIntegrity(ctx, db, cfg.GalleryDir, cfg.ThumbnailsDir, opts, r)
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		respondImportRun(c, db, jobManager, run, job, "Integrity scan queued")
+	}
+}
+
+// RepairIntegrityHandler queues a "repair" run over the entries of one ?issue= of an integrity run:
+// missing_file flags the rows, orphan_file imports the files, stale_thumbnail deletes them,
+// dimension_mismatch and phash_mismatch read the values again from the files.
+func RepairIntegrityHandler(db *sql.DB, cfg *config.Config, jobManager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid import run ID"})
+			return
+		}
+
+		issue := c.Query("issue")
+		if !database.IsIntegrityIssue(issue) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("issue must be one of %v", database.IntegrityIssues)})
+			return
+		}
+
+		run, err := database.GetImportRunByID(db, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch import run"})
+			return
+		}
+		if run == nil || run.Type != "integrity" {
+			c.JSON(404, gin.H{"error": "Integrity run not found"})
+			return
+		}
+		if run.Status == database.JobQueued || run.Status == database.JobRunning {
+			c.JSON(409, gin.H{"error": "Integrity scan has not finished"})
+			return
+		}
+
+		files, err := database.GetImportRunFiles(db, id, []string{issue})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch import run files"})
+			return
+		}
+
+		paths, fileIDs := unretriedFiles(files)
+		if len(paths) == 0 {
+			c.JSON(400, gin.H{"error": "No unrepaired entries for this issue"})
+			return
+		}
+
+		repairRun, job, err := enqueueImportRun(db, jobManager, nil, "repair", &run.ID, "", func(ctx context.Context, r *ingest.Report) error {
+			return ingest.RepairIssues(ctx, db, issue, paths, cfg.GalleryDir, cfg.ThumbnailsDir, r)
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := database.MarkImportRunFilesRetried(db, fileIDs, repairRun.ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		respondImportRun(c, db, jobManager, repairRun, job, "Repair job queued")
+	}
+}
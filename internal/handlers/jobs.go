@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+func GetJobsHandler(jobManager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limit < 1 || limit > 500 {
+			limit = 50
+		}
+
+		jobList, err := jobManager.List(c.Query("type"), limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch jobs"})
+			return
+		}
+
+		c.JSON(200, gin.H{"jobs": jobList})
+	}
+}
+
+func GetJobHandler(jobManager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid job ID"})
+			return
+		}
+
+		job, err := jobManager.Get(id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch job"})
+			return
+		}
+
+		if job == nil {
+			c.JSON(404, gin.H{"error": "Job not found"})
+			return
+		}
+
+		c.JSON(200, job)
+	}
+}
+
+// StreamJobHandler sends a "progress" server-sent event on every update until the job finishes
+func StreamJobHandler(jobManager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid job ID"})
+			return
+		}
+
+		updates, unsubscribe, err := jobManager.Subscribe(id)
+		if err != nil {
+			if errors.Is(err, jobs.ErrJobNotFound) {
+				c.JSON(404, gin.H{"error": "Job not found"})
+				return
+			}
+			c.JSON(500, gin.H{"error": "Failed to fetch job"})
+			return
+		}
+		defer unsubscribe()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case job, ok := <-updates:
+				if !ok {
+					return false
+				}
+				c.SSEvent("progress", job)
+				return !job.Finished()
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+func CancelJobHandler(jobManager *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid job ID"})
+			return
+		}
+
+		err = jobManager.Cancel(id)
+		switch {
+		case errors.Is(err, jobs.ErrJobNotFound):
+			c.JSON(404, gin.H{"error": "Job not found"})
+		case errors.Is(err, jobs.ErrJobFinished):
+			c.JSON(409, gin.H{"error": "Job already finished"})
+		case err != nil:
+			c.JSON(500, gin.H{"error": "Failed to cancel job"})
+		default:
+			c.JSON(200, gin.H{"status": "Cancellation requested", "job_id": id})
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/query"
+	"github.com/brayanMuniz/AGO/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// listPage reads which page of a list to return: the one next to ?cursor=, a token from an earlier
+// response, or else ?page=. A cursor brings back the sort and seed it was made with.
+// It responds with 400 and returns false when the cursor is invalid or no longer fits the sort.
+func listPage(c *gin.Context, params *utils.ImageQueryParams) (utils.Sort, utils.Page, bool) {
+	page := utils.Page{Number: params.Page, Limit: params.Limit}
+	if params.Cursor != "" {
+		cursor, err := utils.DecodeCursor(params.Cursor)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return utils.Sort{}, page, false
+		}
+		params.SortBy, params.Seed = cursor.Sort, cursor.Seed
+		page.Cursor = cursor
+	}
+
+	sort := utils.BuildSortFromParams(*params)
+	if !sort.Matches(page.Cursor) {
+		c.JSON(400, gin.H{"error": "cursor does not fit the sort, start again without it"})
+		return sort, page, false
+	}
+	return sort, page, true
+}
+
+// paginationJSON describes a finished page: page numbers for lists read by ?page=,
+// and the cursors of the neighboring pages, empty at either end
+func paginationJSON(sort utils.Sort, page utils.Page, totalCount int, prev, next string) gin.H {
+	pagination := gin.H{
+		"total_pages": (totalCount + page.Limit - 1) / page.Limit,
+		"total_count": totalCount,
+		"limit":       page.Limit,
+		"prev_cursor": prev,
+		"next_cursor": next,
+		"sort":        sort.Name,
+	}
+	if page.Cursor == nil {
+		pagination["current_page"] = page.Number
+	}
+	if sort.Seed != "" {
+		pagination["seed"] = sort.Seed
+	}
+	return pagination
+}
+
+// respondImagePage answers with the page of images q read, as returned by the database with
+// one row past the limit when more follow, and the facets params asks for
+func respondImagePage(c *gin.Context, db *sql.DB, q query.ImageQuery, params utils.ImageQueryParams, images []database.ImageResult, totalCount int) {
+	images, more := utils.FinishPage(images, q.Page)
+	if err := database.AttachPalettes(db, images); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch palettes"})
+		return
+	}
+
+	prev, next := utils.PageCursors(q.Sort, q.Page, images, more)
+	response := gin.H{
+		"images":     images,
+		"pagination": paginationJSON(q.Sort, q.Page, totalCount, prev, next),
+	}
+
+	if len(params.Facets) > 0 {
+		facets, err := imageFacets(db, q, params)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to count facets"})
+			return
+		}
+		response["facets"] = facets
+	}
+
+	c.JSON(200, response)
+}
+
+// imageFacets counts the most common tags of the categories ?facets= names across every image
+// q matches, keyed by the names the request used so they can go straight back into include filters
+func imageFacets(db *sql.DB, q query.ImageQuery, params utils.ImageQueryParams) (gin.H, error) {
+	categories := make([]string, len(params.Facets))
+	for i, name := range params.Facets {
+		categories[i] = utils.TagCategories[name]
+	}
+
+	counts, err := database.GetFacets(db, q, categories, params.FacetLimit, params.MinConfidence)
+	if err != nil {
+		return nil, err
+	}
+
+	facets := gin.H{}
+	for i, name := range params.Facets {
+		facets[name] = counts[categories[i]]
+	}
+	return facets, nil
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/brayanMuniz/AGO/internal/search"
+	"github.com/brayanMuniz/AGO/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// buildFilterConditions is utils.BuildFilterConditionsFromParams plus the condition of the q search.
+// It responds with 400 and returns false when params are invalid, or with the error's position when q does not parse.
+func buildFilterConditions(c *gin.Context, params utils.ImageQueryParams) ([]utils.FilterCondition, bool) {
+	if err := params.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	filterConditions := utils.BuildFilterConditionsFromParams(params)
+
+	condition, err := search.CompileQuery(params.Query, params.MinConfidence)
+	if err != nil {
+		var parseErr *search.ParseError
+		if errors.As(err, &parseErr) {
+			c.JSON(400, gin.H{"error": parseErr.Error(), "position": parseErr.Pos})
+		} else {
+			c.JSON(400, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+	if condition.SQL != "" {
+		filterConditions = append(filterConditions, utils.FilterCondition{SQL: "(" + condition.SQL + ")", Args: condition.Args})
+	}
+	return filterConditions, true
+}
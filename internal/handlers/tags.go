@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/brayanMuniz/AGO/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// SearchTagsHandler drives tag autocompletion: ?q= is matched by prefix, substring and typo tolerance,
+// ?category= narrows the search and ?limit= caps the results (10 by default, at most 100)
+func SearchTagsHandler(index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 10
+		}
+
+		category := c.Query("category")
+		if mapped, ok := utils.TagCategories[category]; ok {
+			category = mapped
+		}
+
+		results, err := index.Search(c.Query("q"), category, limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to search tags"})
+			return
+		}
+		if results == nil {
+			results = []tagindex.Result{}
+		}
+
+		c.JSON(200, gin.H{"tags": results})
+	}
+}
+
+// implicationChunkSize is how many images an implication job updates per statement
+const implicationChunkSize = 100
+
+type tagAliasBody struct {
+	Alias string `json:"alias"`
+	Tag   string `json:"tag"`
+}
+
+type tagImplicationBody struct {
+	Tag        string `json:"tag"`
+	ImpliedTag string `json:"implied_tag"`
+}
+
+// respondTagRuleError answers with 404 for unknown tags, 400 for rules that cannot be made and 500 otherwise
+func respondTagRuleError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, database.ErrTagNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrInvalidTagRule):
+		c.JSON(400, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": fallback})
+	}
+}
+
+func GetTagAliasesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		aliases, err := database.GetTagAliases(db)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch tag aliases"})
+			return
+		}
+		c.JSON(200, gin.H{"aliases": aliases})
+	}
+}
+
+// CreateTagAliasHandler makes alias stand for tag. An existing tag named alias is merged into tag.
+func CreateTagAliasHandler(db *sql.DB, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body tagAliasBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid JSON body"})
+			return
+		}
+		body.Alias, body.Tag = strings.TrimSpace(body.Alias), strings.TrimSpace(body.Tag)
+		if body.Alias == "" || body.Tag == "" {
+			c.JSON(400, gin.H{"error": "Alias and tag are required"})
+			return
+		}
+
+		alias, err := database.CreateTagAlias(db, body.Alias, body.Tag)
+		if err != nil {
+			respondTagRuleError(c, err, "Failed to create tag alias")
+			return
+		}
+		index.Invalidate()
+
+		c.JSON(201, alias)
+	}
+}
+
+func DeleteTagAliasHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleted, err := database.DeleteTagAlias(db, c.Param("alias"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to delete tag alias"})
+			return
+		}
+		if !deleted {
+			c.JSON(404, gin.H{"error": "Tag alias not found"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Tag alias deleted"})
+	}
+}
+
+func GetTagImplicationsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		implications, err := database.GetTagImplications(db)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch tag implications"})
+			return
+		}
+		c.JSON(200, gin.H{"implications": implications})
+	}
+}
+
+// CreateTagImplicationHandler makes tag imply implied_tag from now on and queues a job
+// that adds implied_tag to the images that already have tag
+func CreateTagImplicationHandler(db *sql.DB, jobManager *jobs.Manager, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body tagImplicationBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid JSON body"})
+			return
+		}
+		body.Tag, body.ImpliedTag = strings.TrimSpace(body.Tag), strings.TrimSpace(body.ImpliedTag)
+		if body.Tag == "" || body.ImpliedTag == "" {
+			c.JSON(400, gin.H{"error": "Tag and implied_tag are required"})
+			return
+		}
+
+		implication, err := database.CreateTagImplication(db, body.Tag, body.ImpliedTag)
+		if err != nil {
+			respondTagRuleError(c, err, "Failed to create tag implication")
+			return
+		}
+
+		job, err := jobManager.EnqueueWithDone("implications", func(ctx context.Context, p *jobs.Progress) error {
+			return applyTagImplication(ctx, db, implication.TagID, p)
+		}, func(database.Job) { index.Invalidate() })
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to queue implication job"})
+			return
+		}
+
+		c.JSON(202, gin.H{"implication": implication, "job_id": job.ID})
+	}
+}
+
+// applyTagImplication adds the tags tagID implies to every image that has it
+func applyTagImplication(ctx context.Context, db *sql.DB, tagID int64, p *jobs.Progress) error {
+	imageIDs, err := database.GetImageIDsWithTag(db, tagID)
+	if err != nil {
+		return err
+	}
+	p.SetTotal(len(imageIDs))
+
+	for start := 0; start < len(imageIDs); start += implicationChunkSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunk := imageIDs[start:min(start+implicationChunkSize, len(imageIDs))]
+		p.Start(fmt.Sprintf("image %d", chunk[0]))
+		if err := database.ApplyTagImplications(db, chunk); err != nil {
+			return err
+		}
+		for _, id := range chunk {
+			p.Processed(fmt.Sprintf("image %d", id))
+		}
+	}
+	return nil
+}
+
+// DeleteTagImplicationHandler stops tag from implying implied_tag, tags it already added are kept
+func DeleteTagImplicationHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body tagImplicationBody
+		if err := c.ShouldBindJSON(&body); err != nil || body.Tag == "" || body.ImpliedTag == "" {
+			c.JSON(400, gin.H{"error": "Tag and implied_tag are required"})
+			return
+		}
+
+		deleted, err := database.DeleteTagImplication(db, body.Tag, body.ImpliedTag)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to delete tag implication"})
+			return
+		}
+		if !deleted {
+			c.JSON(404, gin.H{"error": "Tag implication not found"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Tag implication deleted"})
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/ingest"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/brayanMuniz/AGO/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var errNotFound = errors.New("not found")
+
+// DeleteImageHandler moves an image to the trash: it disappears from every list
+// and its file moves to the trash folder until it is restored or purged
+func DeleteImageHandler(db *sql.DB, cfg *config.Config, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid image ID"})
+			return
+		}
+
+		if err := trashImage(db, cfg, id); err != nil {
+			if errors.Is(err, errNotFound) {
+				c.JSON(404, gin.H{"error": "Image not found"})
+				return
+			}
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		index.Invalidate()
+
+		c.JSON(200, gin.H{"deleted": []int{id}})
+	}
+}
+
+// DeleteImagesRequest lists the images a bulk delete moves to the trash
+type DeleteImagesRequest struct {
+	ImageIDs []int `json:"image_ids"`
+}
+
+// DeleteImagesHandler is DeleteImageHandler for several images, reporting the ones that failed
+func DeleteImagesHandler(db *sql.DB, cfg *config.Config, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DeleteImagesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if len(req.ImageIDs) == 0 {
+			c.JSON(400, gin.H{"error": "No image IDs provided"})
+			return
+		}
+
+		deleted := []int{}
+		failed := []gin.H{}
+		for _, id := range req.ImageIDs {
+			if err := trashImage(db, cfg, id); err != nil {
+				failed = append(failed, gin.H{"id": id, "error": err.Error()})
+				continue
+			}
+			deleted = append(deleted, id)
+		}
+		if len(deleted) > 0 {
+			index.Invalidate()
+		}
+
+		c.JSON(200, gin.H{"deleted": deleted, "failed": failed})
+	}
+}
+
+// trashImage moves the image's file to the trash folder and hides its row.
+// Thumbnails are kept so the trash can still be browsed, purging removes them.
+func trashImage(db *sql.DB, cfg *config.Config, id int) error {
+	img, err := database.GetImageByID(db, id)
+	if err != nil {
+		return err
+	}
+	if img == nil || img.DeletedAt != nil {
+		return fmt.Errorf("image %d %w", id, errNotFound)
+	}
+
+	sourcePath := filepath.Join(cfg.GalleryDir, img.Filename)
+	trashPath, err := images.MoveToTrash(sourcePath, filepath.Join(cfg.TrashDir, "images"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("move image %d to trash: %w", id, err)
+	}
+
+	// A file that was already gone leaves no trash path, only the row is hidden
+	ok, err := database.MarkImageDeleted(db, id, trashPath)
+	if err != nil || !ok {
+		if trashPath != "" {
+			restoreTrashed(map[string]string{sourcePath: trashPath})
+		}
+		if err == nil {
+			err = fmt.Errorf("image %d %w", id, errNotFound)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetTrashHandler lists the images in the trash, most recently deleted first
+func GetTrashHandler(db *sql.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := utils.ParseImageQueryParams(c)
+		page := utils.Page{Number: params.Page, Limit: params.Limit}
+		if params.Cursor != "" {
+			cursor, err := utils.DecodeCursor(params.Cursor)
+			if err != nil || !database.TrashSort.Matches(cursor) {
+				c.JSON(400, gin.H{"error": utils.ErrInvalidCursor.Error()})
+				return
+			}
+			page.Cursor = cursor
+		}
+
+		trashed, total, err := database.GetTrashedImages(db, page)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		trashed, more := utils.FinishPage(trashed, page)
+		prev, next := utils.PageCursors(database.TrashSort, page, trashed, more)
+		c.JSON(200, gin.H{
+			"images":     trashed,
+			"retention":  cfg.TrashRetention.String(),
+			"pagination": paginationJSON(database.TrashSort, page, total, prev, next),
+		})
+	}
+}
+
+// RestoreImageHandler moves a trashed image's file back into the gallery and shows it again
+func RestoreImageHandler(db *sql.DB, cfg *config.Config, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid image ID"})
+			return
+		}
+
+		img, err := database.GetTrashedImage(db, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if img == nil {
+			c.JSON(404, gin.H{"error": "Image not in trash"})
+			return
+		}
+
+		galleryPath := filepath.Join(cfg.GalleryDir, img.Filename)
+		if _, err := os.Stat(galleryPath); err == nil {
+			// The same file may have been organized or uploaded again, then only the row comes back
+			if err := ingest.RestoreFromGallery(db, *img, galleryPath); err != nil {
+				c.JSON(409, gin.H{"error": err.Error()})
+				return
+			}
+		} else {
+			if img.TrashPath != "" {
+				if err := os.Rename(img.TrashPath, galleryPath); err != nil {
+					c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to restore file: %v", err)})
+					return
+				}
+			}
+
+			if err := database.RestoreImage(db, id); err != nil {
+				if img.TrashPath != "" {
+					os.Rename(galleryPath, img.TrashPath)
+				}
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		index.Invalidate()
+
+		restored, err := database.GetImageByID(db, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch image"})
+			return
+		}
+		c.JSON(200, restored)
+	}
+}
+
+// PurgeTrashHandler permanently removes every image deleted more than ?older_than ago,
+// the configured trash retention by default. older_than=0 empties the whole trash.
+func PurgeTrashHandler(db *sql.DB, cfg *config.Config, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		retention := cfg.TrashRetention
+		if v := c.Query("older_than"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				c.JSON(400, gin.H{"error": "older_than must be a duration such as 720h"})
+				return
+			}
+			retention = d
+		}
+
+		// Everything deleted up to now counts when the retention is zero
+		cutoff := time.Now().Add(-retention)
+		if retention == 0 {
+			cutoff = cutoff.Add(time.Second)
+		}
+
+		expired, err := database.GetExpiredTrash(db, cutoff)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		purged := []int{}
+		failed := []gin.H{}
+		for _, img := range expired {
+			if err := purgeImage(db, cfg, img); err != nil {
+				failed = append(failed, gin.H{"id": img.ID, "error": err.Error()})
+				continue
+			}
+			purged = append(purged, img.ID)
+		}
+		if len(purged) > 0 {
+			index.Invalidate()
+		}
+
+		c.JSON(200, gin.H{"purged": purged, "failed": failed})
+	}
+}
+
+// PurgeImageHandler permanently removes one image from the trash regardless of its age
+func PurgeImageHandler(db *sql.DB, cfg *config.Config, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid image ID"})
+			return
+		}
+
+		img, err := database.GetTrashedImage(db, id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if img == nil {
+			c.JSON(404, gin.H{"error": "Image not in trash"})
+			return
+		}
+
+		if err := purgeImage(db, cfg, *img); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		index.Invalidate()
+		c.JSON(200, gin.H{"purged": []int{id}})
+	}
+}
+
+// purgeImage deletes the trashed file and its thumbnails, then the rows
+func purgeImage(db *sql.DB, cfg *config.Config, img database.TrashedImage) error {
+	if img.TrashPath != "" {
+		if err := os.Remove(img.TrashPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove trashed file: %w", err)
+		}
+	}
+	if err := images.RemoveThumbnails(cfg.ThumbnailsDir, img.Filename); err != nil {
+		return fmt.Errorf("remove thumbnails: %w", err)
+	}
+	return database.PurgeImage(db, img.ID)
+}
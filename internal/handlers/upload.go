@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/ingest"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/gin-gonic/gin"
+)
+
+// UploadResult reports what happened to one uploaded file
+type UploadResult struct {
+	Filename string                `json:"filename"`
+	Status   string                `json:"status"` // created, exists, restored or failed
+	Image    *database.ImageResult `json:"image,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+// UploadImagesHandler accepts multipart "files", an optional comma separated "tags" list
+// and an optional manual "album_id". Tags and album only apply to newly created images.
+func UploadImagesHandler(db *sql.DB, cfg *config.Config, index *tagindex.Index) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart form"})
+			return
+		}
+
+		files := form.File["files"]
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No files provided"})
+			return
+		}
+
+		var albumID int
+		if albumIDStr := c.PostForm("album_id"); albumIDStr != "" {
+			albumID, err = strconv.Atoi(albumIDStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+				return
+			}
+
+			var albumType string
+			err = db.QueryRow("SELECT type FROM albums WHERE id = ?", albumID).Scan(&albumType)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if albumType != "manual" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Can only add images to manual albums"})
+				return
+			}
+		}
+
+		var tags []database.ImportedTag
+		for _, field := range c.PostFormArray("tags") {
+			for _, tag := range strings.Split(field, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, database.ImportedTag{Name: tag})
+				}
+			}
+		}
+
+		tagMap, err := images.LoadTagCategoryMapping(cfg.TagMapPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tag metadata"})
+				return
+			}
+			tagMap = map[string]string{}
+		}
+
+		galleryDir := cfg.GalleryDir
+		stagingDir := filepath.Join(galleryDir, ".uploads")
+		if err := os.MkdirAll(stagingDir, os.ModePerm); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload directory"})
+			return
+		}
+
+		results := make([]UploadResult, 0, len(files))
+		for _, file := range files {
+			img, status, err := storeUpload(db, file, stagingDir, galleryDir, tags, tagMap, albumID)
+			result := UploadResult{Filename: file.Filename, Status: status, Image: img}
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+			} else if status != "exists" {
+				index.Invalidate()
+			}
+
+			results = append(results, result)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"uploaded": results})
+	}
+}
+
+// storeUpload hashes an uploaded file, returning the existing image when its content is known
+// and otherwise moving it into the gallery under images.GalleryName and creating its row.
+// The status is created, exists, or restored when the content belonged to a trashed image.
+func storeUpload(db *sql.DB, file *multipart.FileHeader, stagingDir, galleryDir string, tags []database.ImportedTag, tagMap map[string]string, albumID int) (*database.ImageResult, string, error) {
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if !images.IsImageFile(file.Filename) {
+		return nil, "", fmt.Errorf("unsupported file type %q", ext)
+	}
+
+	stagedPath, err := saveUpload(file, stagingDir, ext)
+	if err != nil {
+		return nil, "", err
+	}
+	// Removing is a no-op once the file has been moved into the gallery
+	defer os.Remove(stagedPath)
+
+	hash, err := images.HashImage(stagedPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("not a valid image: %w", err)
+	}
+	phash := images.FormatPhash(hash)
+
+	sha, err := images.HashFile(stagedPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Only a byte-identical file counts as already uploaded, a different image with the same
+	// phash gets a name of its own
+	existing, err := database.GetImageBySHA256(db, sha)
+	if err != nil {
+		return nil, "", err
+	}
+	if existing != nil && existing.DeletedAt != nil {
+		// Put the upload where the trashed file was and take the image out of the trash
+		imagePath := filepath.Join(galleryDir, existing.Filename)
+		if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+			if err := os.Rename(stagedPath, imagePath); err != nil {
+				return nil, "", fmt.Errorf("move file: %w", err)
+			}
+		}
+		return restoreUploaded(db, existing.ID, imagePath)
+	}
+	if existing != nil {
+		return existing, "exists", nil
+	}
+
+	// The file may already be in the gallery, with a row from before content hashes were
+	// stored or without one, e.g. organized but not imported
+	name, imagePath, err := images.GalleryName(galleryDir, phash, sha)
+	if err != nil {
+		return nil, "", err
+	}
+	if imagePath != "" {
+		existing, err := database.GetImageByName(db, name)
+		if err != nil {
+			return nil, "", err
+		}
+		if existing != nil && existing.DeletedAt != nil {
+			return restoreUploaded(db, existing.ID, imagePath)
+		}
+		if existing != nil {
+			if err := database.SetImageSHA256(db, int64(existing.ID), sha); err != nil {
+				return nil, "", err
+			}
+			existing.SHA256 = sha
+			return existing, "exists", nil
+		}
+	} else {
+		imagePath = filepath.Join(galleryDir, name+ext)
+		if err := os.Rename(stagedPath, imagePath); err != nil {
+			return nil, "", fmt.Errorf("move file: %w", err)
+		}
+
+		// Browsers do not send the file's mtime, so uploads only record name and size.
+		// A file organized earlier keeps the provenance recorded then.
+		provenance := database.Provenance{
+			OriginalFilename: filepath.Base(file.Filename),
+			SourceFolder:     "upload",
+			FileSize:         file.Size,
+		}
+		if err := database.RecordProvenance(db, name, provenance); err != nil {
+			return nil, "", err
+		}
+	}
+
+	width, height, err := images.GetImageDimensions(imagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("read image dimensions: %w", err)
+	}
+
+	if err := database.InsertImageWithTags(db, galleryDir, name, tags, tagMap, width, height, database.TagSourceUser, ""); err != nil {
+		return nil, "", err
+	}
+
+	img, err := database.GetImageByName(db, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if albumID != 0 {
+		_, err := db.Exec("INSERT OR IGNORE INTO album_images (album_id, image_id) VALUES (?, ?)", albumID, img.ID)
+		if err != nil {
+			return img, "created", fmt.Errorf("add to album: %w", err)
+		}
+	}
+
+	return img, "created", nil
+}
+
+// restoreUploaded takes the trashed image id out of the trash now that an upload put its file
+// back at imagePath
+func restoreUploaded(db *sql.DB, id int, imagePath string) (*database.ImageResult, string, error) {
+	trashed, err := database.GetTrashedImage(db, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if trashed == nil {
+		return nil, "", fmt.Errorf("image %d is no longer in the trash", id)
+	}
+	if err := ingest.RestoreFromGallery(db, *trashed, imagePath); err != nil {
+		return nil, "", err
+	}
+	img, err := database.GetImageByID(db, id)
+	return img, "restored", err
+}
+
+// saveUpload copies the upload into a uniquely named file in dir
+func saveUpload(file *multipart.FileHeader, dir, ext string) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("open upload: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(dir, "upload-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("create staging file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("save upload: %w", err)
+	}
+
+	return dst.Name(), nil
+}
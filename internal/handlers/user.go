@@ -4,10 +4,11 @@ import (
 	"database/sql"
 	"strconv"
 
+	"github.com/brayanMuniz/AGO/internal/tagindex"
 	"github.com/gin-gonic/gin"
 )
 
-func AddTagFavoriteHandler(db *sql.DB) gin.HandlerFunc {
+func AddTagFavoriteHandler(db *sql.DB, index *tagindex.Index) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tagIDStr := c.Param("id")
 		tagID, err := strconv.Atoi(tagIDStr)
@@ -23,11 +24,12 @@ func AddTagFavoriteHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		index.Invalidate()
 		c.JSON(200, gin.H{"message": "Added to favorites"})
 	}
 }
 
-func RemoveTagFavoriteHandler(db *sql.DB) gin.HandlerFunc {
+func RemoveTagFavoriteHandler(db *sql.DB, index *tagindex.Index) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tagIDStr := c.Param("id")
 		tagID, err := strconv.Atoi(tagIDStr)
@@ -43,6 +45,7 @@ func RemoveTagFavoriteHandler(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		index.Invalidate()
 		c.JSON(200, gin.H{"message": "Removed from favorites"})
 	}
 }
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"github.com/brayanMuniz/AGO/internal/watcher"
+	"github.com/gin-gonic/gin"
+)
+
+func GetWatcherStatusHandler(w *watcher.Watcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, w.Status())
+	}
+}
+
+func PauseWatcherHandler(w *watcher.Watcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w.Pause()
+		c.JSON(200, w.Status())
+	}
+}
+
+func ResumeWatcherHandler(w *watcher.Watcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w.Resume()
+		c.JSON(200, w.Status())
+	}
+}
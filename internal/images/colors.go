@@ -0,0 +1,291 @@
+package images
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// PaletteSize is the most dominant colors AnalyzeColors keeps per image
+const PaletteSize = 5
+
+// PaletteColor is a dominant color in CIELAB, the space where distances match how different colors look
+type PaletteColor struct {
+	Hex    string  `json:"hex"`
+	L      float64 `json:"l"`
+	A      float64 `json:"a"`
+	B      float64 `json:"b"`
+	Weight float64 `json:"weight"` // share of the image, the weights of a palette add up to 1
+}
+
+// ColorAnalysis is an image's palette, largest share first, and whether it is greyscale or a single hue
+type ColorAnalysis struct {
+	Palette    []PaletteColor
+	Monochrome bool
+}
+
+const (
+	// colorSampleSize is the longest side images are shrunk to before clustering
+	colorSampleSize = 64
+	kmeansRounds    = 10
+	// chromaThreshold is the chroma below which a pixel counts as grey
+	chromaThreshold = 12
+)
+
+// AnalyzeColors decodes the image at path and extracts its palette
+func AnalyzeColors(path string) (ColorAnalysis, error) {
+	img, _, err := DecodeImage(path)
+	if err != nil {
+		return ColorAnalysis{}, err
+	}
+	return AnalyzeImageColors(img), nil
+}
+
+type labPixel struct {
+	l, a, b    float64
+	r, g, bl   float64 // sRGB, averaged for the palette's hex values
+	assignment int
+}
+
+// AnalyzeImageColors clusters the pixels of img with k-means in CIELAB.
+// Clusters start from the most populated cells of a coarse Lab grid so results are repeatable.
+func AnalyzeImageColors(img image.Image) ColorAnalysis {
+	small := resize.Thumbnail(colorSampleSize, colorSampleSize, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var pixels []labPixel
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, alpha := small.At(x, y).RGBA()
+			if alpha < 0x8000 {
+				continue // mostly transparent, not part of the picture
+			}
+			// Undo alpha premultiplication before converting
+			rf, gf, bf := float64(r)/float64(alpha), float64(g)/float64(alpha), float64(b)/float64(alpha)
+			l, la, lb := rgbToLab(rf, gf, bf)
+			pixels = append(pixels, labPixel{l: l, a: la, b: lb, r: rf, g: gf, bl: bf})
+		}
+	}
+	if len(pixels) == 0 {
+		return ColorAnalysis{}
+	}
+
+	centers := seedCenters(pixels)
+	for round := 0; round < kmeansRounds; round++ {
+		changed := false
+		for i := range pixels {
+			if nearest := nearestCenter(pixels[i], centers); nearest != pixels[i].assignment {
+				pixels[i].assignment = nearest
+				changed = true
+			}
+		}
+		if round > 0 && !changed {
+			break
+		}
+		centers = updateCenters(pixels, len(centers))
+	}
+
+	return ColorAnalysis{
+		Palette:    buildPalette(pixels, len(centers)),
+		Monochrome: isMonochrome(pixels),
+	}
+}
+
+type center struct {
+	l, a, b float64
+}
+
+// seedCenters returns the means of the PaletteSize most populated cells of a coarse Lab grid
+func seedCenters(pixels []labPixel) []center {
+	type cell struct {
+		sum   center
+		count int
+		key   [3]int
+	}
+	cells := map[[3]int]*cell{}
+	for _, p := range pixels {
+		key := [3]int{int(math.Floor(p.l / 20)), int(math.Floor(p.a / 20)), int(math.Floor(p.b / 20))}
+		c := cells[key]
+		if c == nil {
+			c = &cell{key: key}
+			cells[key] = c
+		}
+		c.sum.l += p.l
+		c.sum.a += p.a
+		c.sum.b += p.b
+		c.count++
+	}
+
+	sorted := make([]*cell, 0, len(cells))
+	for _, c := range cells {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		// Break ties on the cell position so map order never changes the result
+		ki, kj := sorted[i].key, sorted[j].key
+		for d := range ki {
+			if ki[d] != kj[d] {
+				return ki[d] < kj[d]
+			}
+		}
+		return false
+	})
+
+	centers := make([]center, 0, PaletteSize)
+	for _, c := range sorted[:min(PaletteSize, len(sorted))] {
+		n := float64(c.count)
+		centers = append(centers, center{c.sum.l / n, c.sum.a / n, c.sum.b / n})
+	}
+	return centers
+}
+
+func nearestCenter(p labPixel, centers []center) int {
+	best, bestDist := 0, math.MaxFloat64
+	for i, c := range centers {
+		d := (p.l-c.l)*(p.l-c.l) + (p.a-c.a)*(p.a-c.a) + (p.b-c.b)*(p.b-c.b)
+		if d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// updateCenters moves every center to the mean of its pixels, empty clusters keep no center
+func updateCenters(pixels []labPixel, k int) []center {
+	sums := make([]center, k)
+	counts := make([]int, k)
+	for _, p := range pixels {
+		sums[p.assignment].l += p.l
+		sums[p.assignment].a += p.a
+		sums[p.assignment].b += p.b
+		counts[p.assignment]++
+	}
+
+	centers := make([]center, k)
+	for i := range sums {
+		if counts[i] == 0 {
+			// Unreachable center, nothing will be assigned to it again
+			centers[i] = center{math.Inf(1), math.Inf(1), math.Inf(1)}
+			continue
+		}
+		n := float64(counts[i])
+		centers[i] = center{sums[i].l / n, sums[i].a / n, sums[i].b / n}
+	}
+	return centers
+}
+
+func buildPalette(pixels []labPixel, k int) []PaletteColor {
+	type cluster struct {
+		l, a, b, r, g, bl float64
+		count             int
+	}
+	clusters := make([]cluster, k)
+	for _, p := range pixels {
+		c := &clusters[p.assignment]
+		c.l += p.l
+		c.a += p.a
+		c.b += p.b
+		c.r += p.r
+		c.g += p.g
+		c.bl += p.bl
+		c.count++
+	}
+
+	var palette []PaletteColor
+	for _, c := range clusters {
+		if c.count == 0 {
+			continue
+		}
+		n := float64(c.count)
+		palette = append(palette, PaletteColor{
+			Hex:    fmt.Sprintf("#%02x%02x%02x", to8bit(c.r/n), to8bit(c.g/n), to8bit(c.bl/n)),
+			L:      round2(c.l / n),
+			A:      round2(c.a / n),
+			B:      round2(c.b / n),
+			Weight: round2(n / float64(len(pixels))),
+		})
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Weight > palette[j].Weight })
+	return palette
+}
+
+// isMonochrome reports whether nearly every pixel is grey, or the colored ones all share one hue
+// as in sepia or single-tint illustrations
+func isMonochrome(pixels []labPixel) bool {
+	var chromatic int
+	var sumX, sumY, sumChroma float64
+	for _, p := range pixels {
+		chroma := math.Hypot(p.a, p.b)
+		if chroma < chromaThreshold {
+			continue
+		}
+		chromatic++
+		// Hue angles are averaged as unit vectors weighted by chroma
+		sumX += p.a
+		sumY += p.b
+		sumChroma += chroma
+	}
+
+	if float64(chromatic) < 0.05*float64(len(pixels)) {
+		return true
+	}
+	return math.Hypot(sumX, sumY)/sumChroma > 0.95
+}
+
+// rgbToLab converts sRGB components in [0, 1] to CIELAB under the D65 white point
+func rgbToLab(r, g, b float64) (float64, float64, float64) {
+	linear := func(c float64) float64 {
+		if c <= 0.04045 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	r, g, b = linear(r), linear(g), linear(b)
+
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// HexToLab parses a color such as "#1e90ff" or "1e90ff" into CIELAB
+func HexToLab(hex string) (l, a, b float64, err error) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("color must look like #rrggbb")
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("color must look like #rrggbb")
+	}
+
+	l, a, b = rgbToLab(float64(v>>16&0xff)/255, float64(v>>8&0xff)/255, float64(v&0xff)/255)
+	return l, a, b, nil
+}
+
+func to8bit(c float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, c)) * 255))
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
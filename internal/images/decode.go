@@ -0,0 +1,31 @@
+package images
+
+import (
+	"fmt"
+	"image"
+	"os"
+
+	// Register every format the gallery accepts with image.Decode and image.DecodeConfig
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// DecodeImage decodes the image at path and returns it with its format name.
+// Animated GIFs decode to their first frame.
+func DecodeImage(path string) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	img, format, err := image.Decode(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode image: %w", err)
+	}
+
+	return img, format, nil
+}
@@ -2,8 +2,6 @@ package images
 
 import (
 	"image"
-	_ "image/jpeg"
-	_ "image/png"
 	"os"
 )
 
@@ -2,7 +2,11 @@ package images
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -20,24 +24,236 @@ func LoadTagCategoryMapping(path string) (map[string]string, error) {
 	return mapping, nil
 }
 
+// LoadTagThresholds reads per-category minimum confidences, e.g. {"general": 0.35, "character": 0.7}.
+// A missing file means no thresholds.
+func LoadTagThresholds(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]float64{}, nil
+		}
+		return nil, err
+	}
+
+	var thresholds map[string]float64
+	if err := json.Unmarshal(data, &thresholds); err != nil {
+		return nil, err
+	}
+
+	return thresholds, nil
+}
+
+// ScoredTag is a tag read from a tagger output file
+type ScoredTag struct {
+	Name string
+	// Category reported by the tagger itself, empty when the file does not say
+	Category string
+	// Confidence between 0 and 1, nil when the file has no score for the tag
+	Confidence *float64
+}
+
 func LoadTagsFromFile(path string) ([]string, error) {
+	scored, err := LoadScoredTagsFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, len(scored))
+	for i, tag := range scored {
+		tags[i] = tag.Name
+	}
+	return tags, nil
+}
+
+// LoadScoredTagsFromFile reads a tagger output file.
+// .txt files hold comma separated tags, optionally scored as "tag:0.87" or "tag:87%".
+// .json files hold the tagger's JSON output, see parseTaggerJSON.
+func LoadScoredTagsFromFile(path string) ([]ScoredTag, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	content := strings.TrimSpace(string(data))
-	content = strings.TrimSuffix(content, "%")
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return parseTaggerJSON(data)
+	}
+
+	return parseTagText(string(data)), nil
+}
+
+// ParseScoredTags reads tagger output that may be either JSON or comma separated text
+func ParseScoredTags(data []byte) ([]ScoredTag, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		return parseTaggerJSON([]byte(trimmed))
+	}
+	return parseTagText(trimmed), nil
+}
+
+func parseTagText(content string) []ScoredTag {
+	content = strings.TrimSpace(content)
+	// Older tag files end with a stray "%"; keep it when it belongs to a "tag:87%" score
+	if lastTag := content[strings.LastIndex(content, ",")+1:]; !strings.Contains(lastTag, ":") {
+		content = strings.TrimSuffix(content, "%")
+	}
 	rawTags := strings.Split(content, ",")
 
 	// Trim spaces and filter out empty strings
-	var tags []string
+	var tags []ScoredTag
 	for _, tag := range rawTags {
 		trimmed := strings.TrimSpace(tag)
-		if trimmed != "" {
-			tags = append(tags, trimmed)
+		if trimmed == "" {
+			continue
+		}
+
+		scored := ScoredTag{Name: trimmed}
+
+		// Tag names can contain colons themselves (re:zero), so only split on
+		// the last one and only when what follows is a number
+		if i := strings.LastIndex(trimmed, ":"); i > 0 {
+			if confidence, ok := parseConfidence(trimmed[i+1:]); ok {
+				scored.Name = strings.TrimSpace(trimmed[:i])
+				scored.Confidence = &confidence
+			}
+		}
+
+		tags = append(tags, scored)
+	}
+
+	return tags
+}
+
+// parseConfidence accepts 0.87 or 87% and returns a value between 0 and 1.
+// Bare integers are rejected so names like "4:3" are not mistaken for scores.
+func parseConfidence(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	percent := strings.HasSuffix(s, "%")
+	s = strings.TrimSuffix(s, "%")
+
+	if !percent && !strings.Contains(s, ".") {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+
+	if percent {
+		value /= 100
+	}
+	if value > 1 {
+		return 0, false
+	}
+
+	return value, true
+}
+
+// parseTaggerJSON understands the shapes the tagger emits:
+//
+//	{"tag": 0.87, ...}
+//	{"general": [{"tag": "tag", "confidence": 0.87}, ...], "character": [...]}
+//	{"general": [["tag", 0.87], ...]}
+//	{"general": {"tag": 0.87}}
+//
+// optionally wrapped in a top-level "tags" or "predictions" object.
+func parseTaggerJSON(data []byte) ([]ScoredTag, error) {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parse tagger json: %w", err)
+	}
+
+	for _, key := range []string{"tags", "predictions"} {
+		if inner, ok := root[key]; ok {
+			var wrapped map[string]json.RawMessage
+			if err := json.Unmarshal(inner, &wrapped); err == nil {
+				root = wrapped
+			}
+			break
+		}
+	}
+
+	// Sort keys so repeated imports produce tags in the same order
+	keys := make([]string, 0, len(root))
+	for key := range root {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var tags []ScoredTag
+	for _, key := range keys {
+		raw := root[key]
+
+		var confidence float64
+		if err := json.Unmarshal(raw, &confidence); err == nil {
+			tags = appendScored(tags, key, "", confidence)
+			continue
+		}
+
+		var byName map[string]float64
+		if err := json.Unmarshal(raw, &byName); err == nil {
+			names := make([]string, 0, len(byName))
+			for name := range byName {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				tags = appendScored(tags, name, key, byName[name])
+			}
+			continue
+		}
+
+		var entries []json.RawMessage
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			continue // metadata such as the image path or model name
+		}
+		for _, entry := range entries {
+			name, confidence, ok := parseTaggerEntry(entry)
+			if ok {
+				tags = appendScored(tags, name, key, confidence)
+			}
 		}
 	}
 
 	return tags, nil
 }
+
+// parseTaggerEntry reads either {"tag": "x", "confidence": 0.8} or ["x", 0.8]
+func parseTaggerEntry(entry json.RawMessage) (string, float64, bool) {
+	var object map[string]any
+	if err := json.Unmarshal(entry, &object); err == nil {
+		var name string
+		for _, key := range []string{"tag", "name", "label"} {
+			if value, ok := object[key].(string); ok {
+				name = value
+				break
+			}
+		}
+		for _, key := range []string{"confidence", "probability", "prob", "score"} {
+			if value, ok := object[key].(float64); ok {
+				return name, value, name != ""
+			}
+		}
+		return "", 0, false
+	}
+
+	var pair []any
+	if err := json.Unmarshal(entry, &pair); err == nil && len(pair) == 2 {
+		name, nameOK := pair[0].(string)
+		confidence, confidenceOK := pair[1].(float64)
+		return name, confidence, nameOK && confidenceOK
+	}
+
+	return "", 0, false
+}
+
+func appendScored(tags []ScoredTag, name, category string, confidence float64) []ScoredTag {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return tags
+	}
+	if confidence > 1 {
+		confidence /= 100
+	}
+	return append(tags, ScoredTag{Name: name, Category: category, Confidence: &confidence})
+}
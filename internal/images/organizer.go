@@ -1,93 +1,245 @@
 package images
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
+	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/corona10/goimagehash"
 )
 
 var extensions = map[string]bool{
-	".jpg": true, ".jpeg": true, ".png": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".gif": true,
 }
 
-func OrganizeImages(rawDir, galleryDir string) error {
-	// Ensure gallery directory exists
-	if err := os.MkdirAll(galleryDir, os.ModePerm); err != nil {
-		log.Fatalf("Failed to create gallery dir: %v", err)
-		return err
-	}
+// Possible outcomes of organizing a single raw file
+const (
+	OrganizeMoved     = "moved"
+	OrganizeExists    = "exists"
+	OrganizeSkipped   = "skipped"
+	OrganizeFailed    = "failed"
+	OrganizeDuplicate = "duplicate" // left in place because it looks like an existing image
+)
+
+// OrganizeOptions changes how OrganizeFile treats new images
+type OrganizeOptions struct {
+	// FindDuplicate, when set, is asked about every new hash before the file is moved.
+	// Returning a non-empty match leaves the file where it is with status OrganizeDuplicate.
+	FindDuplicate func(hash uint64) (match string, distance int)
+}
+
+// OrganizeResult describes what happened to one file in the raw directory
+type OrganizeResult struct {
+	Source string
+	Dest   string
+	Phash  string
+	SHA256 string
+	Status string
+	Err    error
+	// Set when Status is OrganizeDuplicate
+	DuplicateOf string
+	Distance    int
+	// Size and ModTime of the source file, read before it is moved
+	Size    int64
+	ModTime time.Time
+}
 
+// IsImageFile reports whether name has an extension the organizer handles
+func IsImageFile(name string) bool {
+	return extensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// ListRawFiles returns every regular file below rawDir
+func ListRawFiles(rawDir string) ([]string, error) {
+	var paths []string
 	err := filepath.WalkDir(rawDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-
-		if d.IsDir() {
-			return nil
+		if !d.IsDir() {
+			paths = append(paths, path)
 		}
+		return nil
+	})
+	return paths, err
+}
+
+// HashImage decodes the image at path and returns its perceptual hash
+func HashImage(path string) (uint64, error) {
+	img, _, err := DecodeImage(path)
+	if err != nil {
+		return 0, err
+	}
 
-		ext := strings.ToLower(filepath.Ext(d.Name()))
-		if !extensions[ext] {
-			fmt.Printf("Skipping non-image file: %s\n", d.Name())
-			return nil
+	hash, err := goimagehash.PerceptionHash(img)
+	if err != nil {
+		return 0, fmt.Errorf("compute hash: %w", err)
+	}
+	return hash.GetHash(), nil
+}
+
+// FormatPhash returns the hash as used in gallery file names
+func FormatPhash(hash uint64) string {
+	return fmt.Sprintf("%x", hash)
+}
+
+// HashFile returns the hex encoded SHA-256 of the file's bytes
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CollisionName is the gallery name, without extension, of an image whose phash already
+// belongs to a different image: the phash followed by the start of its SHA-256
+func CollisionName(phash, sha string) string {
+	return phash + "-" + sha[:8]
+}
+
+// PhashFromName returns the phash part of a gallery name without extension
+func PhashFromName(name string) string {
+	phash, _, _ := strings.Cut(name, "-")
+	return phash
+}
+
+// GalleryName picks the name, without extension, that a file with phash and sha is stored under.
+// existing is the gallery file that already has the same content, empty when the file is new.
+func GalleryName(galleryDir, phash, sha string) (name, existing string, err error) {
+	for _, candidate := range []string{phash, CollisionName(phash, sha)} {
+		path, ok := findGalleryFile(galleryDir, candidate)
+		if !ok {
+			return candidate, "", nil
 		}
 
-		file, err := os.Open(path)
+		pathSHA, err := HashFile(path)
 		if err != nil {
-			fmt.Printf("Failed to open file: %s\n", d.Name())
-			return nil
+			return "", "", err
 		}
-		defer file.Close()
-
-		var img image.Image
-		switch ext {
-		case ".jpg", ".jpeg":
-			img, err = jpeg.Decode(file)
-		case ".png":
-			img, err = png.Decode(file)
+		if pathSHA == sha {
+			return candidate, path, nil
 		}
+	}
+	return "", "", fmt.Errorf("gallery already has different images named %s and %s", phash, CollisionName(phash, sha))
+}
 
-		if err != nil {
-			fmt.Printf("Failed to decode image: %s\n", d.Name())
-			return nil
+// findGalleryFile returns the file stored as name with any supported extension
+func findGalleryFile(galleryDir, name string) (string, bool) {
+	for ext := range extensions {
+		path := filepath.Join(galleryDir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
 		}
+	}
+	return "", false
+}
 
-		hash, err := goimagehash.PerceptionHash(img)
-		if err != nil {
-			fmt.Printf("Failed to compute hash: %s\n", d.Name())
-			return nil
-		}
+// OrganizeFile hashes a single raw image and moves it into galleryDir as <phash><ext>, or under
+// CollisionName when a different file already has that phash. Byte-identical files are left alone.
+func OrganizeFile(path, galleryDir string, opts OrganizeOptions) OrganizeResult {
+	result := OrganizeResult{Source: path}
 
-		hashStr := fmt.Sprintf("%x", hash.GetHash())
-		newName := fmt.Sprintf("%s%s", hashStr, ext)
-		newPath := filepath.Join(galleryDir, newName)
+	ext := strings.ToLower(filepath.Ext(path))
+	if !IsImageFile(path) {
+		result.Status = OrganizeSkipped
+		return result
+	}
 
-		if _, err := os.Stat(newPath); err == nil {
-			fmt.Printf("File already exists: %s, skipping.\n", newName)
-			return nil
-		}
+	hash, err := HashImage(path)
+	if err != nil {
+		result.Status = OrganizeFailed
+		result.Err = err
+		return result
+	}
 
-		err = os.Rename(path, newPath)
-		if err != nil {
-			fmt.Printf("Failed to move %s: %v\n", d.Name(), err)
-			return nil
+	result.Phash = FormatPhash(hash)
+	result.SHA256, err = HashFile(path)
+	if err != nil {
+		result.Status = OrganizeFailed
+		result.Err = err
+		return result
+	}
+
+	// A different image with the same phash is stored under a longer name instead of being dropped
+	name, existing, err := GalleryName(galleryDir, result.Phash, result.SHA256)
+	if err != nil {
+		result.Status = OrganizeFailed
+		result.Err = err
+		return result
+	}
+	if existing != "" {
+		result.Dest = existing
+		result.Status = OrganizeExists
+		return result
+	}
+	result.Dest = filepath.Join(galleryDir, name+ext)
+
+	if opts.FindDuplicate != nil {
+		if match, distance := opts.FindDuplicate(hash); match != "" {
+			result.Status = OrganizeDuplicate
+			result.DuplicateOf = match
+			result.Distance = distance
+			return result
 		}
+	}
 
-		fmt.Printf("Moved: %s → %s\n", d.Name(), newName)
-		return nil
-	})
+	info, err := os.Stat(path)
+	if err != nil {
+		result.Status = OrganizeFailed
+		result.Err = fmt.Errorf("stat file: %w", err)
+		return result
+	}
+	result.Size = info.Size()
+	result.ModTime = info.ModTime()
+
+	if err := os.Rename(path, result.Dest); err != nil {
+		result.Status = OrganizeFailed
+		result.Err = fmt.Errorf("move file: %w", err)
+		return result
+	}
+
+	result.Status = OrganizeMoved
+	return result
+}
 
+func OrganizeImages(rawDir, galleryDir string) error {
+	// Ensure gallery directory exists
+	if err := os.MkdirAll(galleryDir, os.ModePerm); err != nil {
+		return fmt.Errorf("create gallery dir: %w", err)
+	}
+
+	paths, err := ListRawFiles(rawDir)
 	if err != nil {
-		log.Fatalf("Error walking directory: %v", err)
-		return err
+		return fmt.Errorf("walk raw dir: %w", err)
+	}
+
+	for _, path := range paths {
+		result := OrganizeFile(path, galleryDir, OrganizeOptions{})
+		name := filepath.Base(path)
+
+		switch result.Status {
+		case OrganizeSkipped:
+			fmt.Printf("Skipping non-image file: %s\n", name)
+		case OrganizeExists:
+			fmt.Printf("File already exists: %s, skipping.\n", filepath.Base(result.Dest))
+		case OrganizeFailed:
+			fmt.Printf("Failed to organize %s: %v\n", name, result.Err)
+		case OrganizeMoved:
+			fmt.Printf("Moved: %s → %s\n", name, filepath.Base(result.Dest))
+		}
 	}
 
 	return nil
@@ -3,6 +3,7 @@ package images
 import (
 	"fmt"
 	"image"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"os"
@@ -53,17 +54,10 @@ func GetThumbnailSizes() map[string]ThumbnailSize {
 
 // GenerateThumbnail creates a thumbnail for the given image
 func GenerateThumbnail(originalPath, thumbnailPath string, size ThumbnailSize) error {
-	// Open original image
-	file, err := os.Open(originalPath)
+	// Decode original image (first frame for animated GIFs)
+	img, format, err := DecodeImage(originalPath)
 	if err != nil {
-		return fmt.Errorf("failed to open original image: %w", err)
-	}
-	defer file.Close()
-
-	// Decode image
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+		return fmt.Errorf("failed to read original image: %w", err)
 	}
 
 	// Calculate new dimensions while preserving aspect ratio
@@ -71,10 +65,15 @@ func GenerateThumbnail(originalPath, thumbnailPath string, size ThumbnailSize) e
 	originalWidth := uint(originalBounds.Dx())
 	originalHeight := uint(originalBounds.Dy())
 
-	// Skip if image is already smaller than thumbnail size
+	// Skip if image is already smaller than thumbnail size.
+	// GIFs are still re-encoded so the thumbnail is a single frame, and WebP has to become PNG.
 	if originalWidth <= size.MaxWidth && originalHeight <= size.MaxHeight {
-		// Just copy the original file
-		return copyImageFile(originalPath, thumbnailPath)
+		switch format {
+		case "jpeg", "png":
+			// Just copy the original file
+			return copyImageFile(originalPath, thumbnailPath)
+		}
+		return writeThumbnail(thumbnailPath, img, format, size)
 	}
 
 	// Calculate new dimensions
@@ -102,6 +101,11 @@ func GenerateThumbnail(originalPath, thumbnailPath string, size ThumbnailSize) e
 	// Resize image
 	resizedImg := resize.Resize(newWidth, newHeight, img, resize.Lanczos3)
 
+	return writeThumbnail(thumbnailPath, resizedImg, format, size)
+}
+
+// writeThumbnail encodes img to thumbnailPath in a format matching the original
+func writeThumbnail(thumbnailPath string, resizedImg image.Image, format string, size ThumbnailSize) error {
 	// Create thumbnail directory if it doesn't exist
 	thumbnailDir := filepath.Dir(thumbnailPath)
 	if err := os.MkdirAll(thumbnailDir, 0755); err != nil {
@@ -122,12 +126,15 @@ func GenerateThumbnail(originalPath, thumbnailPath string, size ThumbnailSize) e
 		err = jpeg.Encode(thumbnailFile, resizedImg, &jpeg.Options{
 			Quality: size.Quality,
 		})
-	case "png":
-		// Use PNG encoder for PNG images to preserve transparency
+	case "png", "webp":
+		// Use PNG encoder for PNG and WebP images to preserve transparency
 		encoder := png.Encoder{
 			CompressionLevel: png.BestCompression,
 		}
 		err = encoder.Encode(thumbnailFile, resizedImg)
+	case "gif":
+		// Static GIF of the first frame, served with the same extension as the original
+		err = gif.Encode(thumbnailFile, resizedImg, nil)
 	default:
 		// Default to high-quality JPEG for other formats
 		err = jpeg.Encode(thumbnailFile, resizedImg, &jpeg.Options{
@@ -143,10 +150,19 @@ func GenerateThumbnail(originalPath, thumbnailPath string, size ThumbnailSize) e
 }
 
 // GetThumbnailPath generates the path for a thumbnail
-func GetThumbnailPath(originalFilename, sizeName string) string {
+func GetThumbnailPath(thumbnailsDir, originalFilename, sizeName string) string {
 	ext := filepath.Ext(originalFilename)
 	nameWithoutExt := strings.TrimSuffix(originalFilename, ext)
-	return filepath.Join("gallery", "thumbnails", sizeName, nameWithoutExt+"_"+sizeName+ext)
+	return filepath.Join(thumbnailsDir, sizeName, nameWithoutExt+"_"+sizeName+thumbnailExt(ext))
+}
+
+// thumbnailExt returns the extension thumbnails are stored with.
+// There is no WebP encoder available, so WebP thumbnails are written as PNG.
+func thumbnailExt(originalExt string) string {
+	if strings.ToLower(originalExt) == ".webp" {
+		return ".png"
+	}
+	return originalExt
 }
 
 // ThumbnailExists checks if a thumbnail already exists
@@ -156,8 +172,8 @@ func ThumbnailExists(thumbnailPath string) bool {
 }
 
 // GenerateThumbnailIfNeeded creates a thumbnail only if it doesn't exist
-func GenerateThumbnailIfNeeded(originalPath, originalFilename, sizeName string) (string, error) {
-	thumbnailPath := GetThumbnailPath(originalFilename, sizeName)
+func GenerateThumbnailIfNeeded(thumbnailsDir, originalPath, originalFilename, sizeName string) (string, error) {
+	thumbnailPath := GetThumbnailPath(thumbnailsDir, originalFilename, sizeName)
 	
 	// Check if thumbnail already exists
 	if ThumbnailExists(thumbnailPath) {
@@ -0,0 +1,44 @@
+package images
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MoveToTrash moves a file into trashDir, adding a numeric suffix if the name is already taken.
+// It returns the new path of the file.
+func MoveToTrash(path, trashDir string) (string, error) {
+	if err := os.MkdirAll(trashDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("create trash dir: %w", err)
+	}
+
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	base := name[:len(name)-len(ext)]
+
+	dest := filepath.Join(trashDir, name)
+	for counter := 1; ; counter++ {
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			break
+		}
+		dest = filepath.Join(trashDir, fmt.Sprintf("%s-%d%s", base, counter, ext))
+	}
+
+	if err := os.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("move to trash: %w", err)
+	}
+
+	return dest, nil
+}
+
+// RemoveThumbnails deletes every generated thumbnail of a gallery file
+func RemoveThumbnails(thumbnailsDir, filename string) error {
+	for sizeName := range GetThumbnailSizes() {
+		err := os.Remove(GetThumbnailPath(thumbnailsDir, filename, sizeName))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/images"
+)
+
+// AnalyzeColors extracts the palette of gallery images that have none yet, or of every image with all
+func AnalyzeColors(ctx context.Context, db *sql.DB, galleryDir string, all bool, r *Report) error {
+	files, err := database.GetImageFilesWithoutColors(db, all)
+	if err != nil {
+		return err
+	}
+	r.SetTotal(len(files))
+
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path := filepath.Join(galleryDir, f.Filename)
+		r.Start(path)
+		outcome, err := analyzeColors(db, int64(f.ID), path)
+		r.Record(path, outcome, err)
+	}
+	return nil
+}
+
+// AnalyzeColorFiles is AnalyzeColors for an explicit list of gallery files
+func AnalyzeColorFiles(ctx context.Context, db *sql.DB, paths []string, r *Report) error {
+	r.SetTotal(len(paths))
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.Start(path)
+
+		img, err := database.GetImageByName(db, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		if err != nil {
+			return err
+		}
+		if img == nil {
+			r.Record(path, database.OutcomeSkippedOther, errors.New("no database row"))
+			continue
+		}
+
+		outcome, err := analyzeColors(db, int64(img.ID), path)
+		r.Record(path, outcome, err)
+	}
+	return nil
+}
+
+// analyzeNewColors fills in the colors of images about to be inserted, decoding them on every CPU.
+// An image that fails to decode is inserted without colors, the colors job retries it.
+func analyzeNewColors(galleryDir string, imgs []database.NewImage) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.NumCPU(), len(imgs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				path := filepath.Join(galleryDir, imgs[i].Filename)
+				colors, err := images.AnalyzeColors(path)
+				if err != nil {
+					fmt.Printf("Could not analyze colors of %s: %v\n", path, err)
+					continue
+				}
+				imgs[i].Colors = &colors
+			}
+		}()
+	}
+
+	for i := range imgs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+func analyzeColors(db *sql.DB, imageID int64, path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return database.OutcomeMissingImage, err
+	}
+
+	colors, err := images.AnalyzeColors(path)
+	if err != nil {
+		return database.OutcomeDecodeError, err
+	}
+
+	if err := database.SetImageColors(db, imageID, colors); err != nil {
+		return database.OutcomeInsertError, fmt.Errorf("store colors: %w", err)
+	}
+	return database.OutcomeAnalyzed, nil
+}
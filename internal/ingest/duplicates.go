@@ -0,0 +1,73 @@
+package ingest
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/duplicates"
+	"github.com/brayanMuniz/AGO/internal/images"
+)
+
+// duplicateGuard flags raw images that are perceptually close to something already in the gallery
+type duplicateGuard struct {
+	db       *sql.DB
+	distance int
+	tree     *duplicates.BKTree[string]
+}
+
+// newDuplicateGuard indexes the hashes of every file in galleryDir, including ones not imported yet
+func newDuplicateGuard(db *sql.DB, galleryDir string, distance int) (*duplicateGuard, error) {
+	files, err := os.ReadDir(galleryDir)
+	if err != nil {
+		return nil, fmt.Errorf("read gallery dir: %w", err)
+	}
+
+	guard := &duplicateGuard{db: db, distance: distance, tree: &duplicates.BKTree[string]{}}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		phash := images.PhashFromName(strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())))
+		if hash, err := duplicates.ParseHash(phash); err == nil {
+			guard.tree.Add(hash, phash)
+		}
+	}
+
+	return guard, nil
+}
+
+func (g *duplicateGuard) options() images.OrganizeOptions {
+	return images.OrganizeOptions{
+		FindDuplicate: func(hash uint64) (string, int) {
+			matches := g.tree.Search(hash, g.distance)
+			if len(matches) == 0 {
+				return "", 0
+			}
+			closest := matches[0]
+			for _, match := range matches[1:] {
+				if match.Distance < closest.Distance {
+					closest = match
+				}
+			}
+			return closest.Value, closest.Distance
+		},
+	}
+}
+
+// record keeps the index and the flag table in step with what happened to a file
+func (g *duplicateGuard) record(result images.OrganizeResult) error {
+	switch result.Status {
+	case images.OrganizeMoved:
+		if hash, err := duplicates.ParseHash(result.Phash); err == nil {
+			g.tree.Add(hash, result.Phash)
+		}
+		return database.ClearDuplicateFlag(g.db, result.Source)
+	case images.OrganizeDuplicate:
+		return database.FlagDuplicate(g.db, result.Source, result.Phash, result.DuplicateOf, result.Distance)
+	}
+	return nil
+}
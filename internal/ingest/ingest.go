@@ -0,0 +1,377 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/images"
+)
+
+// Organize moves every image in rawDir into galleryDir under its phash, reporting each file to r.
+// Byte-identical copies of a gallery file are skipped, see images.OrganizeFile.
+// When duplicateDistance is above zero, images within that Hamming distance of one already in the
+// gallery are flagged and left in rawDir instead of being moved.
+func Organize(ctx context.Context, db *sql.DB, rawDir, galleryDir string, duplicateDistance int, r *Report) error {
+	paths, err := images.ListRawFiles(rawDir)
+	if err != nil {
+		return fmt.Errorf("walk raw dir: %w", err)
+	}
+	return OrganizeFiles(ctx, db, paths, galleryDir, duplicateDistance, r)
+}
+
+// OrganizeFiles is Organize for an explicit list of raw files
+func OrganizeFiles(ctx context.Context, db *sql.DB, paths []string, galleryDir string, duplicateDistance int, r *Report) error {
+	if err := os.MkdirAll(galleryDir, os.ModePerm); err != nil {
+		return fmt.Errorf("create gallery dir: %w", err)
+	}
+
+	var guard *duplicateGuard
+	opts := images.OrganizeOptions{}
+	if duplicateDistance > 0 {
+		var err error
+		guard, err = newDuplicateGuard(db, galleryDir, duplicateDistance)
+		if err != nil {
+			return err
+		}
+		opts = guard.options()
+	}
+
+	r.SetTotal(len(paths))
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r.Start(path)
+
+		result := images.OrganizeFile(path, galleryDir, opts)
+		if guard != nil {
+			if err := guard.record(result); err != nil {
+				fmt.Printf("Failed to record duplicate state for %s: %v\n", filepath.Base(path), err)
+			}
+		}
+
+		switch result.Status {
+		case images.OrganizeMoved:
+			if err := RecordProvenance(db, result); err != nil {
+				fmt.Printf("Failed to record provenance for %s: %v\n", filepath.Base(path), err)
+			}
+			r.Record(path, database.OutcomeOrganized, nil)
+		case images.OrganizeFailed:
+			// Without a phash the file never decoded, otherwise moving it failed
+			if result.Phash == "" {
+				r.Record(path, database.OutcomeDecodeError, result.Err)
+			} else {
+				r.Record(path, database.OutcomeMoveError, result.Err)
+			}
+		case images.OrganizeDuplicate:
+			r.Record(path, database.OutcomeDuplicate,
+				fmt.Errorf("likely duplicate of %s (distance %d)", result.DuplicateOf, result.Distance))
+		case images.OrganizeExists:
+			r.Record(path, database.OutcomeSkippedExisting, nil)
+		default:
+			r.Record(path, database.OutcomeSkippedOther, nil)
+		}
+	}
+
+	return nil
+}
+
+// RecordProvenance stores the original name, folder, size and mtime of a moved file so they
+// end up on the image row when it is imported
+func RecordProvenance(db *sql.DB, result images.OrganizeResult) error {
+	modTime := result.ModTime.UTC()
+	name := strings.TrimSuffix(filepath.Base(result.Dest), filepath.Ext(result.Dest))
+	return database.RecordProvenance(db, name, database.Provenance{
+		OriginalFilename: filepath.Base(result.Source),
+		SourceFolder:     filepath.Dir(result.Source),
+		FileSize:         result.Size,
+		FileModifiedAt:   &modTime,
+	})
+}
+
+// IsTagFile reports whether name looks like tagger output: <name>.txt or <name>.json,
+// named after the gallery file without its extension
+func IsTagFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".txt" || ext == ".json"
+}
+
+// ImportOptions controls how tag files are imported
+type ImportOptions struct {
+	// Model is recorded on the tag links, e.g. the name and version of the tagger
+	Model string
+	// Retag replaces the model tags of images that already have a row instead of skipping them
+	Retag bool
+}
+
+// ImportTagFiles inserts every image that has a tag file in txtDir and no row yet.
+// Tagger tags scored below the threshold for their category in thresholdsPath are dropped.
+func ImportTagFiles(ctx context.Context, db *sql.DB, txtDir, galleryDir, tagMapPath, thresholdsPath string, opts ImportOptions, r *Report) error {
+	files, err := os.ReadDir(txtDir)
+	if err != nil {
+		return fmt.Errorf("read tag files: %w", err)
+	}
+
+	var tagFiles []string
+	for _, file := range files {
+		if file.IsDir() || !IsTagFile(file.Name()) {
+			continue
+		}
+		tagFiles = append(tagFiles, filepath.Join(txtDir, file.Name()))
+	}
+
+	return ImportFiles(ctx, db, tagFiles, galleryDir, tagMapPath, thresholdsPath, opts, r)
+}
+
+// importBatchSize is how many images ImportFiles commits per transaction
+const importBatchSize = 100
+
+// ImportFiles is ImportTagFiles for an explicit list of tag files
+func ImportFiles(ctx context.Context, db *sql.DB, tagFiles []string, galleryDir, tagMapPath, thresholdsPath string, opts ImportOptions, r *Report) error {
+	tagMap, err := images.LoadTagCategoryMapping(tagMapPath)
+	if err != nil {
+		return fmt.Errorf("load tag metadata: %w", err)
+	}
+
+	thresholds, err := images.LoadTagThresholds(thresholdsPath)
+	if err != nil {
+		return fmt.Errorf("load tag thresholds: %w", err)
+	}
+
+	r.SetTotal(len(tagFiles))
+
+	cache := database.NewTagCache()
+	for start := 0; start < len(tagFiles); start += importBatchSize {
+		batch := tagFiles[start:min(start+importBatchSize, len(tagFiles))]
+		if err := importBatch(ctx, db, batch, galleryDir, tagMap, thresholds, opts, cache, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importBatch reads every tag file in paths, then inserts the images that are ready in one transaction.
+// Outcomes are only reported once the transaction has finished, nothing else writes while it is open.
+// Existing images are re-tagged afterwards, one transaction each.
+func importBatch(ctx context.Context, db *sql.DB, paths []string, galleryDir string, tagMap map[string]string, thresholds map[string]float64, opts ImportOptions, cache *database.TagCache, r *Report) error {
+	type retag struct {
+		path    string
+		imageID int64
+		tags    []database.ImportedTag
+	}
+
+	var ready []database.NewImage
+	var readyPaths []string
+	var retags []retag
+	seen := map[string]bool{}
+
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			break // insert what is already prepared, then stop
+		}
+
+		r.Start(path)
+
+		name := NameFromTagFile(path)
+		img, existingID, outcome, err := prepareTagFile(db, path, galleryDir, tagMap, thresholds, opts)
+		switch {
+		case outcome != "":
+			r.Record(path, outcome, err)
+		case seen[name]:
+			// Both a .txt and a .json for the same image
+			r.Record(path, database.OutcomeSkippedExisting, nil)
+		case existingID != 0:
+			seen[name] = true
+			retags = append(retags, retag{path: path, imageID: existingID, tags: img.Tags})
+		default:
+			seen[name] = true
+			ready = append(ready, img)
+			readyPaths = append(readyPaths, path)
+		}
+	}
+
+	if len(ready) > 0 {
+		analyzeNewColors(galleryDir, ready)
+		errs, err := database.InsertImagesWithTags(db, ready, tagMap, cache)
+		for i, path := range readyPaths {
+			switch {
+			case err != nil:
+				r.Record(path, database.OutcomeInsertError, err)
+			case errs[i] != nil:
+				r.Record(path, database.OutcomeInsertError, errs[i])
+			default:
+				r.Record(path, database.OutcomeImported, nil)
+			}
+		}
+	}
+
+	for _, item := range retags {
+		result, err := database.RetagImage(db, item.imageID, item.tags, tagMap, opts.Model, cache)
+		switch {
+		case err != nil:
+			r.Record(item.path, database.OutcomeInsertError, fmt.Errorf("re-tag image: %w", err))
+		case result.Changed():
+			r.Record(item.path, database.OutcomeRetagged, nil)
+		default:
+			r.Record(item.path, database.OutcomeSkippedExisting, nil)
+		}
+	}
+
+	return ctx.Err()
+}
+
+// ImportTagFile inserts the image named by a <name>.txt or <name>.json tag file and returns
+// one of the database.Outcome values, with an error explaining failed outcomes.
+func ImportTagFile(db *sql.DB, tagFilePath, galleryDir string, tagMap map[string]string, thresholds map[string]float64) (string, error) {
+	img, _, outcome, err := prepareTagFile(db, tagFilePath, galleryDir, tagMap, thresholds, ImportOptions{})
+	if outcome != "" {
+		return outcome, err
+	}
+
+	ready := []database.NewImage{img}
+	analyzeNewColors(galleryDir, ready)
+	errs, err := database.InsertImagesWithTags(db, ready, tagMap, nil)
+	if err == nil {
+		err = errs[0]
+	}
+	if err != nil {
+		return database.OutcomeInsertError, fmt.Errorf("insert image: %w", err)
+	}
+
+	return database.OutcomeImported, nil
+}
+
+// prepareTagFile reads a tag file and its image without writing anything, except that a trashed
+// image whose file is back in the gallery is restored with the tags it had.
+// It returns an empty outcome when the image is ready to insert, or, when re-tagging,
+// the ID of the existing image together with only the phash and tags filled in.
+func prepareTagFile(db *sql.DB, tagFilePath, galleryDir string, tagMap map[string]string, thresholds map[string]float64, opts ImportOptions) (database.NewImage, int64, string, error) {
+	name := NameFromTagFile(tagFilePath)
+
+	existingID, err := database.GetImageIDByName(db, name)
+	if err != nil {
+		return database.NewImage{}, 0, database.OutcomeInsertError, fmt.Errorf("check existing image: %w", err)
+	}
+	if existingID != 0 {
+		outcome, err := restoreIfTrashed(db, existingID, galleryDir, name)
+		if outcome != "" {
+			return database.NewImage{}, 0, outcome, err
+		}
+	}
+	if existingID != 0 && !opts.Retag {
+		return database.NewImage{}, 0, database.OutcomeSkippedExisting, nil
+	}
+
+	scoredTags, err := images.LoadScoredTagsFromFile(tagFilePath)
+	if err != nil {
+		return database.NewImage{}, 0, database.OutcomeDecodeError, fmt.Errorf("load tags: %w", err)
+	}
+
+	if existingID != 0 {
+		tags := applyThresholds(scoredTags, tagMap, thresholds)
+		return database.NewImage{Phash: images.PhashFromName(name), Tags: tags, Model: opts.Model}, existingID, "", nil
+	}
+
+	// Get image dimensions
+	imagePath, err := database.FindImageFile(galleryDir, name)
+	if err != nil {
+		return database.NewImage{}, 0, database.OutcomeMissingImage, fmt.Errorf("could not find image: %w", err)
+	}
+
+	width, height, err := images.GetImageDimensions(imagePath)
+	if err != nil {
+		return database.NewImage{}, 0, database.OutcomeDimensionError, fmt.Errorf("read image dimensions: %w", err)
+	}
+
+	img, err := database.PrepareNewImage(db, galleryDir, name, width, height)
+	if err != nil {
+		return database.NewImage{}, 0, database.OutcomeInsertError, err
+	}
+	img.Tags = applyThresholds(scoredTags, tagMap, thresholds)
+	img.Model = opts.Model
+
+	return img, 0, "", nil
+}
+
+// restoreIfTrashed restores the trashed image imageID when its file named name is back in the
+// gallery, and skips it while the file is still in the trash. It returns an empty outcome when
+// the image is not in the trash.
+func restoreIfTrashed(db *sql.DB, imageID int64, galleryDir, name string) (string, error) {
+	trashed, err := database.GetTrashedImage(db, int(imageID))
+	if err != nil {
+		return database.OutcomeInsertError, err
+	}
+	if trashed == nil {
+		return "", nil
+	}
+
+	imagePath, err := database.FindImageFile(galleryDir, name)
+	if err != nil {
+		return database.OutcomeSkippedExisting, nil
+	}
+	if err := RestoreFromGallery(db, *trashed, imagePath); err != nil {
+		return database.OutcomeInsertError, err
+	}
+	return database.OutcomeRestored, nil
+}
+
+// RestoreFromGallery takes a trashed image out of the trash because the same file is back in
+// the gallery at galleryPath, e.g. organized or uploaded again. The copy in the trash is deleted.
+// A different file under the trashed image's name is an error, the trashed image has to be purged first.
+func RestoreFromGallery(db *sql.DB, img database.TrashedImage, galleryPath string) error {
+	sha, err := images.HashFile(galleryPath)
+	if err != nil {
+		return err
+	}
+	if img.SHA256 != "" && img.SHA256 != sha {
+		return fmt.Errorf("%s is a different file than trashed image %d, purge it first", filepath.Base(galleryPath), img.ID)
+	}
+
+	if err := database.RestoreImage(db, img.ID); err != nil {
+		return err
+	}
+	if img.SHA256 == "" {
+		if err := database.SetImageSHA256(db, int64(img.ID), sha); err != nil {
+			return err
+		}
+	}
+	if img.TrashPath != "" {
+		if err := os.Remove(img.TrashPath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove trashed copy %s: %v\n", img.TrashPath, err)
+		}
+	}
+	return nil
+}
+
+// NameFromTagFile returns the gallery name, without extension, of the image a tag file belongs to
+func NameFromTagFile(tagFilePath string) string {
+	name := filepath.Base(tagFilePath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// applyThresholds drops tagger tags scored below the minimum for their category.
+// Tags without a score are always kept.
+func applyThresholds(scoredTags []images.ScoredTag, tagMap map[string]string, thresholds map[string]float64) []database.ImportedTag {
+	tags := make([]database.ImportedTag, 0, len(scoredTags))
+	for _, tag := range scoredTags {
+		category := tagMap[tag.Name]
+		if category == "" {
+			category = tag.Category
+		}
+		if tag.Confidence != nil {
+			if minimum, ok := thresholds[category]; ok && *tag.Confidence < minimum {
+				continue
+			}
+		}
+		tags = append(tags, database.ImportedTag{Name: tag.Name, Category: category, Confidence: tag.Confidence})
+	}
+	return tags
+}
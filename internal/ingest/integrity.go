@@ -0,0 +1,462 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+)
+
+// IntegrityOptions selects the slower checks of CheckIntegrity
+type IntegrityOptions struct {
+	// CheckHashes decodes and reads every image to compare its phash and SHA-256 with the stored ones
+	CheckHashes bool
+}
+
+// CheckIntegrity compares the images table with the gallery and thumbnail directories and records
+// every problem as one of database.IntegrityIssues. It only reads, see RepairIssues for fixes.
+func CheckIntegrity(ctx context.Context, db *sql.DB, galleryDir, thumbnailsDir string, opts IntegrityOptions, r *Report) error {
+	rows, err := database.GetImageFiles(db)
+	if err != nil {
+		return err
+	}
+
+	galleryFiles, err := os.ReadDir(galleryDir)
+	if err != nil {
+		return fmt.Errorf("read gallery dir: %w", err)
+	}
+
+	// Thumbnails are named after the gallery file without its extension.
+	// Trashed images keep theirs until they are purged.
+	known := map[string]bool{}
+	stems := map[string]bool{}
+	var live []database.ImageFile
+	for _, row := range rows {
+		if row.Deleted {
+			stems[strings.TrimSuffix(row.Filename, filepath.Ext(row.Filename))] = true
+			continue
+		}
+		known[row.Filename] = true
+		live = append(live, row)
+	}
+
+	var orphans []string
+	for _, file := range galleryFiles {
+		if file.IsDir() || !images.IsImageFile(file.Name()) {
+			continue
+		}
+		stems[strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))] = true
+		if !known[file.Name()] {
+			orphans = append(orphans, filepath.Join(galleryDir, file.Name()))
+		}
+	}
+
+	thumbnails, err := listThumbnails(thumbnailsDir)
+	if err != nil {
+		return err
+	}
+
+	r.SetTotal(len(live) + len(orphans) + len(thumbnails))
+
+	for _, row := range live {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		path := filepath.Join(galleryDir, row.Filename)
+		r.Start(path)
+		checkImageFile(row, path, opts, r)
+	}
+
+	for _, path := range orphans {
+		r.Start(path)
+		r.Record(path, database.IssueOrphanFile, fmt.Errorf("no image row for this file"))
+	}
+
+	for _, thumb := range thumbnails {
+		r.Start(thumb.path)
+		if stems[thumb.stem] {
+			r.Checked(thumb.path)
+		} else {
+			r.Record(thumb.path, database.IssueStaleThumbnail, fmt.Errorf("original %s is gone", thumb.stem))
+		}
+	}
+
+	return nil
+}
+
+// checkImageFile reports every issue found with the file of row as one outcome of path
+func checkImageFile(row database.ImageFile, path string, opts IntegrityOptions, r *Report) {
+	if issues := imageFileIssues(row, path, opts); len(issues) > 0 {
+		r.RecordIssues(path, issues)
+	} else {
+		r.Checked(path)
+	}
+}
+
+func imageFileIssues(row database.ImageFile, path string, opts IntegrityOptions) []Issue {
+	if _, err := os.Stat(path); err != nil {
+		return []Issue{{database.IssueMissingFile, fmt.Errorf("image %d has no file: %w", row.ID, err)}}
+	}
+
+	var issues []Issue
+	width, height, err := images.GetImageDimensions(path)
+	switch {
+	case err != nil:
+		return append(issues, Issue{database.OutcomeDecodeError, err})
+	case width != row.Width || height != row.Height:
+		issues = append(issues, Issue{database.IssueDimensionMismatch,
+			fmt.Errorf("stored %dx%d, file is %dx%d", row.Width, row.Height, width, height)})
+	}
+
+	if opts.CheckHashes {
+		hash, err := images.HashImage(path)
+		switch {
+		case err != nil:
+			return append(issues, Issue{database.OutcomeDecodeError, err})
+		case images.FormatPhash(hash) != row.Phash:
+			issues = append(issues, Issue{database.IssuePhashMismatch,
+				fmt.Errorf("stored %s, file hashes to %s", row.Phash, images.FormatPhash(hash))})
+		}
+
+		// Rows imported before content hashes were stored have none, repairing fills it in
+		sha, err := images.HashFile(path)
+		switch {
+		case err != nil:
+			return append(issues, Issue{database.OutcomeDecodeError, err})
+		case row.SHA256 == "":
+			issues = append(issues, Issue{database.IssueSHA256Mismatch, fmt.Errorf("no content hash stored")})
+		case sha != row.SHA256:
+			issues = append(issues, Issue{database.IssueSHA256Mismatch, fmt.Errorf("stored %s, file hashes to %s", row.SHA256, sha)})
+		}
+	}
+
+	return issues
+}
+
+type thumbnailFile struct {
+	path string
+	stem string // name of the original without extension
+}
+
+func listThumbnails(thumbnailsDir string) ([]thumbnailFile, error) {
+	var thumbnails []thumbnailFile
+	for sizeName := range images.GetThumbnailSizes() {
+		files, err := os.ReadDir(filepath.Join(thumbnailsDir, sizeName))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("read thumbnails: %w", err)
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+			thumbnails = append(thumbnails, thumbnailFile{
+				path: filepath.Join(thumbnailsDir, sizeName, file.Name()),
+				stem: strings.TrimSuffix(name, "_"+sizeName),
+			})
+		}
+	}
+	return thumbnails, nil
+}
+
+// RepairIssues fixes files recorded by CheckIntegrity as issue:
+// missing files are flagged on their row, orphans are imported without tags, stale thumbnails
+// are deleted, and dimensions, phashes or content hashes are read again from the file.
+func RepairIssues(ctx context.Context, db *sql.DB, issue string, paths []string, galleryDir, thumbnailsDir string, r *Report) error {
+	var repair func(path string) (string, error)
+	switch issue {
+	case database.IssueMissingFile:
+		repair = func(path string) (string, error) { return markMissing(db, path) }
+	case database.IssueOrphanFile:
+		repair = func(path string) (string, error) { return importOrphan(db, path, galleryDir, thumbnailsDir) }
+	case database.IssueStaleThumbnail:
+		repair = deleteThumbnail
+	case database.IssueDimensionMismatch:
+		repair = func(path string) (string, error) { return rereadDimensions(db, path) }
+	case database.IssuePhashMismatch:
+		repair = func(path string) (string, error) { return rehash(db, path, galleryDir, thumbnailsDir) }
+	case database.IssueSHA256Mismatch:
+		repair = func(path string) (string, error) { return storeSHA256(db, path) }
+	default:
+		return fmt.Errorf("unknown integrity issue %q", issue)
+	}
+
+	r.SetTotal(len(paths))
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r.Start(path)
+		outcome, err := repair(path)
+		r.Record(path, outcome, err)
+	}
+	return nil
+}
+
+// markMissing flags the row of a missing file, or clears the flag when the file is back
+func markMissing(db *sql.DB, path string) (string, error) {
+	img, err := database.GetImageFileByFilename(db, filepath.Base(path))
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if img == nil || img.Deleted {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	_, statErr := os.Stat(path)
+	if err := database.SetImageMissing(db, img.ID, statErr != nil); err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if statErr == nil {
+		return database.OutcomeSkippedOther, nil
+	}
+	return database.OutcomeRepaired, nil
+}
+
+// importOrphan inserts an untagged row for a gallery file, renaming it to its gallery name first if needed
+func importOrphan(db *sql.DB, path, galleryDir, thumbnailsDir string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	hash, err := images.HashImage(path)
+	if err != nil {
+		return database.OutcomeRepairError, fmt.Errorf("hash image: %w", err)
+	}
+	phash := images.FormatPhash(hash)
+
+	sha, err := images.HashFile(path)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+
+	existing, err := database.GetImageBySHA256(db, sha)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if existing != nil {
+		return database.OutcomeRepairError, fmt.Errorf("same file as image %d, delete one of them", existing.ID)
+	}
+
+	name, err := freeGalleryName(db, path, galleryDir, phash, sha, 0)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+
+	target := filepath.Join(galleryDir, name+strings.ToLower(filepath.Ext(path)))
+	if target != path {
+		if err := moveIfFree(path, target); err != nil {
+			return database.OutcomeRepairError, err
+		}
+		images.RemoveThumbnails(thumbnailsDir, filepath.Base(path))
+	}
+
+	width, height, err := images.GetImageDimensions(target)
+	if err != nil {
+		return database.OutcomeRepairError, fmt.Errorf("read image dimensions: %w", err)
+	}
+
+	if err := database.InsertImageWithTags(db, galleryDir, name, nil, nil, width, height, database.TagSourceModel, ""); err != nil {
+		return database.OutcomeRepairError, fmt.Errorf("insert image: %w", err)
+	}
+	return database.OutcomeRepaired, nil
+}
+
+func deleteThumbnail(path string) (string, error) {
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return database.OutcomeSkippedOther, nil
+		}
+		return database.OutcomeRepairError, err
+	}
+	return database.OutcomeRepaired, nil
+}
+
+func rereadDimensions(db *sql.DB, path string) (string, error) {
+	img, err := database.GetImageFileByFilename(db, filepath.Base(path))
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if img == nil {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	width, height, err := images.GetImageDimensions(path)
+	if err != nil {
+		return database.OutcomeRepairError, fmt.Errorf("read image dimensions: %w", err)
+	}
+	if width == img.Width && height == img.Height {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	if err := database.SetImageDimensions(db, img.ID, width, height); err != nil {
+		return database.OutcomeRepairError, err
+	}
+	return database.OutcomeRepaired, nil
+}
+
+// rehash stores the file's current phash on its row and renames the file to match
+func rehash(db *sql.DB, path, galleryDir, thumbnailsDir string) (string, error) {
+	img, err := database.GetImageFileByFilename(db, filepath.Base(path))
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if img == nil {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	hash, err := images.HashImage(path)
+	if err != nil {
+		return database.OutcomeRepairError, fmt.Errorf("hash image: %w", err)
+	}
+	phash := images.FormatPhash(hash)
+	if phash == img.Phash {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	sha, err := images.HashFile(path)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+
+	existing, err := database.GetImageBySHA256(db, sha)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if existing != nil && int64(existing.ID) != img.ID {
+		return database.OutcomeRepairError, fmt.Errorf("now the same file as image %d, delete one of them", existing.ID)
+	}
+
+	// The file may already carry the right name when only the row is wrong
+	name, err := freeGalleryName(db, path, galleryDir, phash, sha, img.ID)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	filename := name + filepath.Ext(img.Filename)
+	target := filepath.Join(galleryDir, filename)
+	if target != path {
+		if err := moveIfFree(path, target); err != nil {
+			return database.OutcomeRepairError, err
+		}
+	}
+
+	oldName := strings.TrimSuffix(img.Filename, filepath.Ext(img.Filename))
+	if err := database.RehashImage(db, img.ID, oldName, phash, sha, filename); err != nil {
+		os.Rename(target, path)
+		return database.OutcomeRepairError, err
+	}
+
+	if target != path {
+		images.RemoveThumbnails(thumbnailsDir, img.Filename)
+	}
+	return database.OutcomeRepaired, nil
+}
+
+// storeSHA256 stores the file's current content hash on its row
+func storeSHA256(db *sql.DB, path string) (string, error) {
+	img, err := database.GetImageFileByFilename(db, filepath.Base(path))
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if img == nil {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	sha, err := images.HashFile(path)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if sha == img.SHA256 {
+		return database.OutcomeSkippedOther, nil
+	}
+
+	existing, err := database.GetImageBySHA256(db, sha)
+	if err != nil {
+		return database.OutcomeRepairError, err
+	}
+	if existing != nil {
+		return database.OutcomeRepairError, fmt.Errorf("same file as image %d, delete one of them", existing.ID)
+	}
+
+	if err := database.SetImageSHA256(db, img.ID, sha); err != nil {
+		return database.OutcomeRepairError, err
+	}
+	return database.OutcomeRepaired, nil
+}
+
+// QueueHashBackfill queues a job that stores the content hash of every image without one,
+// so uploads and lookups by SHA-256 find the files of libraries from before hashes were kept.
+// Nothing is queued when every image has its hash.
+func QueueHashBackfill(db *sql.DB, jobManager *jobs.Manager, galleryDir string) error {
+	unhashed, err := database.GetUnhashedImageFiles(db)
+	if err != nil || len(unhashed) == 0 {
+		return err
+	}
+
+	_, err = jobManager.Enqueue("sha256", func(ctx context.Context, p *jobs.Progress) error {
+		p.SetTotal(len(unhashed))
+		for _, img := range unhashed {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			p.Start(img.Filename)
+			outcome, err := storeSHA256(db, filepath.Join(galleryDir, img.Filename))
+			switch {
+			case err != nil:
+				p.Failed(img.Filename, err)
+			case outcome == database.OutcomeRepaired:
+				p.Processed(img.Filename)
+			default:
+				p.Skipped(img.Filename)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// freeGalleryName returns the gallery name for the file at path that neither another file
+// nor the row of an image other than imageID is using
+func freeGalleryName(db *sql.DB, path, galleryDir, phash, sha string, imageID int64) (string, error) {
+	name, existing, err := images.GalleryName(galleryDir, phash, sha)
+	if err != nil {
+		return "", err
+	}
+	if existing != "" && existing != path {
+		return "", fmt.Errorf("same file as %s, delete one of them", filepath.Base(existing))
+	}
+
+	// A row whose file went missing still owns its name
+	ownerID, err := database.GetImageIDByName(db, name)
+	if err != nil {
+		return "", err
+	}
+	if ownerID != 0 && ownerID != imageID {
+		return "", fmt.Errorf("%s belongs to image %d", name, ownerID)
+	}
+	return name, nil
+}
+
+// moveIfFree renames src to dst unless dst already exists
+func moveIfFree(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", filepath.Base(dst))
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("move file: %w", err)
+	}
+	return nil
+}
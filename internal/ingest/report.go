@@ -0,0 +1,126 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+)
+
+// Report records the outcome of every file in an import run and forwards it to the job's progress.
+// A nil *Report ignores every update, like a nil *jobs.Progress.
+type Report struct {
+	db    *sql.DB
+	runID int64
+	p     *jobs.Progress
+}
+
+func NewReport(db *sql.DB, runID int64, p *jobs.Progress) *Report {
+	return &Report{db: db, runID: runID, p: p}
+}
+
+// RunReported runs fn as import run runID, marking the run running and then finished with fn's result
+func RunReported(ctx context.Context, db *sql.DB, runID int64, p *jobs.Progress, fn func(r *Report) error) error {
+	if err := database.StartImportRun(db, runID); err != nil {
+		return err
+	}
+
+	err := fn(NewReport(db, runID, p))
+
+	status, errMsg := database.JobCompleted, ""
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = database.JobCancelled
+	case err != nil:
+		status, errMsg = database.JobFailed, err.Error()
+	}
+	if finishErr := database.FinishImportRun(db, runID, status, errMsg); finishErr != nil && err == nil {
+		err = finishErr
+	}
+	return err
+}
+
+func (r *Report) SetTotal(total int) {
+	if r == nil {
+		return
+	}
+	r.p.SetTotal(total)
+}
+
+func (r *Report) Start(path string) {
+	if r == nil {
+		return
+	}
+	r.p.Start(filepath.Base(path))
+}
+
+// Record stores the outcome of path, err explains failed outcomes and integrity issues
+func (r *Report) Record(path, outcome string, err error) {
+	if r == nil {
+		return
+	}
+
+	name := filepath.Base(path)
+	if err == nil && (database.IsFailedOutcome(outcome) || database.IsIntegrityIssue(outcome)) {
+		err = errors.New(outcome)
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	switch {
+	case database.IsFailedOutcome(outcome) || database.IsIntegrityIssue(outcome):
+		r.p.Failed(name, err)
+	case outcome == database.OutcomeImported || outcome == database.OutcomeOrganized ||
+		outcome == database.OutcomeRetagged || outcome == database.OutcomeRepaired ||
+		outcome == database.OutcomeAnalyzed || outcome == database.OutcomeRestored:
+		r.p.Processed(name)
+	default:
+		r.p.Skipped(name)
+	}
+
+	r.store(path, outcome, errMsg)
+}
+
+// Issue is one of the problems a scan found with a file
+type Issue struct {
+	Outcome string
+	Err     error
+}
+
+// RecordIssues stores every issue found with path, counting the file once as failed.
+// Each issue keeps its own row so repairs can select the files by issue.
+func (r *Report) RecordIssues(path string, issues []Issue) {
+	if r == nil || len(issues) == 0 {
+		return
+	}
+
+	errs := make([]error, len(issues))
+	for i, issue := range issues {
+		if issue.Err == nil {
+			issue.Err = errors.New(issue.Outcome)
+		}
+		errs[i] = issue.Err
+		r.store(path, issue.Outcome, issue.Err.Error())
+	}
+	r.p.Failed(filepath.Base(path), errors.Join(errs...))
+}
+
+func (r *Report) store(path, outcome, errMsg string) {
+	if err := database.RecordImportRunFile(r.db, r.runID, path, outcome, errMsg); err != nil {
+		fmt.Printf("Failed to record outcome of %s: %v\n", filepath.Base(path), err)
+	}
+}
+
+// Checked counts path as processed without recording it, for scans that only record problems
+func (r *Report) Checked(path string) {
+	if r == nil {
+		return
+	}
+	r.p.Processed(filepath.Base(path))
+}
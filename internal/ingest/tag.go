@@ -0,0 +1,154 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+)
+
+// untaggedImage is a gallery file that needs tags, imageID is zero when it has no row yet
+type untaggedImage struct {
+	imageID int64
+	name    string // file name without extension
+	path    string
+}
+
+// TagUntagged runs t on every gallery image that has no database row or no tags,
+// inserting new images and linking tags to existing ones.
+func TagUntagged(ctx context.Context, db *sql.DB, galleryDir string, t tagger.Tagger, tagMapPath, thresholdsPath string, r *Report) error {
+	pending, err := findUntagged(db, galleryDir)
+	if err != nil {
+		return err
+	}
+	r.SetTotal(len(pending))
+	return tagImages(ctx, db, pending, t, tagMapPath, thresholdsPath, r)
+}
+
+// TagFiles is TagUntagged for an explicit list of gallery files, skipping any that gained tags since
+func TagFiles(ctx context.Context, db *sql.DB, paths []string, t tagger.Tagger, tagMapPath, thresholdsPath string, r *Report) error {
+	r.SetTotal(len(paths))
+
+	var pending []untaggedImage
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		img, err := database.GetImageByName(db, name)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case img == nil:
+			pending = append(pending, untaggedImage{name: name, path: path})
+		case len(img.Tags) == 0:
+			pending = append(pending, untaggedImage{imageID: int64(img.ID), name: name, path: path})
+		default:
+			r.Record(path, database.OutcomeSkippedExisting, nil)
+		}
+	}
+	return tagImages(ctx, db, pending, t, tagMapPath, thresholdsPath, r)
+}
+
+func tagImages(ctx context.Context, db *sql.DB, pending []untaggedImage, t tagger.Tagger, tagMapPath, thresholdsPath string, r *Report) error {
+	tagMap, err := images.LoadTagCategoryMapping(tagMapPath)
+	if err != nil {
+		return fmt.Errorf("load tag metadata: %w", err)
+	}
+
+	thresholds, err := images.LoadTagThresholds(thresholdsPath)
+	if err != nil {
+		return fmt.Errorf("load tag thresholds: %w", err)
+	}
+
+	for _, img := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r.Start(img.path)
+		outcome, err := tagImage(ctx, db, img, t, tagMap, thresholds)
+		if err != nil && ctx.Err() != nil {
+			// The tagger was killed by cancellation, the image itself is fine
+			return ctx.Err()
+		}
+		r.Record(img.path, outcome, err)
+	}
+
+	return nil
+}
+
+// findUntagged lists gallery files without a row followed by rows without tags
+func findUntagged(db *sql.DB, galleryDir string) ([]untaggedImage, error) {
+	files, err := os.ReadDir(galleryDir)
+	if err != nil {
+		return nil, fmt.Errorf("read gallery dir: %w", err)
+	}
+
+	var pending []untaggedImage
+	for _, file := range files {
+		if file.IsDir() || !images.IsImageFile(file.Name()) {
+			continue
+		}
+
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		id, err := database.GetImageIDByName(db, name)
+		if err != nil {
+			return nil, fmt.Errorf("check existing image: %w", err)
+		}
+		if id == 0 {
+			pending = append(pending, untaggedImage{name: name, path: filepath.Join(galleryDir, file.Name())})
+		}
+	}
+
+	untagged, err := database.GetUntaggedImages(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range untagged {
+		name := strings.TrimSuffix(img.Filename, filepath.Ext(img.Filename))
+		path, err := database.FindImageFile(galleryDir, name)
+		if err != nil {
+			continue // the file is gone, nothing to tag
+		}
+		pending = append(pending, untaggedImage{imageID: img.ID, name: name, path: path})
+	}
+
+	return pending, nil
+}
+
+// tagImage returns one of the database.Outcome values, with an error explaining failed outcomes.
+// An existing image that got no tags above the thresholds is skipped.
+func tagImage(ctx context.Context, db *sql.DB, img untaggedImage, t tagger.Tagger, tagMap map[string]string, thresholds map[string]float64) (string, error) {
+	scoredTags, err := t.Tag(ctx, img.path)
+	if err != nil {
+		return database.OutcomeTaggerError, err
+	}
+	tags := applyThresholds(scoredTags, tagMap, thresholds)
+
+	if img.imageID != 0 {
+		if len(tags) == 0 {
+			return database.OutcomeSkippedOther, nil
+		}
+		if err := database.LinkImportedTags(db, img.imageID, tags, tagMap, t.Model()); err != nil {
+			return database.OutcomeInsertError, fmt.Errorf("link tags: %w", err)
+		}
+		return database.OutcomeImported, nil
+	}
+
+	width, height, err := images.GetImageDimensions(img.path)
+	if err != nil {
+		return database.OutcomeDimensionError, fmt.Errorf("read image dimensions: %w", err)
+	}
+
+	// Only gallery files are tagged, so the file's directory is the gallery
+	if err := database.InsertImageWithTags(db, filepath.Dir(img.path), img.name, tags, tagMap, width, height, database.TagSourceModel, t.Model()); err != nil {
+		return database.OutcomeInsertError, fmt.Errorf("insert image: %w", err)
+	}
+	return database.OutcomeImported, nil
+}
@@ -0,0 +1,200 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// tagFixture is a gallery and database for the tag job, with the tag metadata it reads
+type tagFixture struct {
+	db             *sql.DB
+	galleryDir     string
+	tagMapPath     string
+	thresholdsPath string
+}
+
+func newTagFixture(t *testing.T) tagFixture {
+	t.Helper()
+	dir := t.TempDir()
+
+	db, err := database.InitDB(filepath.Join(dir, "gallery.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	f := tagFixture{
+		db:             db,
+		galleryDir:     filepath.Join(dir, "gallery"),
+		tagMapPath:     filepath.Join(dir, "tag_to_category.json"),
+		thresholdsPath: filepath.Join(dir, "thresholds.json"),
+	}
+	if err := os.Mkdir(f.galleryDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, f.tagMapPath, `{"cat": "general", "dog": "general", "saber": "character"}`)
+	writeFile(t, f.thresholdsPath, `{"general": 0.5}`)
+	return f
+}
+
+// addImage writes a small PNG named name into the gallery, shaded so every image differs
+func (f tagFixture) addImage(t *testing.T, name string, shade uint8) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{R: shade, G: uint8(x * 30), B: uint8(y * 60), A: 255})
+		}
+	}
+
+	out, err := os.Create(filepath.Join(f.galleryDir, name+".png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := png.Encode(out, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tagNames returns the sorted tags of the image named name and the model each came from,
+// or nil when it has no row
+func (f tagFixture) tagNames(t *testing.T, name string) (tags []string, models map[string]string) {
+	t.Helper()
+	img, err := database.GetImageByName(f.db, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img == nil {
+		return nil, nil
+	}
+
+	models = map[string]string{}
+	for _, infos := range img.Tags {
+		for _, info := range infos {
+			tags = append(tags, info.Name)
+			models[info.Name] = info.Model
+		}
+	}
+	slices.Sort(tags)
+	return tags, models
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func score(v float64) *float64 {
+	return &v
+}
+
+func TestTagUntagged(t *testing.T) {
+	f := newTagFixture(t)
+
+	// a00 has no row, b00 has a row without tags and c00 is tagged already
+	f.addImage(t, "a00", 10)
+	f.addImage(t, "b00", 20)
+	f.addImage(t, "c00", 30)
+	for name, tags := range map[string][]database.ImportedTag{
+		"b00": nil,
+		"c00": {{Name: "dog"}},
+	} {
+		if err := database.InsertImageWithTags(f.db, f.galleryDir, name, tags, nil, 8, 4, database.TagSourceUser, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stub := &tagger.StubTagger{
+		Name: "stub-v1",
+		Default: []images.ScoredTag{
+			{Name: "cat", Confidence: score(0.9)},
+			{Name: "dog", Confidence: score(0.2)}, // below the general threshold
+		},
+		ByFile: map[string][]images.ScoredTag{
+			"b00.png": {{Name: "saber", Confidence: score(0.3)}},
+		},
+	}
+	if err := TagUntagged(context.Background(), f.db, f.galleryDir, stub, f.tagMapPath, f.thresholdsPath, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		wantTags  []string
+		wantModel string
+	}{
+		{"a00", []string{"cat"}, "stub-v1"},
+		{"b00", []string{"saber"}, "stub-v1"},
+		{"c00", []string{"dog"}, ""},
+	}
+	for _, tt := range tests {
+		tags, models := f.tagNames(t, tt.name)
+		if !slices.Equal(tags, tt.wantTags) {
+			t.Errorf("%s has tags %v, want %v", tt.name, tags, tt.wantTags)
+		}
+		for tag, model := range models {
+			if model != tt.wantModel {
+				t.Errorf("%s tag %s has model %q, want %q", tt.name, tag, model, tt.wantModel)
+			}
+		}
+	}
+
+	// Every image has tags now, so a second run has nothing to do
+	stub.Default = []images.ScoredTag{{Name: "dog", Confidence: score(0.9)}}
+	if err := TagUntagged(context.Background(), f.db, f.galleryDir, stub, f.tagMapPath, f.thresholdsPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := f.tagNames(t, "a00"); !slices.Equal(tags, []string{"cat"}) {
+		t.Errorf("a second run changed a00 to %v", tags)
+	}
+}
+
+func TestTagUntaggedTaggerError(t *testing.T) {
+	f := newTagFixture(t)
+	f.addImage(t, "a00", 10)
+
+	stub := &tagger.StubTagger{Err: errors.New("model crashed")}
+	if err := TagUntagged(context.Background(), f.db, f.galleryDir, stub, f.tagMapPath, f.thresholdsPath, nil); err != nil {
+		t.Fatalf("a failing image failed the whole run: %v", err)
+	}
+
+	img, err := database.GetImageByName(f.db, "a00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img != nil {
+		t.Errorf("an image the tagger failed on was inserted: %+v", img)
+	}
+}
+
+func TestTagUntaggedCancelled(t *testing.T) {
+	f := newTagFixture(t)
+	f.addImage(t, "a00", 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stub := &tagger.StubTagger{Default: []images.ScoredTag{{Name: "cat"}}}
+	if err := TagUntagged(ctx, f.db, f.galleryDir, stub, f.tagMapPath, f.thresholdsPath, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("TagUntagged after cancellation = %v, want context.Canceled", err)
+	}
+	if tags, _ := f.tagNames(t, "a00"); tags != nil {
+		t.Errorf("a cancelled run tagged a00 with %v", tags)
+	}
+}
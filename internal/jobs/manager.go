@@ -0,0 +1,288 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/brayanMuniz/AGO/database"
+)
+
+// Func is the work done by a job. It should return soon after ctx is cancelled.
+type Func func(ctx context.Context, p *Progress) error
+
+var ErrJobNotFound = errors.New("job not found")
+var ErrJobFinished = errors.New("job already finished")
+
+type entry struct {
+	job    database.Job
+	run    Func
+	done   func(job database.Job)
+	ctx    context.Context
+	cancel context.CancelFunc
+	subs   map[chan database.Job]struct{}
+	// progress is persisted at most once per saveInterval while running
+	lastSave time.Time
+}
+
+// Manager runs jobs one at a time in the background and keeps their history in SQLite
+type Manager struct {
+	db     *sql.DB
+	mu     sync.Mutex
+	active map[int64]*entry // queued or running jobs
+	queue  chan *entry
+}
+
+const saveInterval = time.Second
+
+func NewManager(db *sql.DB) (*Manager, error) {
+	if err := database.MarkInterruptedJobs(db); err != nil {
+		return nil, fmt.Errorf("mark interrupted jobs: %w", err)
+	}
+	// Import runs only execute inside jobs, so they were interrupted too
+	if err := database.MarkInterruptedImportRuns(db); err != nil {
+		return nil, fmt.Errorf("mark interrupted import runs: %w", err)
+	}
+
+	m := &Manager{
+		db:     db,
+		active: make(map[int64]*entry),
+		queue:  make(chan *entry, 100),
+	}
+	go m.worker()
+
+	return m, nil
+}
+
+// Enqueue records a new job and schedules it to run after the jobs already queued
+func (m *Manager) Enqueue(jobType string, run Func) (database.Job, error) {
+	return m.EnqueueWithDone(jobType, run, nil)
+}
+
+// EnqueueWithDone is Enqueue with done called once the job has finished, however it ended,
+// including when it was cancelled or dropped before run was ever called
+func (m *Manager) EnqueueWithDone(jobType string, run Func, done func(job database.Job)) (database.Job, error) {
+	job, err := database.InsertJob(m.db, jobType)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &entry{
+		job:    *job,
+		run:    run,
+		done:   done,
+		ctx:    ctx,
+		cancel: cancel,
+		subs:   make(map[chan database.Job]struct{}),
+	}
+
+	m.mu.Lock()
+	m.active[job.ID] = e
+	m.mu.Unlock()
+
+	select {
+	case m.queue <- e:
+	default:
+		m.finish(e, database.JobFailed, "job queue is full")
+		return e.job, fmt.Errorf("job queue is full")
+	}
+
+	return *job, nil
+}
+
+// Get returns the live state of an active job, or the stored state of a finished one
+func (m *Manager) Get(id int64) (*database.Job, error) {
+	m.mu.Lock()
+	e, ok := m.active[id]
+	if ok {
+		job := e.job
+		m.mu.Unlock()
+		return &job, nil
+	}
+	m.mu.Unlock()
+
+	return database.GetJobByID(m.db, id)
+}
+
+// List returns recent jobs with live progress for the ones still active
+func (m *Manager) List(jobType string, limit int) ([]database.Job, error) {
+	jobs, err := database.GetJobs(m.db, jobType, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range jobs {
+		if e, ok := m.active[jobs[i].ID]; ok {
+			jobs[i] = e.job
+		}
+	}
+	return jobs, nil
+}
+
+// Cancel stops a running job, or drops a queued one before it starts
+func (m *Manager) Cancel(id int64) error {
+	m.mu.Lock()
+	e, ok := m.active[id]
+	m.mu.Unlock()
+
+	if !ok {
+		job, err := database.GetJobByID(m.db, id)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return ErrJobNotFound
+		}
+		return ErrJobFinished
+	}
+
+	e.cancel()
+	return nil
+}
+
+// Subscribe returns a channel that receives a snapshot after every progress update.
+// The channel is closed once the job finishes; call the returned func to stop listening early.
+func (m *Manager) Subscribe(id int64) (<-chan database.Job, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.active[id]
+	if !ok {
+		job, err := database.GetJobByID(m.db, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if job == nil {
+			return nil, nil, ErrJobNotFound
+		}
+		ch := make(chan database.Job, 1)
+		ch <- *job
+		close(ch)
+		return ch, func() {}, nil
+	}
+
+	ch := make(chan database.Job, 1)
+	ch <- e.job
+	e.subs[ch] = struct{}{}
+
+	unsubscribe := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := e.subs[ch]; ok {
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe, nil
+}
+
+func (m *Manager) worker() {
+	for e := range m.queue {
+		m.runJob(e)
+	}
+}
+
+func (m *Manager) runJob(e *entry) {
+	if e.ctx.Err() != nil {
+		m.finish(e, database.JobCancelled, "")
+		return
+	}
+
+	now := time.Now().UTC()
+	m.update(e, func(job *database.Job) {
+		job.Status = database.JobRunning
+		job.StartedAt = &now
+	})
+	m.save(e)
+
+	err := m.safeRun(e)
+
+	switch {
+	case e.ctx.Err() != nil:
+		m.finish(e, database.JobCancelled, "")
+	case err != nil:
+		m.finish(e, database.JobFailed, err.Error())
+	default:
+		m.finish(e, database.JobCompleted, "")
+	}
+}
+
+// safeRun keeps a panicking job from taking the whole server down
+func (m *Manager) safeRun(e *entry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return e.run(e.ctx, &Progress{manager: m, entry: e})
+}
+
+func (m *Manager) finish(e *entry, status, errMsg string) {
+	now := time.Now().UTC()
+	m.update(e, func(job *database.Job) {
+		job.Status = status
+		job.Error = errMsg
+		job.CurrentFile = ""
+		job.FinishedAt = &now
+	})
+	m.save(e)
+
+	m.mu.Lock()
+	job := e.job
+	delete(m.active, e.job.ID)
+	for ch := range e.subs {
+		close(ch)
+	}
+	e.subs = nil
+	m.mu.Unlock()
+
+	e.cancel()
+	if e.done != nil {
+		e.done(job)
+	}
+}
+
+// update applies a change to the job and pushes the new snapshot to subscribers
+func (m *Manager) update(e *entry, change func(job *database.Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	change(&e.job)
+	for ch := range e.subs {
+		// Subscribers only care about the latest state, so replace anything unread
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- e.job
+	}
+}
+
+func (m *Manager) save(e *entry) {
+	m.mu.Lock()
+	job := e.job
+	e.lastSave = time.Now()
+	m.mu.Unlock()
+
+	if err := database.SaveJob(m.db, job); err != nil {
+		log.Printf("Failed to save job %d: %v", job.ID, err)
+	}
+}
+
+func (m *Manager) saveIfDue(e *entry) {
+	m.mu.Lock()
+	due := time.Since(e.lastSave) >= saveInterval
+	m.mu.Unlock()
+
+	if due {
+		m.save(e)
+	}
+}
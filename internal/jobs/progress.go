@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"github.com/brayanMuniz/AGO/database"
+)
+
+// Progress lets a running job report per-file results.
+// A nil *Progress is valid and ignores every update, so the same code can run outside a job.
+type Progress struct {
+	manager *Manager
+	entry   *entry
+}
+
+// SetTotal records how many files the job expects to handle
+func (p *Progress) SetTotal(total int) {
+	p.report(func(job *database.Job) {
+		job.Total = total
+	})
+}
+
+// Start marks file as the one currently being worked on
+func (p *Progress) Start(file string) {
+	p.report(func(job *database.Job) {
+		job.CurrentFile = file
+	})
+}
+
+func (p *Progress) Processed(file string) {
+	p.report(func(job *database.Job) {
+		job.Processed++
+	})
+}
+
+func (p *Progress) Skipped(file string) {
+	p.report(func(job *database.Job) {
+		job.Skipped++
+	})
+}
+
+func (p *Progress) Failed(file string, err error) {
+	p.report(func(job *database.Job) {
+		job.Failed++
+	})
+}
+
+func (p *Progress) report(change func(job *database.Job)) {
+	if p == nil {
+		return
+	}
+	p.manager.update(p.entry, change)
+	p.manager.saveIfDue(p.entry)
+}
@@ -0,0 +1,33 @@
+package query
+
+import "github.com/brayanMuniz/AGO/utils"
+
+// Facets counts, for each of categories, the limit tags most images the filters keep carry,
+// whatever the page. Rows are category, name and count, most common first.
+// minConfidence leaves out tagger tags scored below it, as the tag filters do.
+func (q ImageQuery) Facets(categories []string, limit int, minConfidence float64) (string, []interface{}) {
+	idsSQL, idsArgs := q.IDs()
+
+	args := make([]interface{}, 0, len(categories)+len(idsArgs)+2)
+	for _, category := range categories {
+		args = append(args, category)
+	}
+	args = append(args, idsArgs...)
+	if minConfidence > 0 {
+		args = append(args, minConfidence)
+	}
+	args = append(args, limit)
+
+	return `
+		SELECT category, name, count FROM (
+			SELECT tags.category AS category, tags.name AS name, COUNT(*) AS count,
+			       ROW_NUMBER() OVER (PARTITION BY tags.category ORDER BY COUNT(*) DESC, tags.name) AS rank
+			FROM image_tags
+			JOIN tags ON tags.id = image_tags.tag_id
+			WHERE tags.category IN (` + placeholders(len(categories)) + `)
+			  AND image_tags.image_id IN (` + idsSQL + `)` + utils.ConfidenceClause("image_tags", minConfidence) + `
+			GROUP BY tags.id
+		)
+		WHERE rank <= ?
+		ORDER BY category, rank`, args
+}
@@ -0,0 +1,98 @@
+package query
+
+import (
+	"strings"
+
+	"github.com/brayanMuniz/AGO/utils"
+)
+
+// ImageQuery is a listing of images: which ones, in what order, which page and which columns.
+// Every endpoint that lists images compiles one, so filters, sorts and pages behave the same everywhere.
+type ImageQuery struct {
+	// Filters are conditions on the images table an image must all pass
+	Filters []utils.FilterCondition
+	Sort    utils.Sort
+	// Page to read, a zero Limit reads every image
+	Page utils.Page
+	// Columns is the select list, the sort key follows it as sort_key
+	Columns string
+}
+
+// Where returns the WHERE clause of the filters, empty when there are none.
+// Every filter is parenthesized, so one holding OR cannot leak into the others.
+func (q ImageQuery) Where() (string, []interface{}) {
+	return where(q.Filters)
+}
+
+func where(filters []utils.FilterCondition) (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	for _, f := range filters {
+		if strings.TrimSpace(f.SQL) == "" {
+			continue
+		}
+		parts = append(parts, "("+f.SQL+")")
+		args = append(args, f.Args...)
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(parts, " AND "), args
+}
+
+// Count counts every image the filters keep, whatever the page
+func (q ImageQuery) Count() (string, []interface{}) {
+	whereClause, args := q.Where()
+	return "SELECT COUNT(*) FROM images " + whereClause, args
+}
+
+// IDs selects the IDs of every image the filters keep, for queries over the whole listing
+func (q ImageQuery) IDs() (string, []interface{}) {
+	whereClause, args := q.Where()
+	return "SELECT images.id FROM images " + whereClause, args
+}
+
+// Select reads the page: Columns and the sort key of the images past the page's cursor, in sort order
+func (q ImageQuery) Select() (string, []interface{}) {
+	filters := append(append([]utils.FilterCondition{}, q.Filters...), q.Sort.Condition(q.Page))
+	whereClause, whereArgs := where(filters)
+	limit, limitArgs := q.Page.Clause()
+
+	args := append([]interface{}{}, q.Sort.Args...)
+	args = append(args, whereArgs...)
+	args = append(args, limitArgs...)
+	return "SELECT " + q.Columns + q.Sort.Column() + " FROM images " + whereClause + " " + q.Sort.OrderBy(q.Page) + " " + limit, args
+}
+
+// InAlbum keeps the images added to a manual album
+func InAlbum(albumID int) utils.FilterCondition {
+	return utils.FilterCondition{
+		SQL:  "images.id IN (SELECT image_id FROM album_images WHERE album_id = ?)",
+		Args: []interface{}{albumID},
+	}
+}
+
+// HasTags keeps images with as many of tags, of any category, as mode asks for
+func HasTags(tags []string, mode utils.MatchMode, minConfidence float64) utils.FilterCondition {
+	return utils.BuildTagMatchFilterCondition(tags, "", mode, minConfidence)
+}
+
+// WithIDs keeps the images with the given IDs
+func WithIDs(ids []int) utils.FilterCondition {
+	if len(ids) == 0 {
+		return utils.FilterCondition{SQL: "0"}
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return utils.FilterCondition{
+		SQL:  "images.id IN (" + placeholders(len(ids)) + ")",
+		Args: args,
+	}
+}
+
+// Deleted keeps the images in the trash, utils.BuildNotDeletedFilterCondition the others
+func Deleted() utils.FilterCondition {
+	return utils.FilterCondition{SQL: "images.deleted_at IS NOT NULL"}
+}
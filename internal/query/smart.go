@@ -0,0 +1,83 @@
+package query
+
+import (
+	"strings"
+
+	"github.com/brayanMuniz/AGO/utils"
+)
+
+// SmartAlbum holds the saved filters of a smart album, tags and albums by ID
+type SmartAlbum struct {
+	IncludeTagIDs   []string
+	IncludeTagMatch utils.MatchMode
+	ExcludeTagIDs   []string
+	MinRating       int
+	FavoriteOnly    bool
+	IncludeAlbumIDs []string
+	ExcludeAlbumIDs []string
+}
+
+// Filters keeps the images the album holds. minConfidence hides tagger tags scored below it
+// when applying the tag filters.
+func (a SmartAlbum) Filters(minConfidence float64) []utils.FilterCondition {
+	var filters []utils.FilterCondition
+
+	if len(a.IncludeTagIDs) > 0 {
+		filters = append(filters, utils.BuildTagIDMatchFilterCondition(a.IncludeTagIDs, a.IncludeTagMatch, minConfidence))
+	}
+
+	if len(a.ExcludeTagIDs) > 0 {
+		args := stringArgs(a.ExcludeTagIDs)
+		if minConfidence > 0 {
+			args = append(args, minConfidence)
+		}
+		filters = append(filters, utils.FilterCondition{
+			SQL: `images.id NOT IN (
+				SELECT image_id FROM image_tags WHERE tag_id IN (` + placeholders(len(a.ExcludeTagIDs)) + `)` +
+				utils.ConfidenceClause("image_tags", minConfidence) + `
+			)`,
+			Args: args,
+		})
+	}
+
+	if a.MinRating > 0 {
+		filters = append(filters, utils.FilterCondition{SQL: "images.rating >= ?", Args: []interface{}{a.MinRating}})
+	}
+
+	if a.FavoriteOnly {
+		filters = append(filters, utils.FilterCondition{SQL: "images.favorite = 1"})
+	}
+
+	// Images must be in at least one of the included albums
+	inIncluded := `images.id IN (SELECT image_id FROM album_images WHERE album_id IN (` + placeholders(len(a.IncludeAlbumIDs)) + `))`
+	if len(a.IncludeAlbumIDs) > 0 {
+		filters = append(filters, utils.FilterCondition{SQL: inIncluded, Args: stringArgs(a.IncludeAlbumIDs)})
+	}
+
+	// and in none of the excluded ones, unless an included album has them too
+	if len(a.ExcludeAlbumIDs) > 0 {
+		exclude := utils.FilterCondition{
+			SQL:  `images.id NOT IN (SELECT image_id FROM album_images WHERE album_id IN (` + placeholders(len(a.ExcludeAlbumIDs)) + `))`,
+			Args: stringArgs(a.ExcludeAlbumIDs),
+		}
+		if len(a.IncludeAlbumIDs) > 0 {
+			exclude.SQL += " OR " + inIncluded
+			exclude.Args = append(exclude.Args, stringArgs(a.IncludeAlbumIDs)...)
+		}
+		filters = append(filters, exclude)
+	}
+
+	return filters
+}
+
+func placeholders(n int) string {
+	return strings.TrimRight(strings.Repeat("?,", n), ",")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
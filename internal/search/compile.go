@@ -0,0 +1,243 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/utils"
+)
+
+type fieldKind int
+
+const (
+	tagField fieldKind = iota
+	numberField
+	boolField
+	otherField
+)
+
+type field struct {
+	kind fieldKind
+	// category of tag fields, column or expression of number and bool fields
+	target string
+	// scale multiplies number values, 1 when zero
+	scale float64
+}
+
+// fields are the names usable before a colon in a term
+var fields = map[string]field{
+	"character":    {kind: tagField, target: "character"},
+	"tag":          {kind: tagField, target: "general"},
+	"general":      {kind: tagField, target: "general"},
+	"artist":       {kind: tagField, target: "artist"},
+	"series":       {kind: tagField, target: "copyright"},
+	"copyright":    {kind: tagField, target: "copyright"},
+	"explicitness": {kind: otherField},
+	"width":        {kind: numberField, target: "images.width"},
+	"height":       {kind: numberField, target: "images.height"},
+	"rating":       {kind: numberField, target: "images.rating"},
+	"likes":        {kind: numberField, target: "images.like_count"},
+	"size":         {kind: numberField, target: "images.file_size"},
+	"mp":           {kind: numberField, target: "images.width * images.height", scale: 1e6},
+	"aspect":       {kind: numberField, target: utils.AspectSQL},
+	"fav":          {kind: boolField, target: "images.favorite"},
+	"favorite":     {kind: boolField, target: "images.favorite"},
+	"monochrome":   {kind: boolField, target: "images.monochrome"},
+	"orientation":  {kind: otherField},
+	"color":        {kind: otherField},
+}
+
+// aspectTolerance is how far aspect:16:9 may be from the ratio, as a share of it
+const aspectTolerance = 0.02
+
+// CompileQuery parses q and compiles it, a blank q gives an empty condition
+func CompileQuery(q string, minConfidence float64) (utils.FilterCondition, error) {
+	n, err := Parse(q)
+	if err != nil || n == nil {
+		return utils.FilterCondition{}, err
+	}
+	return Compile(n, minConfidence)
+}
+
+// Compile turns a parsed query into a condition on the images table.
+// minConfidence hides tagger tags scored below it, as in the other tag filters.
+func Compile(n Node, minConfidence float64) (utils.FilterCondition, error) {
+	switch n := n.(type) {
+	case And:
+		return compileList(n.Nodes, " AND ", minConfidence)
+	case Or:
+		return compileList(n.Nodes, " OR ", minConfidence)
+	case Not:
+		inner, err := Compile(n.Node, minConfidence)
+		if err != nil {
+			return utils.FilterCondition{}, err
+		}
+		// A NULL column, such as the size of an image imported before sizes were stored,
+		// makes inner NULL. Such images do not match inner, so they match its negation.
+		return utils.FilterCondition{SQL: "NOT COALESCE((" + inner.SQL + "), 0)", Args: inner.Args}, nil
+	case Term:
+		return compileTerm(n, minConfidence)
+	}
+	return utils.FilterCondition{}, fmt.Errorf("unknown query node %T", n)
+}
+
+func compileList(nodes []Node, operator string, minConfidence float64) (utils.FilterCondition, error) {
+	var parts []string
+	var args []interface{}
+	for _, n := range nodes {
+		c, err := Compile(n, minConfidence)
+		if err != nil {
+			return utils.FilterCondition{}, err
+		}
+		parts = append(parts, "("+c.SQL+")")
+		args = append(args, c.Args...)
+	}
+	return utils.FilterCondition{SQL: strings.Join(parts, operator), Args: args}, nil
+}
+
+func compileTerm(t Term, minConfidence float64) (utils.FilterCondition, error) {
+	if t.Field == "" {
+		if t.Value == "" {
+			return utils.FilterCondition{}, &ParseError{Pos: t.Pos, Msg: "empty tag"}
+		}
+		return utils.BuildTagFilterCondition([]string{t.Value}, "", true, minConfidence), nil
+	}
+
+	if t.Value == "" {
+		return utils.FilterCondition{}, &ParseError{Pos: t.ValuePos, Msg: fmt.Sprintf("%s: needs a value", t.Field)}
+	}
+
+	f := fields[t.Field]
+	switch f.kind {
+	case tagField:
+		return utils.BuildTagFilterCondition([]string{t.Value}, f.target, true, minConfidence), nil
+	case numberField:
+		return compileNumber(t, f)
+	case boolField:
+		b, err := strconv.ParseBool(t.Value)
+		if err != nil {
+			return utils.FilterCondition{}, &ParseError{Pos: t.ValuePos, Msg: fmt.Sprintf("%s: expected true or false, found %q", t.Field, t.Value)}
+		}
+		return utils.FilterCondition{SQL: f.target + " = ?", Args: []interface{}{b}}, nil
+	}
+
+	switch t.Field {
+	case "explicitness":
+		if !utils.IsValidExplicitnessLevel(t.Value) {
+			levels := utils.GetAllExplicitnessLevels()
+			sort.Strings(levels)
+			return utils.FilterCondition{}, &ParseError{Pos: t.ValuePos,
+				Msg: fmt.Sprintf("explicitness: expected one of %s, found %q", strings.Join(levels, ", "), t.Value)}
+		}
+		return utils.BuildExplicitnessFilterCondition([]string{t.Value}, true, minConfidence), nil
+	case "orientation":
+		c := utils.BuildOrientationFilterCondition(t.Value)
+		if c.SQL == "" {
+			return c, &ParseError{Pos: t.ValuePos, Msg: fmt.Sprintf("orientation: expected portrait, landscape or square, found %q", t.Value)}
+		}
+		return c, nil
+	case "color":
+		l, a, b, err := images.HexToLab(t.Value)
+		if err != nil {
+			return utils.FilterCondition{}, &ParseError{Pos: t.ValuePos, Msg: fmt.Sprintf("color: %v", err)}
+		}
+		return utils.BuildColorFilterCondition(utils.LabColor{L: l, A: a, B: b}, utils.DefaultColorTolerance), nil
+	}
+	return utils.FilterCondition{}, &ParseError{Pos: t.Pos, Msg: fmt.Sprintf("unknown field %q", t.Field)}
+}
+
+// compileNumber reads comparisons such as width:>1920, width:<=800, width:1280..1920 and width:1920
+func compileNumber(t Term, f field) (utils.FilterCondition, error) {
+	if low, high, ok := strings.Cut(t.Value, ".."); ok {
+		var parts []string
+		var args []interface{}
+		if low != "" {
+			v, err := parseNumber(t, f, low, t.ValuePos)
+			if err != nil {
+				return utils.FilterCondition{}, err
+			}
+			parts = append(parts, f.target+" >= ?")
+			args = append(args, v)
+		}
+		if high != "" {
+			v, err := parseNumber(t, f, high, t.ValuePos+len(low)+2)
+			if err != nil {
+				return utils.FilterCondition{}, err
+			}
+			parts = append(parts, f.target+" <= ?")
+			args = append(args, v)
+		}
+		if len(parts) == 0 {
+			return utils.FilterCondition{}, &ParseError{Pos: t.ValuePos, Msg: fmt.Sprintf("%s: range needs at least one bound", t.Field)}
+		}
+		return utils.FilterCondition{SQL: strings.Join(parts, " AND "), Args: args}, nil
+	}
+
+	operator := "="
+	value := t.Value
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			operator, value = op, value[len(op):]
+			break
+		}
+	}
+
+	v, err := parseNumber(t, f, value, t.ValuePos+len(t.Value)-len(value))
+	if err != nil {
+		return utils.FilterCondition{}, err
+	}
+
+	// Aspect ratios are never exact, 16:9 also matches 1920x1088
+	if t.Field == "aspect" && operator == "=" {
+		return utils.BuildAspectFilterCondition(v*(1-aspectTolerance), v*(1+aspectTolerance)), nil
+	}
+	return utils.FilterCondition{SQL: f.target + " " + operator + " ?", Args: []interface{}{v}}, nil
+}
+
+// sizeUnits are the suffixes size: accepts, checked longest first
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"b", 1},
+}
+
+// parseNumber reads one number of t at offset pos. Aspects may be written as 16:9 and sizes with a unit.
+func parseNumber(t Term, f field, value string, pos int) (float64, error) {
+	multiplier := 1.0
+	if f.scale != 0 {
+		multiplier = f.scale
+	}
+
+	text := value
+	switch t.Field {
+	case "aspect":
+		if w, h, ok := strings.Cut(value, ":"); ok {
+			width, errW := strconv.ParseFloat(w, 64)
+			height, errH := strconv.ParseFloat(h, 64)
+			if errW == nil && errH == nil && width > 0 && height > 0 {
+				return width / height, nil
+			}
+			return 0, &ParseError{Pos: pos, Msg: fmt.Sprintf("aspect: expected a ratio such as 16:9, found %q", value)}
+		}
+	case "size":
+		lower := strings.ToLower(value)
+		for _, unit := range sizeUnits {
+			if strings.HasSuffix(lower, unit.suffix) {
+				text = value[:len(value)-len(unit.suffix)]
+				multiplier = unit.multiplier
+				break
+			}
+		}
+	}
+
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, &ParseError{Pos: pos, Msg: fmt.Sprintf("%s: expected a number, found %q", t.Field, value)}
+	}
+	return v * multiplier, nil
+}
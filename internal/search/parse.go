@@ -0,0 +1,321 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError is a query that does not parse, Pos is the byte offset in the query where it went wrong
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Node is a parsed query: And, Or, Not or Term
+type Node interface {
+	node()
+}
+
+// And matches images every one of its nodes matches, terms next to each other are joined by And
+type And struct {
+	Nodes []Node
+}
+
+// Or matches images any of its nodes matches
+type Or struct {
+	Nodes []Node
+}
+
+// Not matches images its node does not match, written -term or -(...)
+type Not struct {
+	Node Node
+}
+
+// Term is a single word such as hatsune_miku, artist:foo or width:>1920.
+// Field is empty for bare tags, Value is everything after the colon.
+type Term struct {
+	Field    string
+	Value    string
+	Pos      int // offset of the term
+	ValuePos int // offset of the value
+}
+
+func (And) node()  {}
+func (Or) node()   {}
+func (Not) node()  {}
+func (Term) node() {}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenOpen
+	tokenClose
+	tokenOr
+	tokenAnd
+	tokenNot
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	pos    int
+	quoted bool // the word started with a quote, so it is never split on a colon
+}
+
+// lex splits a query into tokens. Parentheses inside a word belong to it while they balance,
+// so saber_(fate) is one word but the ) of (cat_ears OR fox_ears) closes the group.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for {
+		for i < len(input) && isSpace(input[i]) {
+			i++
+		}
+		if i == len(input) {
+			return append(tokens, token{kind: tokenEOF, pos: i}), nil
+		}
+
+		switch c := input[i]; {
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "(", pos: i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")", pos: i})
+			i++
+			continue
+		case c == '-' && i+1 < len(input) && !isSpace(input[i+1]):
+			tokens = append(tokens, token{kind: tokenNot, text: "-", pos: i})
+			i++
+			continue
+		case c == '-':
+			return nil, &ParseError{Pos: i, Msg: "expected a term after -"}
+		}
+
+		start := i
+		var word strings.Builder
+		quoted := input[i] == '"'
+		depth := 0
+	scan:
+		for i < len(input) {
+			switch c := input[i]; {
+			case isSpace(c):
+				break scan
+			case c == '"':
+				end, text, err := lexQuoted(input, i)
+				if err != nil {
+					return nil, err
+				}
+				word.WriteString(text)
+				i = end
+			case c == '(':
+				depth++
+				word.WriteByte(c)
+				i++
+			case c == ')':
+				if depth == 0 {
+					break scan
+				}
+				depth--
+				word.WriteByte(c)
+				i++
+			default:
+				word.WriteByte(c)
+				i++
+			}
+		}
+
+		t := token{kind: tokenWord, text: word.String(), pos: start, quoted: quoted}
+		if !quoted {
+			switch t.text {
+			case "OR":
+				t.kind = tokenOr
+			case "AND":
+				t.kind = tokenAnd
+			}
+		}
+		tokens = append(tokens, t)
+	}
+}
+
+// lexQuoted reads the quoted string starting at input[start], \" and \\ are escapes.
+// It returns the offset just past the closing quote.
+func lexQuoted(input string, start int) (int, string, error) {
+	var text strings.Builder
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if i+1 < len(input) && (input[i+1] == '"' || input[i+1] == '\\') {
+				i++
+			}
+			text.WriteByte(input[i])
+		case '"':
+			return i + 1, text.String(), nil
+		default:
+			text.WriteByte(input[i])
+		}
+	}
+	return 0, "", &ParseError{Pos: start, Msg: "unterminated quote"}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// Parse reads a booru-style query. Terms are joined by AND unless OR sits between them,
+// AND binds tighter than OR, parentheses group and - excludes. A blank query returns nil.
+func Parse(input string) (Node, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, nil
+	}
+
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &ParseError{Pos: t.pos, Msg: "unexpected )"}
+	}
+	return n, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (Node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{first}
+	for p.peek().kind == tokenOr {
+		p.next()
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return Or{Nodes: nodes}, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	var nodes []Node
+	for {
+		t := p.peek()
+		switch t.kind {
+		case tokenEOF, tokenClose, tokenOr:
+			if len(nodes) == 0 {
+				return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("expected a term, found %s", describe(t))}
+			}
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			return And{Nodes: nodes}, nil
+		case tokenAnd:
+			if len(nodes) == 0 {
+				return nil, &ParseError{Pos: t.pos, Msg: "expected a term before AND"}
+			}
+			p.next()
+			if after := p.peek(); after.kind != tokenWord && after.kind != tokenOpen && after.kind != tokenNot {
+				return nil, &ParseError{Pos: after.pos, Msg: fmt.Sprintf("expected a term after AND, found %s", describe(after))}
+			}
+		}
+
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if p.peek().kind != tokenNot {
+		return p.parsePrimary()
+	}
+
+	not := p.next()
+	if t := p.peek(); t.kind != tokenWord && t.kind != tokenOpen && t.kind != tokenNot {
+		return nil, &ParseError{Pos: not.pos + 1, Msg: "expected a term after -"}
+	}
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return Not{Node: n}, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenOpen:
+		if p.peek().kind == tokenClose {
+			return nil, &ParseError{Pos: t.pos, Msg: "empty group"}
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		// parseOr stops at ) or the end of the query, the group is unclosed at the end
+		if end := p.next(); end.kind != tokenClose {
+			return nil, &ParseError{Pos: end.pos, Msg: "unclosed ("}
+		}
+		return n, nil
+	case tokenWord:
+		return newTerm(t), nil
+	}
+	return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("expected a term, found %s", describe(t))}
+}
+
+// newTerm splits field:value words when field is one Compile knows,
+// anything else such as re:zero is a tag with a colon in its name
+func newTerm(t token) Term {
+	if !t.quoted {
+		if field, value, ok := strings.Cut(t.text, ":"); ok {
+			if _, known := fields[strings.ToLower(field)]; known {
+				return Term{Field: strings.ToLower(field), Value: value, Pos: t.pos, ValuePos: t.pos + len(field) + 1}
+			}
+		}
+	}
+	return Term{Value: t.text, Pos: t.pos, ValuePos: t.pos}
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenWord:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return t.text
+}
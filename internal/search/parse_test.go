@@ -0,0 +1,139 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// render writes a parsed query as nested lists, e.g. (or (and "a" "b") width=">5")
+func render(n Node) string {
+	switch n := n.(type) {
+	case nil:
+		return "nil"
+	case And:
+		return renderList("and", n.Nodes)
+	case Or:
+		return renderList("or", n.Nodes)
+	case Not:
+		return "(not " + render(n.Node) + ")"
+	case Term:
+		if n.Field != "" {
+			return fmt.Sprintf("%s=%q", n.Field, n.Value)
+		}
+		return fmt.Sprintf("%q", n.Value)
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+func renderList(op string, nodes []Node) string {
+	parts := []string{op}
+	for _, n := range nodes {
+		parts = append(parts, render(n))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"blank", "   ", "nil"},
+		{"single tag", "cat", `"cat"`},
+		{"terms join with and", "cat dog", `(and "cat" "dog")`},
+		{"and binds tighter than or", "a b OR c", `(or (and "a" "b") "c")`},
+		{"or then and", "a OR b c", `(or "a" (and "b" "c"))`},
+		{"explicit and", "a AND b OR c", `(or (and "a" "b") "c")`},
+		{"chained or", "a OR b OR c", `(or "a" "b" "c")`},
+		{"group", "a (b OR c)", `(and "a" (or "b" "c"))`},
+		{"nested groups", "((a OR b) c)", `(and (or "a" "b") "c")`},
+		{"negated tag", "-a b", `(and (not "a") "b")`},
+		{"negated group", "-(a OR b)", `(not (or "a" "b"))`},
+		{"double negation", "--a", `(not (not "a"))`},
+		{"negated field", "-width:>100", `(not width=">100")`},
+		{"dash inside a tag", "k-on", `"k-on"`},
+		{"balanced parens belong to the tag", "saber_(fate) (a OR b)", `(and "saber_(fate)" (or "a" "b"))`},
+		{"group closing after a tag with parens", "(saber_(fate))", `"saber_(fate)"`},
+		{"field names are case insensitive", "Width:>5", `width=">5"`},
+		{"unknown field is a tag", "re:zero", `"re:zero"`},
+		{"quoted words", `"hello world"`, `"hello world"`},
+		{"quoted colon is not a field", `"artist:foo"`, `"artist:foo"`},
+		{"quoted value", `artist:"foo bar"`, `artist="foo bar"`},
+		{"escapes", `"a \"b\" \\ c"`, `"a \"b\" \\ c"`},
+		{"quoted operator is a tag", `a "OR" b`, `(and "a" "OR" "b")`},
+		{"lowercase or is a tag", "a or b", `(and "a" "or" "b")`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.query, err)
+			}
+			if got := render(n); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"(a b", 4, "unclosed ("},
+		{"(a OR (b c)", 11, "unclosed ("},
+		{"a)", 1, "unexpected )"},
+		{"()", 0, "empty group"},
+		{`"abc`, 0, "unterminated quote"},
+		{`a "b`, 2, "unterminated quote"},
+		{"a -", 2, "expected a term after -"},
+		{"- a", 0, "expected a term after -"},
+		{"-)", 1, "expected a term after -"},
+		{"a OR", 4, "expected a term, found end of query"},
+		{"OR a", 0, "expected a term, found OR"},
+		{"AND a", 0, "expected a term before AND"},
+		{"a AND", 5, "expected a term after AND, found end of query"},
+		{"a AND OR b", 6, "expected a term after AND, found OR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse(%q) error = %v, want a *ParseError", tt.query, err)
+			}
+			if parseErr.Pos != tt.pos || parseErr.Msg != tt.msg {
+				t.Errorf("Parse(%q) error = %q at %d, want %q at %d", tt.query, parseErr.Msg, parseErr.Pos, tt.msg, tt.pos)
+			}
+		})
+	}
+}
+
+func TestCompileNegationKeepsNullColumns(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"-monochrome:true", "NOT COALESCE((images.monochrome = ?), 0)"},
+		{"-(fav:true OR monochrome:false)", "NOT COALESCE(((images.favorite = ?) OR (images.monochrome = ?)), 0)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, err := CompileQuery(tt.query, 0)
+			if err != nil {
+				t.Fatalf("CompileQuery(%q): %v", tt.query, err)
+			}
+			if c.SQL != tt.want {
+				t.Errorf("CompileQuery(%q) = %s, want %s", tt.query, c.SQL, tt.want)
+			}
+		})
+	}
+}
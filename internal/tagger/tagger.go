@@ -0,0 +1,125 @@
+package tagger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brayanMuniz/AGO/internal/images"
+)
+
+// Tagger produces tags for a single image file
+type Tagger interface {
+	Tag(ctx context.Context, imagePath string) ([]images.ScoredTag, error)
+	// Model names the model behind the tags, recorded on the tag links it produces
+	Model() string
+}
+
+// imagePlaceholder is replaced by the image path in CommandTagger arguments
+const imagePlaceholder = "{image}"
+
+// CommandTagger runs a local program, such as the model runner script, once per image.
+// The program must print the tags to stdout, either as "tag:0.87, tag2:0.6" text or as tagger JSON.
+type CommandTagger struct {
+	Command string
+	// Args may contain {image}; without it the image path is appended as the last argument
+	Args    []string
+	Timeout time.Duration
+	// Name of the model the program runs, the command line when empty
+	Name string
+}
+
+// NewCommandTagger builds a CommandTagger from a single command line such as
+// "python tag.py --image {image}". Arguments are split on whitespace; quoting is not supported.
+// name is the model the program runs, see CommandTagger.Name.
+func NewCommandTagger(commandLine, name string, timeout time.Duration) (*CommandTagger, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("tagger command is empty")
+	}
+
+	return &CommandTagger{
+		Command: fields[0],
+		Args:    fields[1:],
+		Timeout: timeout,
+		Name:    name,
+	}, nil
+}
+
+func (t *CommandTagger) Model() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return strings.Join(append([]string{t.Command}, t.Args...), " ")
+}
+
+func (t *CommandTagger) Tag(ctx context.Context, imagePath string) ([]images.ScoredTag, error) {
+	absPath, err := filepath.Abs(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve image path: %w", err)
+	}
+
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	args := make([]string, 0, len(t.Args)+1)
+	replaced := false
+	for _, arg := range t.Args {
+		if strings.Contains(arg, imagePlaceholder) {
+			arg = strings.ReplaceAll(arg, imagePlaceholder, absPath)
+			replaced = true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, absPath)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.Command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("run tagger: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	tags, err := images.ParseScoredTags(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("parse tagger output: %w", err)
+	}
+	return tags, nil
+}
+
+// StubTagger returns fixed tags without running a model, for tests and local development
+type StubTagger struct {
+	// ByFile holds tags for specific image file names
+	ByFile map[string][]images.ScoredTag
+	// Default is returned for every other image
+	Default []images.ScoredTag
+	// Err, when set, is returned for every image
+	Err error
+	// Name is returned by Model
+	Name string
+}
+
+func (t *StubTagger) Model() string {
+	return t.Name
+}
+
+func (t *StubTagger) Tag(ctx context.Context, imagePath string) ([]images.ScoredTag, error) {
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	if tags, ok := t.ByFile[filepath.Base(imagePath)]; ok {
+		return tags, nil
+	}
+	return t.Default, nil
+}
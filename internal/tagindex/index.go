@@ -0,0 +1,276 @@
+package tagindex
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brayanMuniz/AGO/database"
+)
+
+// How a tag matched the search, best first
+const (
+	MatchExact     = "exact"
+	MatchPrefix    = "prefix"
+	MatchWord      = "word" // a word inside the name starts with the search, as ears in cat_ears
+	MatchSubstring = "substring"
+	MatchFuzzy     = "fuzzy"
+)
+
+var matchRank = map[string]int{MatchExact: 0, MatchPrefix: 1, MatchWord: 2, MatchSubstring: 3, MatchFuzzy: 4}
+
+// Result is a tag found by Search
+type Result struct {
+	database.Tag
+	Match    string `json:"match"`
+	Distance int    `json:"distance,omitempty"` // typos corrected for fuzzy matches
+}
+
+type entry struct {
+	tag   database.Tag
+	key   string // normalized name
+	runes []rune
+}
+
+// Index keeps every tag in memory, sorted by normalized name, so searching never touches the database.
+// It reloads itself when it is older than maxAge or after Invalidate. Reloads read the database
+// without holding up searches, which keep using the loaded tags until the new ones are swapped in.
+type Index struct {
+	db     *sql.DB
+	maxAge time.Duration
+
+	// load is held while reading the tags, so only one reload runs at a time
+	load sync.Mutex
+
+	mu         sync.Mutex
+	entries    []entry
+	loadedAt   time.Time
+	generation int // bumped by Invalidate
+	loadedGen  int // generation the entries were loaded at
+	refreshing bool
+}
+
+// New returns an index that loads on the first search
+func New(db *sql.DB, maxAge time.Duration) *Index {
+	return &Index{db: db, maxAge: maxAge}
+}
+
+// Invalidate makes the next search reload the tags, call it after tags or their counts change.
+// A nil *Index ignores it.
+func (ix *Index) Invalidate() {
+	if ix == nil {
+		return
+	}
+	ix.mu.Lock()
+	ix.generation++
+	ix.mu.Unlock()
+}
+
+// snapshot returns the loaded tags. Tags that are only old are returned while a reload runs in
+// the background, invalidated or missing ones are reloaded first.
+func (ix *Index) snapshot() ([]entry, error) {
+	ix.mu.Lock()
+	entries := ix.entries
+	switch {
+	case entries == nil || ix.loadedGen != ix.generation:
+		ix.mu.Unlock()
+		return ix.reload()
+	case time.Since(ix.loadedAt) >= ix.maxAge && !ix.refreshing:
+		ix.refreshing = true
+		go func() {
+			ix.reload()
+			ix.mu.Lock()
+			ix.refreshing = false
+			ix.mu.Unlock()
+		}()
+	}
+	ix.mu.Unlock()
+	return entries, nil
+}
+
+// reload reads every tag and swaps them in, unless another reload brought them up to date meanwhile
+func (ix *Index) reload() ([]entry, error) {
+	ix.load.Lock()
+	defer ix.load.Unlock()
+
+	ix.mu.Lock()
+	if ix.entries != nil && ix.loadedGen == ix.generation && time.Since(ix.loadedAt) < ix.maxAge {
+		entries := ix.entries
+		ix.mu.Unlock()
+		return entries, nil
+	}
+	generation := ix.generation
+	ix.mu.Unlock()
+
+	tags, err := database.GetAllTags(ix.db)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]entry, len(tags))
+	for i, tag := range tags {
+		key := Normalize(tag.Name)
+		entries[i] = entry{tag: tag, key: key, runes: []rune(key)}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	ix.mu.Lock()
+	ix.entries = entries
+	ix.loadedAt = time.Now()
+	// An Invalidate during the load leaves the generation ahead, so the next search loads again
+	ix.loadedGen = generation
+	ix.mu.Unlock()
+	return entries, nil
+}
+
+// Normalize lowercases a tag name and treats spaces and underscores alike, so "Cat Ears" finds cat_ears
+func Normalize(name string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '_' || r == ' ' || r == '\t'
+	})
+	return strings.Join(fields, "_")
+}
+
+// Search returns up to limit tags of category (any when empty) matching query by prefix,
+// by substring or within a typo or two. Better matches come first, then favorites, then the most used tags.
+// An empty query returns the most used tags.
+func (ix *Index) Search(query, category string, limit int) ([]Result, error) {
+	entries, err := ix.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	q := Normalize(query)
+	inCategory := func(e entry) bool { return category == "" || e.tag.Category == category }
+
+	var results []Result
+	if q == "" {
+		for _, e := range entries {
+			if inCategory(e) {
+				results = append(results, Result{Tag: e.tag, Match: MatchPrefix})
+			}
+		}
+		return rank(results, limit), nil
+	}
+
+	// Names starting with q sit next to each other in the sorted entries
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].key >= q })
+	for i := start; i < len(entries) && strings.HasPrefix(entries[i].key, q); i++ {
+		if !inCategory(entries[i]) {
+			continue
+		}
+		match := MatchPrefix
+		if entries[i].key == q {
+			match = MatchExact
+		}
+		results = append(results, Result{Tag: entries[i].tag, Match: match})
+	}
+	// Prefix matches always outrank the rest, so a full page of them is the answer
+	if len(results) >= limit {
+		return rank(results, limit), nil
+	}
+
+	qRunes := []rune(q)
+	maxDistance := 0
+	switch {
+	case len(qRunes) >= 8:
+		maxDistance = 2
+	case len(qRunes) >= 3:
+		maxDistance = 1
+	}
+
+	var scratch distanceRows
+	for _, e := range entries {
+		if !inCategory(e) || strings.HasPrefix(e.key, q) {
+			continue
+		}
+		if strings.Contains(e.key, q) {
+			match := MatchSubstring
+			if strings.Contains(e.key, "_"+q) {
+				match = MatchWord
+			}
+			results = append(results, Result{Tag: e.tag, Match: match})
+			continue
+		}
+		if maxDistance == 0 {
+			continue
+		}
+		// A typo may be in a finished name or in the part typed so far
+		d := maxDistance + 1
+		if n := len(e.runes) - len(qRunes); n >= -maxDistance && n <= maxDistance {
+			d = scratch.distance(qRunes, e.runes, maxDistance)
+		}
+		if len(e.runes) > len(qRunes) {
+			d = min(d, scratch.distance(qRunes, e.runes[:len(qRunes)], maxDistance))
+		}
+		if d <= maxDistance {
+			results = append(results, Result{Tag: e.tag, Match: MatchFuzzy, Distance: d})
+		}
+	}
+
+	return rank(results, limit), nil
+}
+
+func rank(results []Result, limit int) []Result {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if matchRank[a.Match] != matchRank[b.Match] {
+			return matchRank[a.Match] < matchRank[b.Match]
+		}
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		if a.IsFavorite != b.IsFavorite {
+			return a.IsFavorite
+		}
+		if a.ImageCount != b.ImageCount {
+			return a.ImageCount > b.ImageCount
+		}
+		return a.Name < b.Name
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// distanceRows are the rows distance works in, reused across calls
+type distanceRows struct {
+	rows [3][]int
+}
+
+// distance is the optimal string alignment distance between a and b, where swapping two neighbors
+// counts as one typo. It stops early and returns maxDistance+1 once the distance is known to exceed it.
+func (s *distanceRows) distance(a, b []rune, maxDistance int) int {
+	for i := range s.rows {
+		if cap(s.rows[i]) < len(b)+1 {
+			s.rows[i] = make([]int, len(b)+1)
+		}
+	}
+	prev2, prev, cur := s.rows[0][:len(b)+1], s.rows[1][:len(b)+1], s.rows[2][:len(b)+1]
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > maxDistance {
+			return maxDistance + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return min(prev[len(b)], maxDistance+1)
+}
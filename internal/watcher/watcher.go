@@ -0,0 +1,302 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/images"
+	"github.com/brayanMuniz/AGO/internal/ingest"
+)
+
+// Options configures which directories are watched and how often
+type Options struct {
+	RawDir     string
+	GalleryDir string
+	TxtDir     string
+	TagMapPath string
+	// ThresholdsPath holds per-category minimum tagger confidences, optional
+	ThresholdsPath string
+	// Interval between directory scans
+	Interval time.Duration
+	// Settle is how long a raw file must stay unchanged before it is organized
+	Settle time.Duration
+	// Paused starts the watcher without processing anything until Resume is called
+	Paused bool
+	// Imported is called after a scan imported tag files, optional
+	Imported func()
+}
+
+// Status is a snapshot of what the watcher has done since the server started
+type Status struct {
+	Paused          bool       `json:"paused"`
+	Interval        string     `json:"interval"`
+	LastScan        *time.Time `json:"last_scan"`
+	PendingFiles    int        `json:"pending_files"`     // raw files still changing
+	WaitingTagFiles int        `json:"waiting_tag_files"` // tag files whose image is not in the gallery yet
+	Organized       int        `json:"organized"`
+	Imported        int        `json:"imported"`
+	Failed          int        `json:"failed"`
+	LastError       string     `json:"last_error"`
+}
+
+// fileState tracks a file between scans so we only act once it stops changing
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // when size and modTime were first seen with these values
+	done    bool      // handled (or failed) for this size and modTime
+	fails   int       // failed imports, retried on later scans up to maxImportAttempts
+}
+
+// maxImportAttempts is how many scans try a tag file whose import keeps failing,
+// a locked database or a full disk may clear up by the next scan
+const maxImportAttempts = 3
+
+// Watcher polls the raw image and tag file directories and ingests new files automatically
+type Watcher struct {
+	db   *sql.DB
+	opts Options
+
+	mu       sync.Mutex
+	status   Status
+	rawFiles map[string]*fileState
+	tagFiles map[string]*fileState
+}
+
+func New(db *sql.DB, opts Options) *Watcher {
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.Settle <= 0 {
+		opts.Settle = 2 * opts.Interval
+	}
+
+	return &Watcher{
+		db:   db,
+		opts: opts,
+		status: Status{
+			Paused:   opts.Paused,
+			Interval: opts.Interval.String(),
+		},
+		rawFiles: make(map[string]*fileState),
+		tagFiles: make(map[string]*fileState),
+	}
+}
+
+// Run scans on every interval until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if !w.Status().Paused {
+			w.scan()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Watcher) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Paused = true
+}
+
+func (w *Watcher) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Paused = false
+}
+
+func (w *Watcher) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *Watcher) scan() {
+	pending := w.scanRawImages()
+	waiting := w.scanTagFiles()
+
+	now := time.Now().UTC()
+	w.mu.Lock()
+	w.status.LastScan = &now
+	w.status.PendingFiles = pending
+	w.status.WaitingTagFiles = waiting
+	w.mu.Unlock()
+}
+
+// scanRawImages organizes raw files that have settled and returns how many are still changing
+func (w *Watcher) scanRawImages() int {
+	if _, err := os.Stat(w.opts.RawDir); os.IsNotExist(err) {
+		return 0
+	}
+
+	paths, err := images.ListRawFiles(w.opts.RawDir)
+	if err != nil {
+		w.recordError(fmt.Errorf("walk raw dir: %w", err))
+		return 0
+	}
+
+	if err := os.MkdirAll(w.opts.GalleryDir, os.ModePerm); err != nil {
+		w.recordError(fmt.Errorf("create gallery dir: %w", err))
+		return 0
+	}
+
+	pending := 0
+	present := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		present[path] = true
+
+		state, settled := w.track(w.rawFiles, path)
+		if state == nil || state.done {
+			continue
+		}
+		if !settled {
+			pending++
+			continue
+		}
+
+		result := images.OrganizeFile(path, w.opts.GalleryDir, images.OrganizeOptions{})
+		switch result.Status {
+		case images.OrganizeMoved:
+			if err := ingest.RecordProvenance(w.db, result); err != nil {
+				w.recordError(fmt.Errorf("record provenance for %s: %w", filepath.Base(path), err))
+			}
+			w.count(&w.status.Organized)
+			delete(w.rawFiles, path)
+		case images.OrganizeFailed:
+			w.recordError(fmt.Errorf("organize %s: %w", filepath.Base(path), result.Err))
+			state.done = true
+		default:
+			// Not an image or already in the gallery, leave it alone until it changes
+			state.done = true
+		}
+	}
+
+	forgetMissing(w.rawFiles, present)
+	return pending
+}
+
+// scanTagFiles imports settled tag files and returns how many are waiting on their image
+func (w *Watcher) scanTagFiles() int {
+	files, err := os.ReadDir(w.opts.TxtDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.recordError(fmt.Errorf("read tag files: %w", err))
+		}
+		return 0
+	}
+
+	var tagMap map[string]string
+	var thresholds map[string]float64
+	waiting := 0
+	imported := false
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		if file.IsDir() || !ingest.IsTagFile(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(w.opts.TxtDir, file.Name())
+		present[path] = true
+
+		state, settled := w.track(w.tagFiles, path)
+		if state == nil || state.done || !settled {
+			continue
+		}
+
+		name := ingest.NameFromTagFile(path)
+		if _, err := database.FindImageFile(w.opts.GalleryDir, name); err != nil {
+			// The image may still be waiting in the raw directory, check again next scan
+			waiting++
+			continue
+		}
+
+		if tagMap == nil {
+			tagMap, err = images.LoadTagCategoryMapping(w.opts.TagMapPath)
+			if err != nil {
+				w.recordError(fmt.Errorf("load tag metadata: %w", err))
+				return waiting
+			}
+			thresholds, err = images.LoadTagThresholds(w.opts.ThresholdsPath)
+			if err != nil {
+				w.recordError(fmt.Errorf("load tag thresholds: %w", err))
+				return waiting
+			}
+		}
+
+		outcome, err := ingest.ImportTagFile(w.db, path, w.opts.GalleryDir, tagMap, thresholds)
+		if err != nil {
+			w.recordError(fmt.Errorf("import %s: %w", file.Name(), err))
+			// A tag file that cannot be read stays broken until it changes, anything else is tried again
+			state.fails++
+			state.done = outcome == database.OutcomeDecodeError || state.fails >= maxImportAttempts
+			continue
+		}
+		if outcome == database.OutcomeImported || outcome == database.OutcomeRestored {
+			w.count(&w.status.Imported)
+			imported = true
+		}
+		state.done = true
+	}
+
+	forgetMissing(w.tagFiles, present)
+	if imported && w.opts.Imported != nil {
+		w.opts.Imported()
+	}
+	return waiting
+}
+
+// track updates the state of path and reports whether it has been unchanged for the settle period
+func (w *Watcher) track(states map[string]*fileState, path string) (*fileState, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		delete(states, path)
+		return nil, false
+	}
+
+	now := time.Now()
+	state, ok := states[path]
+	if !ok || state.size != info.Size() || !state.modTime.Equal(info.ModTime()) {
+		state = &fileState{size: info.Size(), modTime: info.ModTime(), since: now}
+		states[path] = state
+	}
+
+	settled := now.Sub(state.since) >= w.opts.Settle && now.Sub(info.ModTime()) >= w.opts.Settle
+	return state, settled
+}
+
+func forgetMissing(states map[string]*fileState, present map[string]bool) {
+	for path := range states {
+		if !present[path] {
+			delete(states, path)
+		}
+	}
+}
+
+func (w *Watcher) count(counter *int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	*counter++
+}
+
+func (w *Watcher) recordError(err error) {
+	fmt.Printf("Watcher: %v\n", err)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Failed++
+	w.status.LastError = err.Error()
+}
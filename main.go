@@ -1,21 +1,72 @@
 package main
 
 import (
+	"context"
 	"github.com/brayanMuniz/AGO/database"
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/ingest"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/brayanMuniz/AGO/internal/watcher"
 	"github.com/brayanMuniz/AGO/routes"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
+	"os"
+	"time"
 )
 
+// tagIndexMaxAge is how stale autocomplete counts may get before the tag index reloads on its own
+const tagIndexMaxAge = 30 * time.Second
+
 func main() {
-	database, err := database.InitDB("./galleryDB.db")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	database, err := database.InitDB(cfg.Database)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer database.Close()
 
-	r := routes.SetupRouter(database)
-	if err := r.Run(":8081"); err != nil {
+	jobManager, err := jobs.NewManager(database)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := ingest.QueueHashBackfill(database, jobManager, cfg.GalleryDir); err != nil {
+		log.Fatal(err)
+	}
+
+	tagIndex := tagindex.New(database, tagIndexMaxAge)
+
+	// The watcher always runs so it can be resumed over the API, but only starts active with -watch
+	libraryWatcher := watcher.New(database, watcher.Options{
+		RawDir:         cfg.RawDir,
+		GalleryDir:     cfg.GalleryDir,
+		TxtDir:         cfg.TxtDir,
+		TagMapPath:     cfg.TagMapPath,
+		ThresholdsPath: cfg.ThresholdsPath,
+		Interval:       cfg.WatchInterval,
+		Paused:         !cfg.Watch,
+		Imported:       tagIndex.Invalidate,
+	})
+	go libraryWatcher.Run(context.Background())
+
+	// Tagging is disabled unless a tagger command is given
+	var imageTagger tagger.Tagger
+	if cfg.Tagger != "" {
+		commandTagger, err := tagger.NewCommandTagger(cfg.Tagger, cfg.TaggerModel, cfg.TaggerTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		imageTagger = commandTagger
+	}
+
+	r := routes.SetupRouter(database, cfg, jobManager, libraryWatcher, imageTagger, tagIndex)
+	if err := r.Run(cfg.Addr); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
 }
@@ -29,7 +29,7 @@ func RegisterCategoriesRoute(r *gin.RouterGroup, db *sql.DB) {
 
 func categoryHandler(db *sql.DB, category string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		tags, err := database.GetTagsByCategory(db, category)
+		tags, err := database.GetTagsByCategory(db, category, utils.ParseMinConfidence(ctx))
 		if err != nil {
 			ctx.JSON(500, gin.H{"error": "Failed to fetch tags"})
 			return
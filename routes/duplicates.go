@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterDuplicateRoutes(r *gin.RouterGroup, db *sql.DB, cfg *config.Config, index *tagindex.Index) {
+	duplicateGroup := r.Group("/duplicates")
+
+	duplicateGroup.GET("/", handlers.GetDuplicatesHandler(db))
+	duplicateGroup.GET("/flagged", handlers.GetDuplicateFlagsHandler(db))
+	duplicateGroup.POST("/resolve", handlers.ResolveDuplicatesHandler(db, cfg, index))
+}
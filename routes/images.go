@@ -3,27 +3,37 @@ package routes
 import (
 	"database/sql"
 
+	"github.com/brayanMuniz/AGO/internal/config"
 	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterImageRoutes(r *gin.RouterGroup, db *sql.DB) {
+func RegisterImageRoutes(r *gin.RouterGroup, db *sql.DB, cfg *config.Config, jobManager *jobs.Manager, imageTagger tagger.Tagger, index *tagindex.Index) {
 	imageGroup := r.Group("/images")
 	{
 		imageGroup.GET("/", handlers.GetImagesHandler(db))
 		imageGroup.GET("/by-tags", handlers.GetImagesByTagsHandler(db))
-		imageGroup.GET("/file/:filename", handlers.ServeImageFileHandler())
-		imageGroup.POST("/organize", handlers.OrganizeImagesHandler())
-		imageGroup.POST("/import", handlers.PopulateDatabaseHanlder(db))
-		imageGroup.POST("/export", handlers.ExportImagesHandler(db))
+		imageGroup.GET("/file/:filename", handlers.ServeImageFileHandler(cfg))
+		imageGroup.GET("/sha256/:sha", handlers.GetImageBySHA256Handler(db))
+		imageGroup.POST("/organize", handlers.OrganizeImagesHandler(db, cfg, jobManager))
+		imageGroup.POST("/import", handlers.PopulateDatabaseHanlder(db, cfg, jobManager, index))
+		imageGroup.POST("/tag", handlers.TagImagesHandler(db, cfg, jobManager, imageTagger, index))
+		imageGroup.POST("/colors", handlers.AnalyzeColorsHandler(db, cfg, jobManager))
+		imageGroup.POST("/upload", handlers.UploadImagesHandler(db, cfg, index))
+		imageGroup.POST("/export", handlers.ExportImagesHandler(db, cfg))
+		imageGroup.POST("/delete", handlers.DeleteImagesHandler(db, cfg, index))
 
 		imageGroup.GET("/:id", handlers.GetImageByIDHandler(db))
+		imageGroup.DELETE("/:id", handlers.DeleteImageHandler(db, cfg, index))
 
 		imageUpdateGroup := imageGroup.Group("/:id")
 		{
 			// Tag management
-			imageUpdateGroup.POST("/tags", handlers.AddTagToImageHandler(db))
-			imageUpdateGroup.DELETE("/tags", handlers.RemoveTagFromImageHandler(db))
+			imageUpdateGroup.POST("/tags", handlers.AddTagToImageHandler(db, index))
+			imageUpdateGroup.DELETE("/tags", handlers.RemoveTagFromImageHandler(db, index))
 		}
 
 	}
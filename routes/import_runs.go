@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterImportRunRoutes(r *gin.RouterGroup, db *sql.DB, cfg *config.Config, jobManager *jobs.Manager, imageTagger tagger.Tagger, index *tagindex.Index) {
+	runGroup := r.Group("/import-runs")
+	{
+		runGroup.GET("/", handlers.GetImportRunsHandler(db))
+		runGroup.GET("/:id", handlers.GetImportRunHandler(db))
+		runGroup.POST("/:id/retry", handlers.RetryImportRunHandler(db, cfg, jobManager, imageTagger, index))
+	}
+}
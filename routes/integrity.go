@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterIntegrityRoutes(r *gin.RouterGroup, db *sql.DB, cfg *config.Config, jobManager *jobs.Manager) {
+	integrityGroup := r.Group("/integrity")
+	{
+		integrityGroup.POST("/scan", handlers.CheckIntegrityHandler(db, cfg, jobManager))
+		integrityGroup.POST("/:id/repair", handlers.RepairIntegrityHandler(db, cfg, jobManager))
+	}
+}
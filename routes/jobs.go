@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterJobRoutes(r *gin.RouterGroup, jobManager *jobs.Manager) {
+	jobGroup := r.Group("/jobs")
+
+	jobGroup.GET("/", handlers.GetJobsHandler(jobManager))
+	jobGroup.GET("/:id", handlers.GetJobHandler(jobManager))
+	jobGroup.GET("/:id/events", handlers.StreamJobHandler(jobManager))
+	jobGroup.POST("/:id/cancel", handlers.CancelJobHandler(jobManager))
+}
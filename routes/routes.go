@@ -2,12 +2,18 @@ package routes
 
 import (
 	"database/sql"
+
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/tagger"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/brayanMuniz/AGO/internal/watcher"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func SetupRouter(database *sql.DB) *gin.Engine {
+func SetupRouter(database *sql.DB, cfg *config.Config, jobManager *jobs.Manager, libraryWatcher *watcher.Watcher, imageTagger tagger.Tagger, tagIndex *tagindex.Index) *gin.Engine {
 	r := gin.Default()
 
 	// Add gzip compression middleware for better performance
@@ -15,10 +21,17 @@ func SetupRouter(database *sql.DB) *gin.Engine {
 
 	api := r.Group("/api")
 
-	RegisterImageRoutes(api, database)
+	RegisterImageRoutes(api, database, cfg, jobManager, imageTagger, tagIndex)
 	RegisterCategoriesRoute(api, database)
+	RegisterTagRoutes(api, database, jobManager, tagIndex)
 	RegisterAlbumRoutes(api, database)
-	RegisterUserRoutes(api, database)
+	RegisterUserRoutes(api, database, tagIndex)
+	RegisterJobRoutes(api, jobManager)
+	RegisterImportRunRoutes(api, database, cfg, jobManager, imageTagger, tagIndex)
+	RegisterDuplicateRoutes(api, database, cfg, tagIndex)
+	RegisterWatcherRoutes(api, libraryWatcher)
+	RegisterIntegrityRoutes(api, database, cfg, jobManager)
+	RegisterTrashRoutes(api, database, cfg, tagIndex)
 
 	return r
 }
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/jobs"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterTagRoutes(r *gin.RouterGroup, db *sql.DB, jobManager *jobs.Manager, index *tagindex.Index) {
+	tagGroup := r.Group("/tags")
+	{
+		tagGroup.GET("/search", handlers.SearchTagsHandler(index))
+
+		tagGroup.GET("/aliases", handlers.GetTagAliasesHandler(db))
+		tagGroup.POST("/aliases", handlers.CreateTagAliasHandler(db, index))
+		tagGroup.DELETE("/aliases/:alias", handlers.DeleteTagAliasHandler(db))
+
+		tagGroup.GET("/implications", handlers.GetTagImplicationsHandler(db))
+		tagGroup.POST("/implications", handlers.CreateTagImplicationHandler(db, jobManager, index))
+		tagGroup.DELETE("/implications", handlers.DeleteTagImplicationHandler(db))
+	}
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/brayanMuniz/AGO/internal/config"
+	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterTrashRoutes(r *gin.RouterGroup, db *sql.DB, cfg *config.Config, index *tagindex.Index) {
+	trashGroup := r.Group("/trash")
+	{
+		trashGroup.GET("/", handlers.GetTrashHandler(db, cfg))
+		trashGroup.POST("/purge", handlers.PurgeTrashHandler(db, cfg, index))
+		trashGroup.POST("/:id/restore", handlers.RestoreImageHandler(db, cfg, index))
+		trashGroup.DELETE("/:id", handlers.PurgeImageHandler(db, cfg, index))
+	}
+}
@@ -4,26 +4,27 @@ import (
 	"database/sql"
 
 	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/tagindex"
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterUserRoutes(r *gin.RouterGroup, db *sql.DB) {
+func RegisterUserRoutes(r *gin.RouterGroup, db *sql.DB, index *tagindex.Index) {
 	userGroup := r.Group("/user")
 	favoriteGroup := userGroup.Group("/favorite")
 
 	// Tag favorites
-	favoriteGroup.POST("/tag/:id", handlers.AddTagFavoriteHandler(db))
-	favoriteGroup.DELETE("/tag/:id", handlers.RemoveTagFavoriteHandler(db))
+	favoriteGroup.POST("/tag/:id", handlers.AddTagFavoriteHandler(db, index))
+	favoriteGroup.DELETE("/tag/:id", handlers.RemoveTagFavoriteHandler(db, index))
 
 	// Artist favorites
-	favoriteGroup.POST("/artist/:id", handlers.AddTagFavoriteHandler(db))
-	favoriteGroup.DELETE("/artist/:id", handlers.RemoveTagFavoriteHandler(db))
+	favoriteGroup.POST("/artist/:id", handlers.AddTagFavoriteHandler(db, index))
+	favoriteGroup.DELETE("/artist/:id", handlers.RemoveTagFavoriteHandler(db, index))
 
 	// Character favorites
-	favoriteGroup.POST("/character/:id", handlers.AddTagFavoriteHandler(db))
-	favoriteGroup.DELETE("/character/:id", handlers.RemoveTagFavoriteHandler(db))
+	favoriteGroup.POST("/character/:id", handlers.AddTagFavoriteHandler(db, index))
+	favoriteGroup.DELETE("/character/:id", handlers.RemoveTagFavoriteHandler(db, index))
 
 	// Series favorites
-	favoriteGroup.POST("/series/:id", handlers.AddTagFavoriteHandler(db))
-	favoriteGroup.DELETE("/series/:id", handlers.RemoveTagFavoriteHandler(db))
+	favoriteGroup.POST("/series/:id", handlers.AddTagFavoriteHandler(db, index))
+	favoriteGroup.DELETE("/series/:id", handlers.RemoveTagFavoriteHandler(db, index))
 }
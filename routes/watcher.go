@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/brayanMuniz/AGO/internal/handlers"
+	"github.com/brayanMuniz/AGO/internal/watcher"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterWatcherRoutes(r *gin.RouterGroup, w *watcher.Watcher) {
+	watcherGroup := r.Group("/watcher")
+
+	watcherGroup.GET("/", handlers.GetWatcherStatusHandler(w))
+	watcherGroup.POST("/pause", handlers.PauseWatcherHandler(w))
+	watcherGroup.POST("/resume", handlers.ResumeWatcherHandler(w))
+}
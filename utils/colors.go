@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"strconv"
+
+	"github.com/brayanMuniz/AGO/internal/images"
+)
+
+const (
+	// DefaultColorTolerance is how far, in CIELAB units, a palette color may be from the one searched for.
+	// Around 2 is barely noticeable, 20 still reads as the same color.
+	DefaultColorTolerance = 20
+	// minColorWeight keeps specks of color out of color search, a match must cover this share of the image
+	minColorWeight = 0.05
+)
+
+// LabColor is a color searched for, in CIELAB
+type LabColor struct {
+	L, A, B float64
+}
+
+// String writes the color as L,A,B with the precision the queries use
+func (c LabColor) String() string {
+	return formatLab(c.L) + "," + formatLab(c.A) + "," + formatLab(c.B)
+}
+
+func formatLab(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+// parseColorParam reads a #rrggbb color, returning nil for anything else
+func parseColorParam(value string) *LabColor {
+	if value == "" {
+		return nil
+	}
+	l, a, b, err := images.HexToLab(value)
+	if err != nil {
+		return nil
+	}
+	return &LabColor{L: l, A: a, B: b}
+}
+
+func parseColorTolerance(value string) float64 {
+	tolerance, err := strconv.ParseFloat(value, 64)
+	if err != nil || tolerance <= 0 {
+		return DefaultColorTolerance
+	}
+	return tolerance
+}
+
+// parseBoolParam returns nil when value is empty or not a boolean
+func parseBoolParam(value string) *bool {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil
+	}
+	return &b
+}
+
+// colorDistanceSQL is the squared CIELAB distance between the image_colors row ic and color
+func colorDistanceSQL(color LabColor) string {
+	f := formatLab
+	return "((ic.l - " + f(color.L) + ") * (ic.l - " + f(color.L) + ")" +
+		" + (ic.a - " + f(color.A) + ") * (ic.a - " + f(color.A) + ")" +
+		" + (ic.b - " + f(color.B) + ") * (ic.b - " + f(color.B) + "))"
+}
+
+// BuildColorFilterCondition keeps images with a dominant color within tolerance of color
+func BuildColorFilterCondition(color LabColor, tolerance float64) FilterCondition {
+	return FilterCondition{
+		SQL: `EXISTS (
+			SELECT 1 FROM image_colors ic
+			WHERE ic.image_id = images.id AND ic.weight >= ? AND ` + colorDistanceSQL(color) + ` <= ?
+		)`,
+		Args: []interface{}{minColorWeight, tolerance * tolerance},
+	}
+}
+
+// BuildMonochromeFilterCondition keeps greyscale and single-hue images, or only the others.
+// Images whose colors have not been analyzed match neither.
+func BuildMonochromeFilterCondition(monochrome bool) FilterCondition {
+	return FilterCondition{SQL: "images.monochrome = ?", Args: []interface{}{monochrome}}
+}
+
+// colorSortKey scores how close an image's palette comes to color, lower is closer.
+// A palette color scores its distance, padded so a large patch of a near color
+// beats a speck of the exact one, divided by its share of the image.
+// Images without an analyzed palette score highest and come last.
+func colorSortKey(color LabColor) string {
+	return "COALESCE((SELECT MIN((" + colorDistanceSQL(color) + " + 400) / ic.weight) FROM image_colors ic WHERE ic.image_id = images.id), 1e18)"
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a row of a sorted list, pages are read after it or, for Before, up to it.
+// It is handed to clients as an opaque token and remembers the sort it was made for.
+type Cursor struct {
+	Sort   string      `json:"s"`
+	Seed   string      `json:"r,omitempty"`
+	Color  string      `json:"c,omitempty"`
+	Key    interface{} `json:"k"`
+	ID     int64       `json:"i"`
+	Before bool        `json:"b,omitempty"`
+}
+
+// Encode returns the cursor as a URL-safe token
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor reads a token made by Encode
+func DecodeCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var c Cursor
+	if err := decoder.Decode(&c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	// Numbers come back as they went in, integers stay integers
+	switch key := c.Key.(type) {
+	case json.Number:
+		if n, err := key.Int64(); err == nil {
+			c.Key = n
+		} else if f, err := key.Float64(); err == nil {
+			c.Key = f
+		} else {
+			return nil, ErrInvalidCursor
+		}
+	case string:
+	default:
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Page picks a page of a sorted list: the rows next to Cursor when it is set, page Number otherwise
+type Page struct {
+	Number int
+	Limit  int
+	Cursor *Cursor
+}
+
+// Clause returns the LIMIT and OFFSET that end a query for p, or nothing when Limit is zero.
+// It asks for one row past the limit so FinishPage can tell whether more follow.
+func (p Page) Clause() (string, []interface{}) {
+	if p.Limit == 0 {
+		return "", nil
+	}
+	offset := 0
+	if p.Cursor == nil {
+		offset = (p.Number - 1) * p.Limit
+	}
+	return "LIMIT ? OFFSET ?", []interface{}{p.Limit + 1, offset}
+}
+
+// Keyed is a row read with Sort.Column, which knows its place in the sort
+type Keyed interface {
+	SortPosition() (key interface{}, id int64)
+}
+
+// FinishPage drops the extra row Page.Clause asks for and puts a page read backwards back in order.
+// more reports whether rows are left past the page in the direction it was read.
+func FinishPage[T any](rows []T, p Page) ([]T, bool) {
+	more := p.Limit > 0 && len(rows) > p.Limit
+	if more {
+		rows = rows[:p.Limit]
+	}
+	if p.Cursor != nil && p.Cursor.Before {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows, more
+}
+
+// PageCursors returns the tokens of the pages before and after rows, a page finished by FinishPage.
+// A token is empty when there is no such page.
+func PageCursors[T Keyed](s Sort, p Page, rows []T, more bool) (prev, next string) {
+	if len(rows) == 0 {
+		return "", ""
+	}
+	backward := p.Cursor != nil && p.Cursor.Before
+
+	if more || backward {
+		key, id := rows[len(rows)-1].SortPosition()
+		next = s.cursor(key, id, false).Encode()
+	}
+	if backward && more || !backward && (p.Cursor != nil || p.Number > 1) {
+		key, id := rows[0].SortPosition()
+		prev = s.cursor(key, id, true).Encode()
+	}
+	return prev, next
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name  string
+		token string
+		want  *Cursor
+	}{
+		{"integer key", Cursor{Sort: "size_desc", Key: int64(1024), ID: 7}.Encode(), &Cursor{Sort: "size_desc", Key: int64(1024), ID: 7}},
+		{"float key", Cursor{Sort: "aspect_asc", Key: 1.5, ID: 3}.Encode(), &Cursor{Sort: "aspect_asc", Key: 1.5, ID: 3}},
+		{"string key", Cursor{Sort: "name_asc", Key: "a.png", ID: 2, Before: true}.Encode(), &Cursor{Sort: "name_asc", Key: "a.png", ID: 2, Before: true}},
+		{"seed", Cursor{Sort: "random", Seed: "abc", Key: int64(-5), ID: 1}.Encode(), &Cursor{Sort: "random", Seed: "abc", Key: int64(-5), ID: 1}},
+		{"color", Cursor{Sort: "color", Color: "1.0000,2.0000,3.0000", Key: 400.25, ID: 9}.Encode(), &Cursor{Sort: "color", Color: "1.0000,2.0000,3.0000", Key: 400.25, ID: 9}},
+		{"large integer stays exact", raw(`{"s":"size_asc","k":9007199254740993,"i":1}`), &Cursor{Sort: "size_asc", Key: int64(9007199254740993), ID: 1}},
+		{"not base64", "!!!", nil},
+		{"not json", raw("cursor"), nil},
+		{"missing sort", raw(`{"k":1,"i":1}`), nil},
+		{"missing key", raw(`{"s":"size_asc","i":1}`), nil},
+		{"boolean key", raw(`{"s":"size_asc","k":true,"i":1}`), nil},
+		{"object key", raw(`{"s":"size_asc","k":{},"i":1}`), nil},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCursor(tt.token)
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidCursor) {
+					t.Fatalf("DecodeCursor(%q) = %+v, %v, want ErrInvalidCursor", tt.token, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeCursor(%q): %v", tt.token, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeCursor(%q) = %+v, want %+v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+type testRow struct {
+	key int64
+	id  int64
+}
+
+func (r testRow) SortPosition() (interface{}, int64) {
+	return r.key, r.id
+}
+
+func TestPageCursors(t *testing.T) {
+	sort := BuildSort("size_asc", "")
+	rows := []testRow{{10, 1}, {20, 2}, {30, 3}}
+	after := func(r testRow) *Cursor { return &Cursor{Sort: sort.Name, Key: r.key, ID: r.id} }
+	before := func(r testRow) *Cursor { return &Cursor{Sort: sort.Name, Key: r.key, ID: r.id, Before: true} }
+
+	tests := []struct {
+		name     string
+		page     Page
+		rows     []testRow
+		more     bool
+		wantPrev *Cursor
+		wantNext *Cursor
+	}{
+		{"only page", Page{Number: 1, Limit: 3}, rows, false, nil, nil},
+		{"first page", Page{Number: 1, Limit: 3}, rows, true, nil, after(rows[2])},
+		{"middle page by number", Page{Number: 2, Limit: 3}, rows, true, before(rows[0]), after(rows[2])},
+		{"last page by number", Page{Number: 3, Limit: 3}, rows, false, before(rows[0]), nil},
+		{"page after a cursor", Page{Limit: 3, Cursor: after(testRow{5, 9})}, rows, true, before(rows[0]), after(rows[2])},
+		{"last page after a cursor", Page{Limit: 3, Cursor: after(testRow{5, 9})}, rows, false, before(rows[0]), nil},
+		{"page before a cursor", Page{Limit: 3, Cursor: before(testRow{40, 4})}, rows, true, before(rows[0]), after(rows[2])},
+		{"first page before a cursor", Page{Limit: 3, Cursor: before(testRow{40, 4})}, rows, false, nil, after(rows[2])},
+		{"empty page", Page{Number: 2, Limit: 3}, nil, false, nil, nil},
+		{"empty page before a cursor", Page{Limit: 3, Cursor: before(testRow{10, 1})}, nil, false, nil, nil},
+	}
+
+	decode := func(t *testing.T, token string) *Cursor {
+		t.Helper()
+		if token == "" {
+			return nil
+		}
+		c, err := DecodeCursor(token)
+		if err != nil {
+			t.Fatalf("DecodeCursor(%q): %v", token, err)
+		}
+		return c
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, next := PageCursors(sort, tt.page, tt.rows, tt.more)
+			if got := decode(t, prev); !reflect.DeepEqual(got, tt.wantPrev) {
+				t.Errorf("prev = %+v, want %+v", got, tt.wantPrev)
+			}
+			if got := decode(t, next); !reflect.DeepEqual(got, tt.wantNext) {
+				t.Errorf("next = %+v, want %+v", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestFinishPageBackward(t *testing.T) {
+	// A page before a cursor is read nearest first, one row past the limit
+	read := []testRow{{30, 3}, {20, 2}, {10, 1}}
+	page := Page{Limit: 2, Cursor: &Cursor{Sort: "size_asc", Key: int64(40), ID: 4, Before: true}}
+
+	rows, more := FinishPage(read, page)
+	if want := []testRow{{20, 2}, {30, 3}}; !reflect.DeepEqual(rows, want) || !more {
+		t.Errorf("FinishPage = %v, %v, want %v, true", rows, more, want)
+	}
+}
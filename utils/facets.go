@@ -0,0 +1,30 @@
+package utils
+
+import "strings"
+
+// TagCategories maps the names the API uses for tag categories to stored categories
+var TagCategories = map[string]string{
+	"tags":       "general",
+	"series":     "copyright",
+	"characters": "character",
+	"artists":    "artist",
+	"ratings":    "rating",
+}
+
+// DefaultFacetLimit is how many tags a facet lists unless ?facet_limit= says otherwise
+const DefaultFacetLimit = 10
+
+// parseFacetsParam reads a comma-separated list of categories named as in TagCategories,
+// dropping unknown and repeated names
+func parseFacetsParam(value string) []string {
+	var facets []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := TagCategories[name]; ok && !seen[name] {
+			seen[name] = true
+			facets = append(facets, name)
+		}
+	}
+	return facets
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // FilterCondition represents a SQL filter condition with its arguments
@@ -12,9 +13,10 @@ type FilterCondition struct {
 }
 
 // BuildTagFilterCondition creates a SQL condition for filtering images by tags
-// category: 'character', 'general', 'rating', etc.
+// category: 'character', 'general', 'rating', etc., empty matches tags of every category
 // include: if true, creates an IN condition; if false, creates a NOT IN condition
-func BuildTagFilterCondition(tags []string, category string, include bool) FilterCondition {
+// minConfidence: if above zero, tagger tags scored below it are treated as absent
+func BuildTagFilterCondition(tags []string, category string, include bool, minConfidence float64) FilterCondition {
 	if len(tags) == 0 {
 		return FilterCondition{}
 	}
@@ -28,19 +30,23 @@ func BuildTagFilterCondition(tags []string, category string, include bool) Filte
 		operator = "NOT IN"
 	}
 
+	categoryClause := ""
+	if category != "" {
+		categoryClause = fmt.Sprintf(" AND t.category = '%s'", category)
+	}
+
 	// Build the SQL condition
 	sql := fmt.Sprintf(`
 		images.id %s (
 			SELECT DISTINCT it.image_id 
 			FROM image_tags it 
 			JOIN tags t ON it.tag_id = t.id 
-			WHERE t.name IN (%s) AND t.category = '%s'
-		)`, operator, placeholders, category)
+			WHERE %s%s%s
+		)`, operator, TagNameClause("t", placeholders), categoryClause, ConfidenceClause("it", minConfidence))
 
-	// Convert tags to interface{} slice for SQL args
-	args := make([]interface{}, len(tags))
-	for i, tag := range tags {
-		args[i] = tag
+	args := TagNameArgs(tags)
+	if minConfidence > 0 {
+		args = append(args, minConfidence)
 	}
 
 	return FilterCondition{
@@ -50,50 +56,196 @@ func BuildTagFilterCondition(tags []string, category string, include bool) Filte
 }
 
 // BuildCharacterFilterCondition creates a filter condition for character tags
-func BuildCharacterFilterCondition(characters []string, include bool) FilterCondition {
-	return BuildTagFilterCondition(characters, "character", include)
+func BuildCharacterFilterCondition(characters []string, include bool, minConfidence float64) FilterCondition {
+	return BuildTagFilterCondition(characters, "character", include, minConfidence)
 }
+
 // BuildGeneralTagFilterCondition creates a filter condition for general tags
-func BuildGeneralTagFilterCondition(tags []string, include bool) FilterCondition {
-	return BuildTagFilterCondition(tags, "general", include)
+func BuildGeneralTagFilterCondition(tags []string, include bool, minConfidence float64) FilterCondition {
+	return BuildTagFilterCondition(tags, "general", include, minConfidence)
 }
 
 // BuildExplicitnessFilterCondition is a wrapper for BuildTagFilterCondition for explicitness (rating) tags
-func BuildExplicitnessFilterCondition(explicitnessLevels []string, include bool) FilterCondition {
+func BuildExplicitnessFilterCondition(explicitnessLevels []string, include bool, minConfidence float64) FilterCondition {
 	ratingTags := MapExplicitnessToTags(explicitnessLevels)
-	return BuildTagFilterCondition(ratingTags, "rating", include)
+	return BuildTagFilterCondition(ratingTags, "rating", include, minConfidence)
 }
 
 // BuildSeriesFilterCondition is a wrapper for BuildTagFilterCondition for series (copyright) tags
-func BuildSeriesFilterCondition(series []string, include bool) FilterCondition {
-	return BuildTagFilterCondition(series, "copyright", include)
+func BuildSeriesFilterCondition(series []string, include bool, minConfidence float64) FilterCondition {
+	return BuildTagFilterCondition(series, "copyright", include, minConfidence)
 }
 
 // BuildArtistFilterCondition is a wrapper for BuildTagFilterCondition for artist tags
-func BuildArtistFilterCondition(artists []string, include bool) FilterCondition {
-	return BuildTagFilterCondition(artists, "artist", include)
+func BuildArtistFilterCondition(artists []string, include bool, minConfidence float64) FilterCondition {
+	return BuildTagFilterCondition(artists, "artist", include, minConfidence)
+}
+
+// BuildImportedRangeFilterCondition keeps images imported at or after after and before before, either may be nil
+func BuildImportedRangeFilterCondition(after, before *time.Time) FilterCondition {
+	var parts []string
+	var args []interface{}
+	if after != nil {
+		parts = append(parts, "images.imported_at >= ?")
+		args = append(args, after.UTC())
+	}
+	if before != nil {
+		parts = append(parts, "images.imported_at < ?")
+		args = append(args, before.UTC())
+	}
+	if len(parts) == 0 {
+		return FilterCondition{}
+	}
+
+	return FilterCondition{
+		SQL:  "(" + strings.Join(parts, " AND ") + ")",
+		Args: args,
+	}
+}
+
+// BuildOriginalNameFilterCondition keeps images whose original filename contains name, ignoring case
+func BuildOriginalNameFilterCondition(name string) FilterCondition {
+	if name == "" {
+		return FilterCondition{}
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(name)
+	return FilterCondition{
+		SQL:  `images.original_filename LIKE ? ESCAPE '\'`,
+		Args: []interface{}{"%" + escaped + "%"},
+	}
 }
 
-// CombineFilterConditions combines multiple filter conditions into a single WHERE clause
-func CombineFilterConditions(conditions []FilterCondition) (string, []interface{}) {
-	if len(conditions) == 0 {
-		return "", nil
+// BuildFileSizeFilterCondition keeps images whose file size in bytes is within [minSize, maxSize], zero means unbounded
+func BuildFileSizeFilterCondition(minSize, maxSize int64) FilterCondition {
+	var parts []string
+	var args []interface{}
+	if minSize > 0 {
+		parts = append(parts, "images.file_size >= ?")
+		args = append(args, minSize)
+	}
+	if maxSize > 0 {
+		parts = append(parts, "images.file_size <= ?")
+		args = append(args, maxSize)
+	}
+	if len(parts) == 0 {
+		return FilterCondition{}
 	}
 
-	var sqlParts []string
-	var allArgs []interface{}
+	return FilterCondition{
+		SQL:  "(" + strings.Join(parts, " AND ") + ")",
+		Args: args,
+	}
+}
 
-	for _, condition := range conditions {
-		if condition.SQL != "" {
-			sqlParts = append(sqlParts, condition.SQL)
-			allArgs = append(allArgs, condition.Args...)
+// BuildDimensionFilterCondition keeps images whose width and height in pixels are within the bounds given,
+// zero means unbounded
+func BuildDimensionFilterCondition(minWidth, maxWidth, minHeight, maxHeight int) FilterCondition {
+	var parts []string
+	var args []interface{}
+	bounds := []struct {
+		column string
+		op     string
+		value  int
+	}{
+		{"images.width", ">=", minWidth},
+		{"images.width", "<=", maxWidth},
+		{"images.height", ">=", minHeight},
+		{"images.height", "<=", maxHeight},
+	}
+	for _, b := range bounds {
+		if b.value > 0 {
+			parts = append(parts, b.column+" "+b.op+" ?")
+			args = append(args, b.value)
 		}
 	}
+	if len(parts) == 0 {
+		return FilterCondition{}
+	}
 
-	if len(sqlParts) == 0 {
-		return "", nil
+	return FilterCondition{
+		SQL:  "(" + strings.Join(parts, " AND ") + ")",
+		Args: args,
+	}
+}
+
+// BuildMegapixelsFilterCondition keeps images with at least minMegapixels million pixels
+func BuildMegapixelsFilterCondition(minMegapixels float64) FilterCondition {
+	return FilterCondition{
+		SQL:  "images.width * images.height >= ?",
+		Args: []interface{}{int64(minMegapixels * 1e6)},
+	}
+}
+
+// BuildOrientationFilterCondition keeps portrait (taller than wide), landscape (wider than tall)
+// or square images
+func BuildOrientationFilterCondition(orientation string) FilterCondition {
+	switch orientation {
+	case "portrait":
+		return FilterCondition{SQL: "images.height > images.width"}
+	case "landscape":
+		return FilterCondition{SQL: "images.width > images.height"}
+	case "square":
+		return FilterCondition{SQL: "images.width = images.height"}
+	}
+	return FilterCondition{}
+}
+
+// BuildAspectFilterCondition keeps images whose width divided by height is within [minAspect, maxAspect],
+// zero means unbounded
+func BuildAspectFilterCondition(minAspect, maxAspect float64) FilterCondition {
+	var parts []string
+	var args []interface{}
+	if minAspect > 0 {
+		parts = append(parts, AspectSQL+" >= ?")
+		args = append(args, minAspect)
+	}
+	if maxAspect > 0 {
+		parts = append(parts, AspectSQL+" <= ?")
+		args = append(args, maxAspect)
+	}
+	if len(parts) == 0 {
+		return FilterCondition{}
 	}
 
-	whereClause := "WHERE " + strings.Join(sqlParts, " AND ")
-	return whereClause, allArgs
+	return FilterCondition{
+		SQL:  "(" + strings.Join(parts, " AND ") + ")",
+		Args: args,
+	}
+}
+
+// AspectSQL is an image's width divided by its height, NULL when the height is unknown
+const AspectSQL = "(CAST(images.width AS REAL) / NULLIF(images.height, 0))"
+
+// BuildNotDeletedFilterCondition keeps images that are not in the trash
+func BuildNotDeletedFilterCondition() FilterCondition {
+	return FilterCondition{SQL: "images.deleted_at IS NULL"}
+}
+
+// TagNameClause matches the tags on the tags alias given whose name, or one of whose aliases,
+// is in placeholders. The caller passes TagNameArgs for it.
+func TagNameClause(alias, placeholders string) string {
+	return fmt.Sprintf("(%s.name IN (%s) OR %s.id IN (SELECT tag_id FROM tag_aliases WHERE alias IN (%s)))",
+		alias, placeholders, alias, placeholders)
+}
+
+// TagNameArgs are the args of TagNameClause for names
+func TagNameArgs(names []string) []interface{} {
+	args := make([]interface{}, 0, len(names)*2+1)
+	for range 2 {
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+	return args
+}
+
+// ConfidenceClause returns an " AND ..." clause that hides tagger tags scored below minConfidence
+// on the image_tags alias given. Tags without a score (added by hand) always pass.
+// The caller appends minConfidence to its args when it is above zero.
+func ConfidenceClause(alias string, minConfidence float64) string {
+	if minConfidence <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" AND (%s.confidence IS NULL OR %s.confidence >= ?)", alias, alias)
 }
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MatchMode says how many entries of an include list an image needs:
+// any of them, all of them, or at least AtLeast of them
+type MatchMode struct {
+	All     bool
+	AtLeast int
+}
+
+var (
+	MatchAny = MatchMode{AtLeast: 1}
+	MatchAll = MatchMode{All: true}
+)
+
+// ParseMatchMode reads "any", "all" or a count such as "2", returning fallback for an empty value
+func ParseMatchMode(value string, fallback MatchMode) (MatchMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return fallback, nil
+	case "any":
+		return MatchAny, nil
+	case "all":
+		return MatchAll, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fallback, fmt.Errorf("match mode must be any, all or a count of at least 1, got %q", value)
+	}
+	return MatchMode{AtLeast: n}, nil
+}
+
+// Required returns how many of a list of n distinct entries an image needs
+func (m MatchMode) Required(n int) int {
+	if m.All {
+		return n
+	}
+	if m.AtLeast < 1 {
+		return 1
+	}
+	return m.AtLeast
+}
+
+func (m MatchMode) String() string {
+	switch {
+	case m.All:
+		return "all"
+	case m.AtLeast <= 1:
+		return "any"
+	}
+	return strconv.Itoa(m.AtLeast)
+}
+
+// parseMatchParam is ParseMatchMode for the query parameter name, defaulting to any.
+// An invalid value is added to invalid, see ImageQueryParams.Validate.
+func parseMatchParam(c *gin.Context, name string, invalid *[]error) MatchMode {
+	mode, err := ParseMatchMode(c.Query(name), MatchAny)
+	if err != nil {
+		*invalid = append(*invalid, fmt.Errorf("%s: %w", name, err))
+	}
+	return mode
+}
+
+// BuildTagMatchFilterCondition keeps images that have the number of tags the mode asks for
+// among tags of the given category, see BuildTagFilterCondition
+func BuildTagMatchFilterCondition(tags []string, category string, mode MatchMode, minConfidence float64) FilterCondition {
+	tags = distinct(tags)
+	if len(tags) == 0 || mode.Required(len(tags)) <= 1 {
+		return BuildTagFilterCondition(tags, category, true, minConfidence)
+	}
+
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(tags)), ",")
+	categoryClause := ""
+	if category != "" {
+		categoryClause = fmt.Sprintf(" AND t.category = '%s'", category)
+	}
+
+	args := TagNameArgs(tags)
+	if minConfidence > 0 {
+		args = append(args, minConfidence)
+	}
+
+	required := "?"
+	if mode.All {
+		required = requiredTagsSQL(len(tags), categoryClause)
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+	} else {
+		args = append(args, mode.AtLeast)
+	}
+
+	return FilterCondition{
+		SQL: fmt.Sprintf(`
+		images.id IN (
+			SELECT it.image_id
+			FROM image_tags it
+			JOIN tags t ON it.tag_id = t.id
+			WHERE %s%s%s
+			GROUP BY it.image_id
+			HAVING COUNT(DISTINCT t.id) >= %s
+		)`, TagNameClause("t", placeholders), categoryClause, ConfidenceClause("it", minConfidence), required),
+		Args: args,
+	}
+}
+
+// requiredTagsSQL counts the distinct tags n names stand for once aliases are resolved, so
+// an alias listed next to its tag is only required once. A name matching no tag of the
+// category still counts, nothing can have it. The caller passes the names as args.
+func requiredTagsSQL(n int, categoryClause string) string {
+	names := strings.TrimSuffix(strings.Repeat("SELECT ? AS name UNION ALL ", n), " UNION ALL ")
+	return fmt.Sprintf(`(
+				SELECT COUNT(DISTINCT COALESCE((
+					SELECT MIN(t.id) FROM tags t
+					WHERE (t.name = n.name OR t.id IN (SELECT tag_id FROM tag_aliases WHERE alias = n.name))%s
+				), 'missing:' || n.name))
+				FROM (%s) n
+			)`, categoryClause, names)
+}
+
+// BuildTagIDMatchFilterCondition is BuildTagMatchFilterCondition for tag IDs, as smart albums store them
+func BuildTagIDMatchFilterCondition(tagIDs []string, mode MatchMode, minConfidence float64) FilterCondition {
+	tagIDs = distinct(tagIDs)
+	if len(tagIDs) == 0 {
+		return FilterCondition{}
+	}
+
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(tagIDs)), ",")
+	args := make([]interface{}, 0, len(tagIDs)+2)
+	for _, id := range tagIDs {
+		args = append(args, id)
+	}
+	if minConfidence > 0 {
+		args = append(args, minConfidence)
+	}
+	args = append(args, mode.Required(len(tagIDs)))
+
+	return FilterCondition{
+		SQL: fmt.Sprintf(`
+		images.id IN (
+			SELECT it.image_id
+			FROM image_tags it
+			WHERE it.tag_id IN (%s)%s
+			GROUP BY it.image_id
+			HAVING COUNT(DISTINCT it.tag_id) >= ?
+		)`, placeholders, ConfidenceClause("it", minConfidence)),
+		Args: args,
+	}
+}
+
+// distinct drops repeated and empty entries, keeping the first of each
+func distinct(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
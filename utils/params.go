@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,6 +16,8 @@ type ImageQueryParams struct {
 	Limit               int
 	SortBy              string
 	Seed                string
+	Cursor              string  // token of a neighboring page, read instead of Page when set
+	MinConfidence       float64 // hide tagger tags scored below this, 0 shows everything
 	IncludeCharacters   string
 	ExcludeCharacters   string
 	IncludeTags         string
@@ -23,6 +28,36 @@ type ImageQueryParams struct {
 	ExcludeSeries       string
 	IncludeArtists      string
 	ExcludeArtists      string
+	ImportedAfter       *time.Time // inclusive
+	ImportedBefore      *time.Time // exclusive, a bare date includes that whole day
+	OriginalName        string     // substring of the original filename
+	MinFileSize         int64      // bytes, 0 for no minimum
+	MaxFileSize         int64      // bytes, 0 for no maximum
+	Color               *LabColor  // keeps images with a dominant color near this one, nil for any
+	ColorTolerance      float64    // CIELAB distance allowed from Color
+	Monochrome          *bool      // nil for both monochrome and colorful images
+	MinWidth            int        // pixels, 0 for no minimum
+	MaxWidth            int        // pixels, 0 for no maximum
+	MinHeight           int        // pixels, 0 for no minimum
+	MaxHeight           int        // pixels, 0 for no maximum
+	MinMegapixels       float64    // width times height in millions, 0 for no minimum
+	Orientation         string     // portrait, landscape or square, empty for any
+	MinAspect           float64    // width divided by height, 0 for no minimum
+	MaxAspect           float64    // width divided by height, 0 for no maximum
+	Query               string     // booru-style search such as "miku -solo width:>1920", see internal/search
+	// How many entries of each include list an image needs, read from *_match such as tags_match, any by default.
+	// The tags list of the by-tags endpoint has its own ?match=, which defaults to all.
+	CharactersMatch   MatchMode
+	TagsMatch         MatchMode
+	ExplicitnessMatch MatchMode
+	SeriesMatch       MatchMode
+	ArtistsMatch      MatchMode
+	// Categories, named as in TagCategories, whose most common tags are counted across the whole result
+	Facets     []string
+	FacetLimit int // tags listed per facet, at most 100
+
+	// invalid is the first parameter that did not parse, see Validate
+	invalid error
 }
 
 // ParseImageQueryParams extracts and validates all image query parameters from gin context
@@ -38,11 +73,21 @@ func ParseImageQueryParams(c *gin.Context) ImageQueryParams {
 		limit = 20
 	}
 
-	return ImageQueryParams{
+	var invalid []error
+	minAspect, maxAspect := parseAspectParams(c, &invalid)
+
+	facetLimit, _ := strconv.Atoi(c.Query("facet_limit"))
+	if facetLimit < 1 || facetLimit > 100 {
+		facetLimit = DefaultFacetLimit
+	}
+
+	params := ImageQueryParams{
 		Page:                page,
 		Limit:               limit,
 		SortBy:              c.DefaultQuery("sort", "random"),
 		Seed:                c.DefaultQuery("seed", ""),
+		Cursor:              c.Query("cursor"),
+		MinConfidence:       ParseMinConfidence(c),
 		IncludeCharacters:   c.DefaultQuery("include_characters", ""),
 		ExcludeCharacters:   c.DefaultQuery("exclude_characters", ""),
 		IncludeTags:         c.DefaultQuery("include_tags", ""),
@@ -53,7 +98,158 @@ func ParseImageQueryParams(c *gin.Context) ImageQueryParams {
 		ExcludeSeries:       c.DefaultQuery("exclude_series", ""),
 		IncludeArtists:      c.DefaultQuery("include_artists", ""),
 		ExcludeArtists:      c.DefaultQuery("exclude_artists", ""),
+		ImportedAfter:       parseDateParam(c.Query("imported_after"), false),
+		ImportedBefore:      parseDateParam(c.Query("imported_before"), true),
+		OriginalName:        strings.TrimSpace(c.Query("original_name")),
+		MinFileSize:         parseSizeParam(c.Query("min_size")),
+		MaxFileSize:         parseSizeParam(c.Query("max_size")),
+		Color:               parseColorParam(c.Query("color")),
+		ColorTolerance:      parseColorTolerance(c.Query("color_tolerance")),
+		Monochrome:          parseBoolParam(c.Query("monochrome")),
+		MinWidth:            parsePixelsParam(c.Query("min_width")),
+		MaxWidth:            parsePixelsParam(c.Query("max_width")),
+		MinHeight:           parsePixelsParam(c.Query("min_height")),
+		MaxHeight:           parsePixelsParam(c.Query("max_height")),
+		MinMegapixels:       parsePositiveFloat(c.Query("min_megapixels")),
+		Orientation:         parseOrientationParam(c.Query("orientation"), &invalid),
+		MinAspect:           minAspect,
+		MaxAspect:           maxAspect,
+		Query:               c.Query("q"),
+		CharactersMatch:     parseMatchParam(c, "characters_match", &invalid),
+		TagsMatch:           parseMatchParam(c, "tags_match", &invalid),
+		ExplicitnessMatch:   parseMatchParam(c, "explicitness_match", &invalid),
+		SeriesMatch:         parseMatchParam(c, "series_match", &invalid),
+		ArtistsMatch:        parseMatchParam(c, "artists_match", &invalid),
+		Facets:              parseFacetsParam(c.Query("facets")),
+		FacetLimit:          facetLimit,
+	}
+	if len(invalid) > 0 {
+		params.invalid = invalid[0]
+	}
+	return params
+}
+
+// Validate reports parameters that did not parse and filters that can never match.
+// Handlers answer 400 with it rather than quietly returning a differently filtered list.
+func (p ImageQueryParams) Validate() error {
+	if p.invalid != nil {
+		return p.invalid
+	}
+
+	// An image has a single explicitness level
+	_, _, _, _, includeExplicitness, _, _, _, _, _ := ParseFilterArrays(p)
+	if levels := len(distinct(includeExplicitness)); levels > 1 && p.ExplicitnessMatch.Required(levels) > 1 {
+		return fmt.Errorf("explicitness_match: an image has one explicitness level, so %s of %d levels can never match", p.ExplicitnessMatch, levels)
+	}
+	return nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps or YYYY-MM-DD dates, returning nil for anything else.
+// With endOfDay a bare date moves to the start of the next day so it can be used as an exclusive bound.
+func parseDateParam(value string, endOfDay bool) *time.Time {
+	if value == "" {
+		return nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t
+}
+
+func parseSizeParam(value string) int64 {
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+func parsePixelsParam(value string) int {
+	pixels, err := strconv.Atoi(value)
+	if err != nil || pixels < 0 {
+		return 0
+	}
+	return pixels
+}
+
+func parsePositiveFloat(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
+
+// parseOrientationParam adds anything but portrait, landscape, square or nothing to invalid
+func parseOrientationParam(value string, invalid *[]error) string {
+	switch value {
+	case "", "portrait", "landscape", "square":
+		return value
 	}
+	*invalid = append(*invalid, fmt.Errorf("orientation: %q is not portrait, landscape or square", value))
+	return ""
+}
+
+// parseAspectParams reads the aspect range from min_aspect and max_aspect, or from aspect
+// with aspect_tolerance in percent (2 by default), so aspect=16:9 matches 16:9 within 2%.
+// Ratios are written as 16:9 or 1.78, anything else is added to invalid.
+func parseAspectParams(c *gin.Context, invalid *[]error) (minAspect, maxAspect float64) {
+	if c.Query("aspect") != "" {
+		ratio := parseRatioParam(c, "aspect", invalid)
+		tolerance := 2.0
+		if value := c.Query("aspect_tolerance"); value != "" {
+			t, err := strconv.ParseFloat(value, 64)
+			if err != nil || t < 0 || t >= 100 {
+				*invalid = append(*invalid, fmt.Errorf("aspect_tolerance: %q is not a percentage below 100", value))
+			} else {
+				tolerance = t
+			}
+		}
+		return ratio * (1 - tolerance/100), ratio * (1 + tolerance/100)
+	}
+	return parseRatioParam(c, "min_aspect", invalid), parseRatioParam(c, "max_aspect", invalid)
+}
+
+// parseRatioParam is parseRatio for the query parameter name, adding a set value that is not a ratio to invalid
+func parseRatioParam(c *gin.Context, name string, invalid *[]error) float64 {
+	value := c.Query(name)
+	ratio := parseRatio(value)
+	if value != "" && ratio == 0 {
+		*invalid = append(*invalid, fmt.Errorf("%s: %q is not a ratio such as 16:9 or 1.78", name, value))
+	}
+	return ratio
+}
+
+// parseRatio returns 0 for anything that is not a positive ratio
+func parseRatio(value string) float64 {
+	if w, h, ok := strings.Cut(value, ":"); ok {
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return 0
+		}
+		return width / height
+	}
+	return parsePositiveFloat(value)
+}
+
+// ParseMinConfidence reads the min_confidence query parameter, clamped to [0, 1]
+func ParseMinConfidence(c *gin.Context) float64 {
+	minConfidence, err := strconv.ParseFloat(c.DefaultQuery("min_confidence", "0"), 64)
+	if err != nil || minConfidence < 0 {
+		return 0
+	}
+	if minConfidence > 1 {
+		return 1
+	}
+	return minConfidence
 }
 
 // ParseFilterArrays converts comma-separated filter strings to trimmed string arrays
@@ -141,43 +337,79 @@ func BuildFilterConditionsFromParams(params ImageQueryParams) []FilterCondition
 	
 	// Character filters
 	if len(includeCharacters) > 0 {
-		filterConditions = append(filterConditions, BuildCharacterFilterCondition(includeCharacters, true))
+		filterConditions = append(filterConditions, BuildTagMatchFilterCondition(includeCharacters, "character", params.CharactersMatch, params.MinConfidence))
 	}
 	if len(excludeCharacters) > 0 {
-		filterConditions = append(filterConditions, BuildCharacterFilterCondition(excludeCharacters, false))
+		filterConditions = append(filterConditions, BuildCharacterFilterCondition(excludeCharacters, false, params.MinConfidence))
 	}
 	
 	// Tag filters
 	if len(includeTags) > 0 {
-		filterConditions = append(filterConditions, BuildGeneralTagFilterCondition(includeTags, true))
+		filterConditions = append(filterConditions, BuildTagMatchFilterCondition(includeTags, "general", params.TagsMatch, params.MinConfidence))
 	}
 	if len(excludeTags) > 0 {
-		filterConditions = append(filterConditions, BuildGeneralTagFilterCondition(excludeTags, false))
+		filterConditions = append(filterConditions, BuildGeneralTagFilterCondition(excludeTags, false, params.MinConfidence))
 	}
 	
 	// Explicitness filters
 	if len(includeExplicitness) > 0 {
-		filterConditions = append(filterConditions, BuildExplicitnessFilterCondition(includeExplicitness, true))
+		filterConditions = append(filterConditions, BuildTagMatchFilterCondition(MapExplicitnessToTags(includeExplicitness), "rating", params.ExplicitnessMatch, params.MinConfidence))
 	}
 	if len(excludeExplicitness) > 0 {
-		filterConditions = append(filterConditions, BuildExplicitnessFilterCondition(excludeExplicitness, false))
+		filterConditions = append(filterConditions, BuildExplicitnessFilterCondition(excludeExplicitness, false, params.MinConfidence))
 	}
 	
 	// Series filters
 	if len(includeSeries) > 0 {
-		filterConditions = append(filterConditions, BuildSeriesFilterCondition(includeSeries, true))
+		filterConditions = append(filterConditions, BuildTagMatchFilterCondition(includeSeries, "copyright", params.SeriesMatch, params.MinConfidence))
 	}
 	if len(excludeSeries) > 0 {
-		filterConditions = append(filterConditions, BuildSeriesFilterCondition(excludeSeries, false))
+		filterConditions = append(filterConditions, BuildSeriesFilterCondition(excludeSeries, false, params.MinConfidence))
 	}
 	
 	// Artist filters
 	if len(includeArtists) > 0 {
-		filterConditions = append(filterConditions, BuildArtistFilterCondition(includeArtists, true))
+		filterConditions = append(filterConditions, BuildTagMatchFilterCondition(includeArtists, "artist", params.ArtistsMatch, params.MinConfidence))
 	}
 	if len(excludeArtists) > 0 {
-		filterConditions = append(filterConditions, BuildArtistFilterCondition(excludeArtists, false))
+		filterConditions = append(filterConditions, BuildArtistFilterCondition(excludeArtists, false, params.MinConfidence))
+	}
+
+	// Provenance filters
+	if params.ImportedAfter != nil || params.ImportedBefore != nil {
+		filterConditions = append(filterConditions, BuildImportedRangeFilterCondition(params.ImportedAfter, params.ImportedBefore))
+	}
+	if params.OriginalName != "" {
+		filterConditions = append(filterConditions, BuildOriginalNameFilterCondition(params.OriginalName))
+	}
+	if params.MinFileSize > 0 || params.MaxFileSize > 0 {
+		filterConditions = append(filterConditions, BuildFileSizeFilterCondition(params.MinFileSize, params.MaxFileSize))
+	}
+
+	// Color filters
+	if params.Color != nil {
+		filterConditions = append(filterConditions, BuildColorFilterCondition(*params.Color, params.ColorTolerance))
 	}
+	if params.Monochrome != nil {
+		filterConditions = append(filterConditions, BuildMonochromeFilterCondition(*params.Monochrome))
+	}
+
+	// Dimension filters
+	if params.MinWidth > 0 || params.MaxWidth > 0 || params.MinHeight > 0 || params.MaxHeight > 0 {
+		filterConditions = append(filterConditions, BuildDimensionFilterCondition(params.MinWidth, params.MaxWidth, params.MinHeight, params.MaxHeight))
+	}
+	if params.MinMegapixels > 0 {
+		filterConditions = append(filterConditions, BuildMegapixelsFilterCondition(params.MinMegapixels))
+	}
+	if params.Orientation != "" {
+		filterConditions = append(filterConditions, BuildOrientationFilterCondition(params.Orientation))
+	}
+	if params.MinAspect > 0 || params.MaxAspect > 0 {
+		filterConditions = append(filterConditions, BuildAspectFilterCondition(params.MinAspect, params.MaxAspect))
+	}
+
+	// Images in the trash never show up in lists
+	filterConditions = append(filterConditions, BuildNotDeletedFilterCondition())
 	
 	return filterConditions
 }
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// Sort orders images by Key and then by images.id in the same direction, so pages stay
+// stable when the key ties. Key is never NULL, which keyset pagination relies on.
+type Sort struct {
+	Name  string
+	Key   string        // SQL expression
+	Args  []interface{} // args of Key
+	Desc  bool
+	Seed  string // of the shuffle, set for random sorts
+	Color string // sorted by, set for color sorts
+}
+
+type sortKey struct {
+	key  string
+	desc bool
+}
+
+// sorts are the sort parameters BuildSort knows besides random and color.
+// Missing values sort as if they were the smallest.
+var sorts = map[string]sortKey{
+	"date_asc":        {"COALESCE(images.imported_at, '')", false},
+	"date_desc":       {"COALESCE(images.imported_at, '')", true},
+	"modified_asc":    {"COALESCE(images.file_modified_at, '')", false},
+	"modified_desc":   {"COALESCE(images.file_modified_at, '')", true},
+	"size_asc":        {"COALESCE(images.file_size, -1)", false},
+	"size_desc":       {"COALESCE(images.file_size, -1)", true},
+	"name_asc":        {"COALESCE(images.original_filename, '') COLLATE NOCASE", false},
+	"name_desc":       {"COALESCE(images.original_filename, '') COLLATE NOCASE", true},
+	"rating_desc":     {"COALESCE(images.rating, -1)", true},
+	"rating_asc":      {"COALESCE(images.rating, -1)", false},
+	"likes_desc":      {"COALESCE(images.like_count, -1)", true},
+	"likes_asc":       {"COALESCE(images.like_count, -1)", false},
+	"resolution_desc": {"COALESCE(images.width * images.height, -1)", true},
+	"resolution_asc":  {"COALESCE(images.width * images.height, -1)", false},
+	"aspect_desc":     {"COALESCE(" + AspectSQL + ", -1)", true},
+	"aspect_asc":      {"COALESCE(" + AspectSQL + ", -1)", false},
+}
+
+// BuildSort returns the sort a sort parameter asks for, falling back to random.
+// Random sorts shuffle by seed, a new one when it is empty, so every page of a listing
+// and every endpoint given the same seed agree on the order.
+func BuildSort(sortBy, seed string) Sort {
+	if k, ok := sorts[sortBy]; ok {
+		return Sort{Name: sortBy, Key: k.key, Desc: k.desc}
+	}
+	if seed == "" {
+		seed = NewSeed()
+	}
+	return Sort{Name: "random", Key: "shuffle_rank(?, images.id)", Args: []interface{}{seed}, Seed: seed}
+}
+
+// BuildSortFromParams is BuildSort plus the sorts that need more than a seed:
+// sort=color orders by closeness to the color parameter.
+func BuildSortFromParams(params ImageQueryParams) Sort {
+	if params.SortBy == "color" && params.Color != nil {
+		return Sort{Name: "color", Key: colorSortKey(*params.Color), Color: params.Color.String()}
+	}
+	return BuildSort(params.SortBy, params.Seed)
+}
+
+// NewSeed returns a random shuffle seed
+func NewSeed() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Column is the select-list entry that reads the sort key as sort_key, it takes s.Args
+func (s Sort) Column() string {
+	return ", " + s.Key + " AS sort_key"
+}
+
+// Condition keeps the rows past p's cursor in the direction p reads, it is empty without a cursor
+func (s Sort) Condition(p Page) FilterCondition {
+	if p.Cursor == nil {
+		return FilterCondition{}
+	}
+
+	operator := ">"
+	if s.Desc != p.Cursor.Before {
+		operator = "<"
+	}
+
+	args := append([]interface{}{}, s.Args...)
+	args = append(args, p.Cursor.Key)
+	args = append(args, s.Args...)
+	args = append(args, p.Cursor.Key, p.Cursor.ID)
+	return FilterCondition{
+		SQL:  fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND images.id %[2]s ?))", s.Key, operator),
+		Args: args,
+	}
+}
+
+// OrderBy returns the ORDER BY for page p, to follow Column and Condition.
+// A page before a cursor is read backwards from it and turned around by FinishPage.
+func (s Sort) OrderBy(p Page) string {
+	desc := s.Desc
+	if p.Cursor != nil && p.Cursor.Before {
+		desc = !desc
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	return fmt.Sprintf("ORDER BY sort_key %[1]s, images.id %[1]s", direction)
+}
+
+// Matches reports whether c was made for s, a cursor only makes sense in the order it came from
+func (s Sort) Matches(c *Cursor) bool {
+	return c == nil || c.Sort == s.Name && c.Seed == s.Seed && c.Color == s.Color
+}
+
+func (s Sort) cursor(key interface{}, id int64, before bool) Cursor {
+	return Cursor{Sort: s.Name, Seed: s.Seed, Color: s.Color, Key: key, ID: id, Before: before}
+}